@@ -0,0 +1,254 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// Security schemes of the OpenAPI document, given to methods by the
+// authenticated and client traits
+const (
+	BearerAuth        = "bearerAuth"
+	ClientCredentials = "clientCredentials"
+)
+
+// Mounted returns the routes of e that are part of the API, leaving out the
+// not found handlers groups with middleware route everything under them to
+func Mounted(e *echo.Echo) []*echo.Route {
+	probe := echo.New()
+	probe.Group("/probe", func(next echo.HandlerFunc) echo.HandlerFunc { return next })
+	catchAll := map[string]bool{}
+	for _, r := range probe.Routes() {
+		catchAll[r.Name] = true
+	}
+
+	var routes []*echo.Route
+	for _, r := range e.Routes() {
+		if !catchAll[r.Name] && !strings.HasSuffix(r.Path, "/*") {
+			routes = append(routes, r)
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// OpenAPI describes routes as an OpenAPI 3 document, using what the spec
+// says about them. Routes the spec doesn't know about are listed with no
+// description. The spec can be nil, leaving only the routes.
+func (s *Spec) OpenAPI(routes []*echo.Route, version string) map[string]interface{} {
+	if s == nil {
+		s = &Spec{Types: map[string]*Type{}, Methods: map[string]*Method{}}
+	}
+
+	title := s.Title
+	if len(title) == 0 {
+		title = "Auth Service"
+	}
+
+	ref := func(name string) Schema {
+		return Schema{"$ref": "#/components/schemas/" + name}
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, r := range routes {
+		path := openAPIPath(r.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(r.Method)] = s.operation(r, s.Methods[r.Method+" "+r.Path], ref)
+	}
+
+	schemas := map[string]interface{}{}
+	for name, t := range s.Types {
+		schema := s.schema(t, 0, ref)
+		schema["title"] = name
+		schemas[name] = schema
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				BearerAuth: map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An access token from POST /auth/tokens. It can also be sent as the access_token query parameter or in the token cookie.",
+				},
+				ClientCredentials: map[string]interface{}{
+					"type":        "oauth2",
+					"description": "Clients registered through /clients get their tokens from the gateway.",
+					"flows": map[string]interface{}{
+						"clientCredentials": map[string]interface{}{
+							"tokenUrl": "/auth/tokens",
+							"scopes":   map[string]string{},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// operation describes route r, documented by m when the spec has it
+func (s *Spec) operation(r *echo.Route, m *Method, ref func(string) Schema) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": operationID(r),
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{"description": "Not described by the API spec"},
+		},
+	}
+
+	segments := strings.Split(strings.Trim(r.Path, "/"), "/")
+	if len(segments[0]) > 0 {
+		op["tags"] = []string{segments[0]}
+	}
+
+	var parameters []interface{}
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     strings.TrimPrefix(segment, ":"),
+				"in":       "path",
+				"required": true,
+				"schema":   Schema{"type": "string"},
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	if m == nil {
+		return op
+	}
+
+	if len(m.Description) > 0 {
+		op["summary"] = summary(m.Description)
+		op["description"] = m.Description
+	}
+
+	var security []interface{}
+	for _, trait := range m.Is {
+		switch trait {
+		case "authenticated":
+			security = append(security, map[string][]string{BearerAuth: {}})
+		case "client":
+			security = append(security, map[string][]string{ClientCredentials: {}})
+		}
+	}
+	if len(security) > 0 {
+		op["security"] = security
+	}
+
+	if len(m.Media) > 0 {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  s.content(m.Media, m.Body, ref),
+		}
+	}
+
+	if len(m.Responses) > 0 {
+		responses := map[string]interface{}{}
+		for status, r := range m.Responses {
+			description := r.Description
+			if len(description) == 0 {
+				description = http.StatusText(status)
+			}
+
+			response := map[string]interface{}{"description": description}
+			if len(r.Media) > 0 {
+				response["content"] = s.content(r.Media, r.Body, ref)
+			}
+			responses[strconv.Itoa(status)] = response
+		}
+		op["responses"] = responses
+	}
+
+	return op
+}
+
+// content describes a body of type t in each of the media types. Only JSON,
+// problems and forms have a type, anything else is binary.
+func (s *Spec) content(media []string, t *Type, ref func(string) Schema) map[string]interface{} {
+	content := map[string]interface{}{}
+	for _, m := range media {
+		schema := Schema{"type": "string", "format": "binary"}
+		if t != nil && (m == "application/json" || m == "application/problem+json" || m == "application/x-www-form-urlencoded") {
+			schema = s.schema(t, 0, ref)
+		}
+		content[m] = map[string]interface{}{"schema": schema}
+	}
+
+	return content
+}
+
+// openAPIPath turns /users/:id into /users/{id}
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + strings.TrimPrefix(part, ":") + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// operationID names an operation after its method and path, so GET
+// /users/:id/roles is getUsersByIdRoles
+func operationID(r *echo.Route) string {
+	id := strings.ToLower(r.Method)
+	for _, segment := range strings.Split(r.Path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			id += "By"
+			segment = strings.TrimPrefix(segment, ":")
+		}
+
+		for _, word := range strings.FieldsFunc(segment, func(c rune) bool { return c == '-' || c == '_' || c == '.' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+
+	return id
+}
+
+// summary is the first sentence of a description
+func summary(description string) string {
+	description = strings.TrimSpace(description)
+	if i := strings.Index(description, ". "); i > 0 {
+		return description[:i]
+	}
+
+	return strings.TrimSuffix(description, ".")
+}
@@ -0,0 +1,544 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api reads the RAML description of the service, so the request
+// schemas can be generated from it and the service checked against it.
+// Only the parts of RAML 1.0 the spec uses are understood.
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Spec is a loaded RAML document
+type Spec struct {
+	Title string
+	Types map[string]*Type
+
+	// Methods are keyed by "METHOD /path", with paths as the router
+	// writes them (/users/:id)
+	Methods map[string]*Method
+
+	traits map[string]interface{}
+}
+
+// Method is one method of a resource
+type Method struct {
+	Method      string
+	Path        string
+	Description string
+	Is          []string
+
+	// Body is the JSON or form request body, nil when none is declared
+	Body *Type
+
+	// Media are the media types the request body can be sent as
+	Media     []string
+	Responses map[int]*Response
+}
+
+// Response is a declared response of a method
+type Response struct {
+	Description string
+
+	// Body is the JSON body, nil when the response has none or it isn't JSON
+	Body  *Type
+	Media []string
+}
+
+// Type is a RAML type declaration. Type is either a built in type
+// (string, object, array...) or the name of a declared type.
+type Type struct {
+	Name                 string
+	Type                 string
+	Description          string
+	Items                *Type
+	Properties           []*Property
+	Enum                 []string
+	MinLength            *int
+	MaxLength            *int
+	MinProperties        *int
+	AdditionalProperties *bool
+}
+
+// Property is a property of an object type
+type Property struct {
+	Name     string
+	Required bool
+	Type     *Type
+}
+
+var methods = []string{"get", "post", "put", "patch", "delete", "head", "options"}
+
+// Load reads the RAML document in file, following its !include tags
+func Load(file string) (*Spec, error) {
+	doc, err := load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: not a RAML document", file)
+	}
+
+	s := &Spec{
+		Types:   map[string]*Type{},
+		Methods: map[string]*Method{},
+	}
+	s.Title, _ = root["title"].(string)
+	s.traits, _ = root["traits"].(map[string]interface{})
+
+	types, _ := root["types"].(map[string]interface{})
+	for name, decl := range types {
+		t, err := parseType(decl)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: %v", name, err)
+		}
+		t.Name = name
+		s.Types[name] = t
+	}
+
+	for key, value := range root {
+		if strings.HasPrefix(key, "/") {
+			if err := s.resource(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, m := range s.Methods {
+		for _, t := range m.types() {
+			if err := s.check(t); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", m.Method, m.Path, err)
+			}
+		}
+	}
+	for name, t := range s.Types {
+		if err := s.check(t); err != nil {
+			return nil, fmt.Errorf("type %s: %v", name, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Sorted returns the methods ordered by path, then method
+func (s *Spec) Sorted() []*Method {
+	sorted := make([]*Method, 0, len(s.Methods))
+	for _, m := range s.Methods {
+		sorted = append(sorted, m)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Method < sorted[j].Method
+	})
+
+	return sorted
+}
+
+// Resolve follows references to declared types, returning the declaration
+// a type is based on
+func (s *Spec) Resolve(t *Type) *Type {
+	for i := 0; t != nil && i < 32; i++ {
+		base, ok := s.Types[t.Type]
+		if !ok {
+			return t
+		}
+		t = base
+	}
+
+	return t
+}
+
+// check makes sure every type t refers to is declared
+func (s *Spec) check(t *Type) error {
+	if t == nil {
+		return nil
+	}
+
+	switch t.Type {
+	case "", "any", "string", "number", "integer", "boolean", "object", "array", "datetime", "date-only", "file", "nil":
+	default:
+		if _, ok := s.Types[t.Type]; !ok {
+			return fmt.Errorf("unknown type %s", t.Type)
+		}
+	}
+
+	if err := s.check(t.Items); err != nil {
+		return err
+	}
+
+	for _, p := range t.Properties {
+		if err := s.check(p.Type); err != nil {
+			return fmt.Errorf("%s: %v", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Method) types() []*Type {
+	types := []*Type{m.Body}
+	for _, r := range m.Responses {
+		types = append(types, r.Body)
+	}
+
+	return types
+}
+
+// resource reads the resource at path and the resources nested in it
+func (s *Spec) resource(path string, value interface{}) error {
+	decl, _ := value.(map[string]interface{})
+
+	for key, value := range decl {
+		if strings.HasPrefix(key, "/") {
+			if err := s.resource(path+key, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, name := range methods {
+			if key != name {
+				continue
+			}
+
+			m, err := s.parseMethod(strings.ToUpper(name), routePath(path), value)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", strings.ToUpper(name), path, err)
+			}
+			s.Methods[m.Method+" "+m.Path] = m
+		}
+	}
+
+	return nil
+}
+
+// routePath turns /users/{id} into /users/:id
+func routePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			parts[i] = ":" + strings.Trim(part, "{}")
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func (s *Spec) parseMethod(method, path string, value interface{}) (*Method, error) {
+	decl, _ := value.(map[string]interface{})
+
+	m := &Method{
+		Method:    method,
+		Path:      path,
+		Responses: map[int]*Response{},
+	}
+	m.Description, _ = decl["description"].(string)
+
+	traits, _ := decl["is"].([]interface{})
+	for _, trait := range traits {
+		if name, ok := trait.(string); ok {
+			m.Is = append(m.Is, name)
+		}
+	}
+
+	var err error
+	if m.Body, m.Media, err = parseBody(decl["body"]); err != nil {
+		return nil, fmt.Errorf("body: %v", err)
+	}
+
+	responses := map[string]interface{}{}
+	declared, _ := decl["responses"].(map[string]interface{})
+	for code, value := range declared {
+		responses[code] = value
+	}
+
+	// traits add the responses the method doesn't declare itself
+	for _, name := range m.Is {
+		trait, ok := s.traits[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unknown trait %s", name)
+		}
+
+		common, _ := trait["responses"].(map[string]interface{})
+		for code, value := range common {
+			if _, ok := responses[code]; !ok {
+				responses[code] = value
+			}
+		}
+	}
+
+	for code, value := range responses {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("response %s: not a status code", code)
+		}
+
+		r := &Response{}
+		if response, ok := value.(map[string]interface{}); ok {
+			r.Description, _ = response["description"].(string)
+			if r.Body, r.Media, err = parseBody(response["body"]); err != nil {
+				return nil, fmt.Errorf("response %d: %v", status, err)
+			}
+		}
+		m.Responses[status] = r
+	}
+
+	return m, nil
+}
+
+// parseBody returns the type of a JSON, problem or form body, and every
+// media type the body can be. Bodies of other media types have no type as
+// far as validation is concerned.
+func parseBody(value interface{}) (*Type, []string, error) {
+	decl, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, nil
+	}
+
+	var media []string
+	for key := range decl {
+		if strings.Contains(key, "/") {
+			media = append(media, key)
+		}
+	}
+	sort.Strings(media)
+
+	// the body declares its type directly, in the default media type
+	if len(media) == 0 {
+		t, err := parseType(decl)
+		return t, []string{"application/json"}, err
+	}
+
+	for _, key := range []string{"application/json", "application/problem+json", "application/x-www-form-urlencoded"} {
+		if body, ok := decl[key]; ok {
+			if body == nil {
+				return nil, media, nil
+			}
+			t, err := parseType(body)
+			return t, media, err
+		}
+	}
+
+	return nil, media, nil
+}
+
+func parseType(value interface{}) (*Type, error) {
+	switch decl := value.(type) {
+	case nil:
+		return &Type{Type: "any"}, nil
+	case string:
+		return typeExpression(decl), nil
+	case []interface{}:
+		// type: [Base] inherits from Base, only single inheritance is
+		// understood
+		if len(decl) != 1 {
+			return nil, fmt.Errorf("multiple inheritance is not supported")
+		}
+		return parseType(decl[0])
+	case map[string]interface{}:
+		return parseDeclaration(decl)
+	}
+
+	return nil, fmt.Errorf("unexpected %T", value)
+}
+
+// typeExpression reads type names and arrays of them, such as string[]
+func typeExpression(expr string) *Type {
+	expr = strings.TrimSpace(expr)
+	if strings.HasSuffix(expr, "[]") {
+		return &Type{
+			Type:  "array",
+			Items: typeExpression(strings.TrimSuffix(expr, "[]")),
+		}
+	}
+
+	return &Type{Type: expr}
+}
+
+func parseDeclaration(decl map[string]interface{}) (*Type, error) {
+	t := &Type{Type: "string"}
+	if _, ok := decl["properties"]; ok {
+		t.Type = "object"
+	}
+
+	if base, ok := decl["type"]; ok {
+		b, err := parseType(base)
+		if err != nil {
+			return nil, err
+		}
+		*t = *b
+	}
+
+	t.Description, _ = decl["description"].(string)
+
+	if items, ok := decl["items"]; ok {
+		var err error
+		if t.Items, err = parseType(items); err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+		t.Type = "array"
+	}
+
+	if values, ok := decl["enum"].([]interface{}); ok {
+		for _, v := range values {
+			t.Enum = append(t.Enum, fmt.Sprint(v))
+		}
+	}
+
+	var err error
+	for facet, target := range map[string]**int{
+		"minLength":     &t.MinLength,
+		"maxLength":     &t.MaxLength,
+		"minProperties": &t.MinProperties,
+	} {
+		if *target, err = intFacet(decl, facet); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := decl["additionalProperties"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("additionalProperties: not a boolean")
+		}
+		t.AdditionalProperties = &b
+	}
+
+	properties, _ := decl["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, err := parseProperty(name, properties[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		t.Properties = append(t.Properties, p)
+	}
+
+	return t, nil
+}
+
+// parseProperty reads a property, which like in RAML 1.0 is required unless
+// it says otherwise or its name ends in ?
+func parseProperty(name string, value interface{}) (*Property, error) {
+	p := &Property{Name: name, Required: true}
+	if strings.HasSuffix(name, "?") {
+		p.Name = strings.TrimSuffix(name, "?")
+		p.Required = false
+	}
+
+	if decl, ok := value.(map[string]interface{}); ok {
+		if required, ok := decl["required"].(bool); ok {
+			p.Required = required
+		}
+	}
+
+	var err error
+	p.Type, err = parseType(value)
+
+	return p, err
+}
+
+func intFacet(decl map[string]interface{}, name string) (*int, error) {
+	v, ok := decl[name]
+	if !ok {
+		return nil, nil
+	}
+
+	i, ok := v.(int)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an integer", name)
+	}
+
+	return &i, nil
+}
+
+// load reads a YAML file into maps, slices and scalars, replacing !include
+// tags with the content of the file they name
+func load(file string) (interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	return value(filepath.Dir(file), doc.Content[0])
+}
+
+func value(dir string, n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return value(dir, n.Alias)
+
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := value(dir, n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[n.Content[i].Value] = v
+		}
+		return m, nil
+
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := value(dir, c)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+
+	case yaml.ScalarNode:
+		if n.Tag == "!include" {
+			return load(filepath.Join(dir, n.Value))
+		}
+
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	return nil, nil
+}
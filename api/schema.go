@@ -0,0 +1,236 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RoutesFile maps "METHOD /path" to the name of the schema its request body
+// is validated against
+const RoutesFile = "routes.json"
+
+// Schema is a JSON Schema document
+type Schema map[string]interface{}
+
+var words = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+var separators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// SchemaName is the file name, without extension, of the schema of the
+// declared type name: TokenRequest is token-request
+func SchemaName(name string) string {
+	return strings.ToLower(words.ReplaceAllString(name, "$1-$2"))
+}
+
+// routeSchemaName names the schema of a body declared inline in a method
+func routeSchemaName(m *Method) string {
+	return strings.Trim(separators.ReplaceAllString(strings.ToLower(m.Method+m.Path), "-"), "-")
+}
+
+// Schemas returns the schema of every declared type and of every request
+// body declared inline, by schema name
+func (s *Spec) Schemas() map[string]Schema {
+	schemas := map[string]Schema{}
+
+	for name, t := range s.Types {
+		schema := s.Schema(t)
+		schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+		schema["title"] = name
+		schemas[SchemaName(name)] = schema
+	}
+
+	for _, m := range s.Methods {
+		if m.Body == nil {
+			continue
+		}
+
+		if _, ok := s.Types[m.Body.Type]; ok && m.Body.plain() {
+			continue
+		}
+
+		schema := s.Schema(m.Body)
+		schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+		schema["title"] = m.Method + " " + m.Path
+		schemas[routeSchemaName(m)] = schema
+	}
+
+	return schemas
+}
+
+// Routes returns the schema request bodies are validated against, by
+// "METHOD /path"
+func (s *Spec) Routes() map[string]string {
+	routes := map[string]string{}
+
+	for key, m := range s.Methods {
+		if m.Body == nil {
+			continue
+		}
+
+		if _, ok := s.Types[m.Body.Type]; ok && m.Body.plain() {
+			routes[key] = SchemaName(m.Body.Type)
+		} else {
+			routes[key] = routeSchemaName(m)
+		}
+	}
+
+	return routes
+}
+
+// Schema converts t to JSON Schema, inlining the declared types it uses
+func (s *Spec) Schema(t *Type) Schema {
+	return s.schema(t, 0, nil)
+}
+
+// schema converts t, using ref for the declared types t is exactly rather
+// than inlining them when it is set
+func (s *Spec) schema(t *Type, depth int, ref func(name string) Schema) Schema {
+	schema := Schema{}
+	if t == nil || depth > 16 {
+		return schema
+	}
+
+	if _, ok := s.Types[t.Type]; ok && ref != nil && t.plain() {
+		return ref(t.Type)
+	}
+
+	switch t.Type {
+	case "", "any":
+	case "datetime":
+		schema["type"] = "string"
+		schema["format"] = "date-time"
+	case "date-only", "file":
+		schema["type"] = "string"
+	case "nil":
+		schema["type"] = "null"
+	case "string", "number", "integer", "boolean", "object", "array":
+		schema["type"] = t.Type
+	default:
+		// a declared type, which t may add properties and facets to
+		for k, v := range s.schema(s.Types[t.Type], depth+1, ref) {
+			schema[k] = v
+		}
+	}
+
+	if len(t.Description) > 0 {
+		schema["description"] = t.Description
+	}
+
+	if t.Items != nil {
+		schema["items"] = s.schema(t.Items, depth+1, ref)
+	}
+
+	if len(t.Enum) > 0 {
+		schema["enum"] = t.Enum
+	}
+
+	if t.MinLength != nil {
+		schema["minLength"] = *t.MinLength
+	}
+	if t.MaxLength != nil {
+		schema["maxLength"] = *t.MaxLength
+	}
+	if t.MinProperties != nil {
+		schema["minProperties"] = *t.MinProperties
+	}
+	if t.AdditionalProperties != nil && !*t.AdditionalProperties {
+		schema["additionalProperties"] = false
+	}
+
+	if len(t.Properties) > 0 {
+		properties, _ := schema["properties"].(map[string]interface{})
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		required, _ := schema["required"].([]string)
+
+		for _, p := range t.Properties {
+			properties[p.Name] = s.schema(p.Type, depth+1, ref)
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+
+		schema["properties"] = properties
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+	}
+
+	return schema
+}
+
+// plain reports whether t is a reference to another type, adding nothing
+func (t *Type) plain() bool {
+	return len(t.Description) == 0 && t.Items == nil && len(t.Properties) == 0 && len(t.Enum) == 0 &&
+		t.MinLength == nil && t.MaxLength == nil && t.MinProperties == nil && t.AdditionalProperties == nil
+}
+
+// WriteSchemas writes every schema and the routes using them to dir,
+// removing the schemas written before that the spec no longer declares.
+// It returns the names of the files written.
+func (s *Spec) WriteSchemas(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	previous := map[string]bool{}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, RoutesFile)); err == nil {
+		routes := map[string]string{}
+		if json.Unmarshal(data, &routes) == nil {
+			for _, name := range routes {
+				previous[name] = true
+			}
+		}
+	}
+
+	var written []string
+	write := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		written = append(written, name)
+		return ioutil.WriteFile(filepath.Join(dir, name), append(data, '\n'), 0644)
+	}
+
+	schemas := s.Schemas()
+	for name, schema := range schemas {
+		if err := write(name+".json", schema); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range previous {
+		if _, ok := schemas[name]; !ok {
+			os.Remove(filepath.Join(dir, name+".json"))
+		}
+	}
+
+	if err := write(RoutesFile, s.Routes()); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(written)
+	return written, nil
+}
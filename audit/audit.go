@@ -0,0 +1,39 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Record appends an event to the audit trail. The trail is best effort so
+// failures are logged rather than returned.
+func Record(ctx context.Context, s storage.Store, e model.AuditEvent) {
+	e.ID = primitive.NewObjectID()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	if err := s.Audit().Record(ctx, e); err != nil {
+		logrus.Errorf("Audit: unable to record %s: %v", e.Type, err)
+	}
+}
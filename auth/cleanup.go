@@ -0,0 +1,57 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// RemoveExpiredTokens deletes the tokens that have expired. The gateway
+// expires its copies itself, and the reconciler removes any left behind.
+func RemoveExpiredTokens(ctx context.Context, s storage.Store) {
+	n, err := s.Tokens().DeleteExpired(ctx, time.Now())
+	if err != nil {
+		metrics.CleanupErrors.WithLabelValues("expired_tokens").Inc()
+		logrus.Errorf("Cleanup: unable to remove expired tokens: %v", err)
+		return
+	}
+
+	metrics.CleanupRemoved.WithLabelValues("expired_tokens").Add(float64(n))
+	if n > 0 {
+		logrus.Debugf("Cleanup: removed %d expired tokens", n)
+	}
+}
+
+// RemoveExpiredCodes deletes the devices whose code expired before anyone
+// linked them
+func RemoveExpiredCodes(ctx context.Context, s storage.Store) {
+	n, err := s.Devices().DeleteExpired(ctx, time.Now())
+	if err != nil {
+		metrics.CleanupErrors.WithLabelValues("expired_codes").Inc()
+		logrus.Errorf("Cleanup: unable to remove expired device codes: %v", err)
+		return
+	}
+
+	metrics.CleanupRemoved.WithLabelValues("expired_codes").Add(float64(n))
+	if n > 0 {
+		logrus.Debugf("Cleanup: removed %d devices with expired codes", n)
+	}
+}
@@ -14,21 +14,84 @@
 
 package auth
 
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/config"
+)
+
+// Names of the drivers that can be selected at startup
+const (
+	DriverNone = "none"
+	DriverKong = "kong"
+	DriverTyk  = "tyk"
+)
+
+// ErrNotFound is returned by drivers when the gateway has no such user or client
+var ErrNotFound = errors.New("not found")
+
+// ErrNotSupported is returned by drivers that can't perform an operation
+var ErrNotSupported = errors.New("not supported by the gateway driver")
+
+// ErrUnavailable is returned by drivers when the gateway can't be reached.
+// Tokens are still handed out and the driver issues them once it recovers.
+var ErrUnavailable = errors.New("gateway unavailable")
+
+// ErrNoPolicy is returned by drivers asked to issue a token that no policy
+// gives access to, rather than letting the gateway decide what it can call
+var ErrNoPolicy = errors.New("no policy applies to the token")
+
 // Driver interface
 type Driver interface {
-	RegisterRoutes()
+	RegisterRoutes(g *echo.Group)
+
+	CreateUser(ctx context.Context, user *User) (*User, error)
 
-	CreateUser(user *User) (*User, error)
+	GetUser(ctx context.Context, userID string) (*User, error)
 
-	GetUser(userID string) (*User, error)
+	DeleteUser(ctx context.Context, userID string) error
 
-	DeleteUser(userID string) error
+	CreateClient(ctx context.Context, client *Client) (*Client, error)
 
-	CreateClient(client *Client) (*Client, error)
+	GetClient(ctx context.Context, clientID string) (*Client, error)
 
-	GetClient(clientID string) (*Client, error)
+	DeleteClient(ctx context.Context, client *Client) error
+
+	Authorize(ctx context.Context, client *Client, user *User, scope string) (*Redirect, error)
+
+	IssueToken(ctx context.Context, token *Token) error
+
+	RevokeToken(ctx context.Context, token *Token) error
+}
+
+// Pinger is implemented by drivers that can check the gateway is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Services finds the instances of a service by name, such as in Consul
+type Services interface {
+	// Balance returns a function picking the host:port of one of the
+	// service's healthy instances for each call, and one to call once it
+	// is no longer needed
+	Balance(name string) (next func() string, stop func(), err error)
+}
 
-	DeleteClient(client *Client) error
+// NewDriver creates the driver selected by the gateway configuration,
+// finding the gateway in services when it is configured by service name
+func NewDriver(c config.Gateway, services Services) (Driver, error) {
+	switch c.Driver {
+	case "", DriverNone:
+		return NewNone(), nil
+	case DriverKong:
+		return NewKong(c.Kong)
+	case DriverTyk:
+		return NewTyk(c.Tyk, services)
+	}
 
-	Authorize(client *Client, user *User, scope string) (*Redirect, error)
+	return nil, fmt.Errorf("unknown auth driver %s", c.Driver)
 }
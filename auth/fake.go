@@ -0,0 +1,249 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Fake is an in-memory driver for tests. It behaves like a gateway, remembering
+// everything it is given, so tests can check what would have been sent.
+type Fake struct {
+	mu      sync.Mutex
+	users   map[string]*User
+	clients map[string]*Client
+	tokens  map[string]*Token
+
+	// Err is returned from every call when set
+	Err error
+}
+
+// NewFake creates an empty in-memory driver
+func NewFake() *Fake {
+	return &Fake{
+		users:   make(map[string]*User),
+		clients: make(map[string]*Client),
+		tokens:  make(map[string]*Token),
+	}
+}
+
+// RegisterRoutes allows the driver to add additional routes at boot time
+func (f *Fake) RegisterRoutes(g *echo.Group) {
+}
+
+// CreateUser stores the user, assigning it an id if it has none
+func (f *Fake) CreateUser(ctx context.Context, user *User) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	if len(user.ID) == 0 {
+		user.ID = uuid.NewV4().String()
+	}
+
+	if _, ok := f.users[user.ID]; ok {
+		return nil, fmt.Errorf("user %s already exists", user.ID)
+	}
+
+	u := *user
+	f.users[user.ID] = &u
+
+	return user, nil
+}
+
+// GetUser looks up a stored user
+func (f *Fake) GetUser(ctx context.Context, userID string) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	user := *u
+	return &user, nil
+}
+
+// DeleteUser removes a stored user and any tokens issued to it
+func (f *Fake) DeleteUser(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	if _, ok := f.users[userID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(f.users, userID)
+	for k, t := range f.tokens {
+		if t.UserID == userID {
+			delete(f.tokens, k)
+		}
+	}
+
+	return nil
+}
+
+// CreateClient stores the client with generated credentials
+func (f *Fake) CreateClient(ctx context.Context, client *Client) (*Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	client.ClientID = uuid.NewV4().String()
+	client.ClientSecret = uuid.NewV4().String()
+
+	c := *client
+	f.clients[client.ClientID] = &c
+
+	return client, nil
+}
+
+// GetClient looks up a stored client
+func (f *Fake) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	c, ok := f.clients[clientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	client := *c
+	return &client, nil
+}
+
+// DeleteClient removes a stored client
+func (f *Fake) DeleteClient(ctx context.Context, client *Client) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	if _, ok := f.clients[client.ClientID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(f.clients, client.ClientID)
+
+	return nil
+}
+
+// Authorize grants any known client access for any known user
+func (f *Fake) Authorize(ctx context.Context, client *Client, user *User, scope string) (*Redirect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	c, ok := f.clients[client.ClientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if _, ok := f.users[user.ID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	return &Redirect{
+		RedirectURI: fmt.Sprintf("%s?code=%s", c.RedirectURI, uuid.NewV4().String()),
+	}, nil
+}
+
+// IssueToken stores the token
+func (f *Fake) IssueToken(ctx context.Context, token *Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	t := *token
+	f.tokens[token.Token] = &t
+
+	return nil
+}
+
+// RevokeToken removes a stored token
+func (f *Fake) RevokeToken(ctx context.Context, token *Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	if _, ok := f.tokens[token.Token]; !ok {
+		return ErrNotFound
+	}
+
+	delete(f.tokens, token.Token)
+
+	return nil
+}
+
+// Tokens returns a copy of every token currently issued
+func (f *Fake) Tokens() []Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tokens := make([]Token, 0, len(f.tokens))
+	for _, t := range f.tokens {
+		tokens = append(tokens, *t)
+	}
+
+	return tokens
+}
+
+// Users returns a copy of every user currently stored
+func (f *Fake) Users() []User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users := make([]User, 0, len(f.users))
+	for _, u := range f.users {
+		users = append(users, *u)
+	}
+
+	return users
+}
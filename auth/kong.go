@@ -15,72 +15,118 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
 	uuid "github.com/satori/go.uuid"
+
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/trace"
 )
 
+const kongRetries = 3
+
 type KongUser struct {
 	ID        string `json:"id"`
+	Username  string `json:"username"`
 	CustomID  string `json:"custom_id"`
 	CreatedAt int    `json:"created_at"`
 }
 
-type KongClientList struct {
-	Clients []*Client `json:"applications"`
+// KongClient is an oauth2 credential as described by the Admin API
+type KongClient struct {
+	ID           string   `json:"id,omitempty"`
+	ClientID     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	Consumer     *struct {
+		ID string `json:"id"`
+	} `json:"consumer,omitempty"`
+}
+
+// KongError is returned when Kong responds with an unexpected status
+type KongError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+}
+
+func (e *KongError) Error() string {
+	return fmt.Sprintf("kong: %d %s", e.StatusCode, e.Message)
 }
 
 // Kong is an authentication driver
 type Kong struct {
-	api    string
-	admin  string
-	key    string
-	client *http.Client
+	api          string
+	admin        string
+	key          string
+	keyHeader    string
+	provisionKey string
+	client       *http.Client
 }
 
 // NewKong creates a new instance of the Kong auth driver
-func NewKong() (*Kong, error) {
-	api := os.Getenv("KONG_API")
-	if len(api) == 0 {
+func NewKong(c config.Kong) (*Kong, error) {
+	if len(c.API) == 0 {
 		return nil, fmt.Errorf("KONG_API not set")
 	}
 
-	admin := os.Getenv("KONG_ADMIN")
-	if len(admin) == 0 {
+	if len(c.Admin) == 0 {
 		return nil, fmt.Errorf("KONG_ADMIN not set")
 	}
 
+	keyHeader := c.AccessHeader
+	if len(keyHeader) == 0 {
+		keyHeader = "Kong-Admin-Token"
+	}
+
 	return &Kong{
-		api:    api,
-		admin:  admin,
-		key:    os.Getenv("KONG_ACCESS_KEY"),
-		client: &http.Client{},
+		api:          c.API,
+		admin:        c.Admin,
+		key:          c.AccessKey,
+		keyHeader:    keyHeader,
+		provisionKey: c.ProvisionKey,
+		client: trace.NewClient(http.Client{
+			Timeout: time.Second * 10,
+		}),
 	}, nil
 }
 
+// Close closes the connections kept open to Kong. Calls in progress can
+// still finish.
+func (k *Kong) Close() error {
+	k.client.CloseIdleConnections()
+	return nil
+}
+
 // RegisterRoutes allows the driver to add additional routes at boot time
-func (k *Kong) RegisterRoutes() {
+func (k *Kong) RegisterRoutes(g *echo.Group) {
 }
 
 // CreateUser creates a new consumer
-func (k *Kong) CreateUser(user *User) (*User, error) {
-	user.ID = uuid.NewV4().String()
-
-	data := url.Values{}
-	data.Add("custom_id", user.ID)
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/consumers", k.admin), strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
+func (k *Kong) CreateUser(ctx context.Context, user *User) (*User, error) {
+	if len(user.ID) == 0 {
+		user.ID = uuid.NewV4().String()
 	}
 
-	if _, err := k.client.Do(req); err != nil {
+	err := k.do(ctx, "POST", k.admin+"/consumers", KongUser{
+		Username: user.ID,
+		CustomID: user.ID,
+	}, nil)
+	if e, ok := err.(*KongError); ok && e.StatusCode == http.StatusConflict {
+		// the consumer is already there, which is all we wanted
+		logrus.Debugf("Kong: consumer %s already exists", user.ID)
+		return user, nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -88,164 +134,233 @@ func (k *Kong) CreateUser(user *User) (*User, error) {
 }
 
 // GetUser looks up a consumer
-func (k *Kong) GetUser(userID string) (*User, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/consumers/%s", k.admin, userID), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := k.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var result *KongUser
-	if err := json.Unmarshal(body, &result); err != nil {
+func (k *Kong) GetUser(ctx context.Context, userID string) (*User, error) {
+	result := &KongUser{}
+	if err := k.do(ctx, "GET", k.admin+"/consumers/"+url.PathEscape(userID), nil, result); err != nil {
 		return nil, err
 	}
 
 	return &User{
-		ID: result.ID,
+		ID: result.Username,
 	}, nil
 }
 
 // DeleteUser removes a consumer
-func (k *Kong) DeleteUser(userID string) error {
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/consumers/%s", k.admin, userID), nil)
-	if err != nil {
-		return err
-	}
-
-	if _, err := k.client.Do(req); err != nil {
-		return err
-	}
-
-	return nil
+func (k *Kong) DeleteUser(ctx context.Context, userID string) error {
+	return k.do(ctx, "DELETE", k.admin+"/consumers/"+url.PathEscape(userID), nil, nil)
 }
 
 // CreateClient creates a new application
-func (k *Kong) CreateClient(client *Client) (*Client, error) {
+func (k *Kong) CreateClient(ctx context.Context, client *Client) (*Client, error) {
 	if len(client.ConsumerID) == 0 {
 		return nil, fmt.Errorf("Kong requires consumer_id when creating a client")
 	}
 
-	data := url.Values{}
-	data.Add("name", client.Name)
-	data.Add("redirect_uri", client.RedirectURI)
+	c := KongClient{
+		Name: client.Name,
+	}
+	if len(client.RedirectURI) > 0 {
+		c.RedirectURIs = []string{client.RedirectURI}
+	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/consumers/%s/oauth2", k.admin, client.ConsumerID), strings.NewReader(data.Encode()))
-	if err != nil {
+	result := &KongClient{}
+	if err := k.do(ctx, "POST", fmt.Sprintf("%s/consumers/%s/oauth2", k.admin, url.PathEscape(client.ConsumerID)), c, result); err != nil {
 		return nil, err
 	}
 
-	resp, err := k.client.Do(req)
-	if err != nil {
+	client.ClientID = result.ClientID
+	client.ClientSecret = result.ClientSecret
+
+	return client, nil
+}
+
+// GetClient looks up an application, client_id being the endpoint key of
+// oauth2 credentials as list endpoints no longer filter on it
+func (k *Kong) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	c := &KongClient{}
+	if err := k.do(ctx, "GET", k.admin+"/oauth2/"+url.PathEscape(clientID), nil, c); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	client := &Client{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Name:         c.Name,
 	}
 
-	var result *Client
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
+	if len(c.RedirectURIs) > 0 {
+		client.RedirectURI = c.RedirectURIs[0]
 	}
 
-	client.ClientID = result.ClientID
-	client.ClientSecret = result.ClientSecret
+	if c.Consumer != nil {
+		client.ConsumerID = c.Consumer.ID
+	}
 
 	return client, nil
 }
 
-// GetClient looks up an application
-func (k *Kong) GetClient(clientID string) (*Client, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/oauth2", k.admin), nil)
-	if err != nil {
-		return nil, err
+// DeleteClient deletes a client
+func (k *Kong) DeleteClient(ctx context.Context, client *Client) error {
+	if len(client.ConsumerID) == 0 {
+		return fmt.Errorf("Kong requires consumer_id when deleting a client")
 	}
 
-	q := req.URL.Query()
-	q.Add("client_id", clientID)
-	req.URL.RawQuery = q.Encode()
+	return k.do(ctx, "DELETE", fmt.Sprintf("%s/consumers/%s/oauth2/%s", k.admin, url.PathEscape(client.ConsumerID), url.PathEscape(client.ClientID)), nil, nil)
+}
 
-	resp, err := k.client.Do(req)
-	if err != nil {
-		return nil, err
+// Authorize attempts to authenticate a user
+func (k *Kong) Authorize(ctx context.Context, client *Client, user *User, scope string) (*Redirect, error) {
+	if len(k.provisionKey) == 0 {
+		return nil, fmt.Errorf("KONG_PROVISION_KEY not set")
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+
+	data := map[string]string{
+		"client_id":            client.ClientID,
+		"response_type":        "code",
+		"scope":                scope,
+		"provision_key":        k.provisionKey,
+		"authenticated_userid": user.ID,
 	}
 
-	var result *KongClientList
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
+	headers := http.Header{}
+	if len(client.Host) > 0 {
+		headers.Set("Host", client.Host)
 	}
 
-	if len(result.Clients) != 1 {
-		return nil, fmt.Errorf("Client not found for %s", clientID)
+	redirect := &Redirect{}
+	if err := k.send(ctx, "POST", k.api+"/oauth2/authorize", data, redirect, headers); err != nil {
+		return nil, err
 	}
 
-	return result.Clients[0], nil
+	return redirect, nil
 }
 
-// DeleteClient deletes a client
-func (k *Kong) DeleteClient(client *Client) error {
-	if len(client.ConsumerID) == 0 {
-		return fmt.Errorf("Kong requires consumer_id when creating a client")
+// IssueToken adds the token as a key-auth credential of the consumer
+func (k *Kong) IssueToken(ctx context.Context, token *Token) error {
+	err := k.do(ctx, "POST", fmt.Sprintf("%s/consumers/%s/key-auth", k.admin, url.PathEscape(token.UserID)), map[string]string{
+		"key": token.Token,
+	}, nil)
+	if e, ok := err.(*KongError); ok && e.StatusCode == http.StatusConflict {
+		// an earlier attempt got through if the key is the consumer's, Kong
+		// doesn't find it under the consumer when it is someone else's
+		owned := k.do(ctx, "GET", fmt.Sprintf("%s/consumers/%s/key-auth/%s", k.admin, url.PathEscape(token.UserID), url.PathEscape(token.Token)), nil, nil)
+		if owned == ErrNotFound {
+			return err
+		}
+
+		return owned
 	}
 
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/consumers/%s/oauth2", k.admin, client.ConsumerID), nil)
-	if err != nil {
-		return err
+	return err
+}
+
+// RevokeToken removes the consumer's key-auth credential
+func (k *Kong) RevokeToken(ctx context.Context, token *Token) error {
+	return k.do(ctx, "DELETE", fmt.Sprintf("%s/consumers/%s/key-auth/%s", k.admin, url.PathEscape(token.UserID), url.PathEscape(token.Token)), nil, nil)
+}
+
+// Ping checks the admin API is reachable
+func (k *Kong) Ping(ctx context.Context) error {
+	return k.do(ctx, "GET", k.admin+"/status", nil, nil)
+}
+
+func (k *Kong) do(ctx context.Context, method, endpoint string, body interface{}, v interface{}) error {
+	return k.send(ctx, method, endpoint, body, v, nil)
+}
+
+// send makes an authenticated JSON request to Kong, retrying server errors,
+// and decodes the response into v
+func (k *Kong) send(ctx context.Context, method, endpoint string, body interface{}, v interface{}, headers http.Header) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
 	}
 
-	_, err = k.client.Do(req)
-	if err != nil {
-		return err
+	var err error
+	for attempt := 0; attempt < kongRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(100<<uint(attempt)) * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			logrus.Debugf("Kong: retrying %s %s: %v", method, endpoint, err)
+		}
+
+		var retry bool
+		retry, err = k.attempt(ctx, method, endpoint, data, v, headers)
+		if !retry {
+			return err
+		}
 	}
 
-	return nil
+	return err
 }
 
-// Authorize attempts to authenticate a user
-func (k *Kong) Authorize(client *Client, user *User, scope string) (*Redirect, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/oauth2/authorize", k.api), nil)
+// attempt makes a single request, reporting whether it is worth retrying
+func (k *Kong) attempt(ctx context.Context, method, endpoint string, data []byte, v interface{}, headers http.Header) (bool, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, body)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	req = req.WithContext(ctx)
 
-	req.Header.Add("host", client.Host)
+	for name := range headers {
+		req.Header.Set(name, headers.Get(name))
+	}
+	if host := headers.Get("Host"); len(host) > 0 {
+		req.Host = host
+	}
 
-	q := req.URL.Query()
-	q.Add("user_id", user.ID)
-	req.URL.RawQuery = q.Encode()
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if len(k.key) > 0 {
+		req.Header.Set(k.keyHeader, k.key)
+	}
+
+	// we can't know if a failed POST reached Kong so only retry it when Kong
+	// tells us it failed
+	idempotent := method == "GET" || method == "DELETE"
 
 	resp, err := k.client.Do(req)
 	if err != nil {
-		return nil, err
+		return idempotent, err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	result, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return idempotent, err
 	}
 
-	var redirect *Redirect
-	if err := json.Unmarshal(body, &redirect); err != nil {
-		return nil, err
+	if resp.StatusCode == http.StatusNotFound {
+		return false, ErrNotFound
 	}
 
-	return redirect, nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &KongError{
+			StatusCode: resp.StatusCode,
+		}
+		if json.Unmarshal(result, e) != nil || len(e.Message) == 0 {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+
+		return resp.StatusCode >= 500, e
+	}
+
+	if v == nil || len(result) == 0 {
+		return false, nil
+	}
+
+	return false, json.Unmarshal(result, v)
 }
@@ -0,0 +1,244 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2-IMMERSE/auth-service/auth/kongtest"
+	"github.com/2-IMMERSE/auth-service/config"
+)
+
+// newTestKong starts a stand-in Kong and a driver using it
+func newTestKong(t *testing.T) (*Kong, *kongtest.Server) {
+	s := kongtest.NewServer("admin-token", "provision-key")
+
+	d, err := NewKong(config.Kong{
+		API:          s.URL,
+		Admin:        s.URL,
+		AccessKey:    "admin-token",
+		ProvisionKey: "provision-key",
+	})
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+
+	return d, s
+}
+
+func TestKongConsumers(t *testing.T) {
+	d, s := newTestKong(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := d.CreateUser(ctx, &User{ID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing consumer is what we wanted
+	if _, err := d.CreateUser(ctx, &User{ID: "user"}); err != nil {
+		t.Errorf("creating an existing consumer: %v", err)
+	}
+
+	u, err := d.GetUser(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "user" {
+		t.Errorf("got consumer %q, expected user", u.ID)
+	}
+
+	token := &Token{Token: "token", UserID: "user"}
+	if err := d.IssueToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.IssueToken(ctx, token); err != nil {
+		t.Errorf("issuing an existing token: %v", err)
+	}
+
+	if _, err := d.CreateUser(ctx, &User{ID: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.IssueToken(ctx, &Token{Token: "token", UserID: "other"}); err == nil {
+		t.Error("issued another consumer's key")
+	}
+	if err := d.RevokeToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RevokeToken(ctx, token); err != ErrNotFound {
+		t.Errorf("got %v revoking a revoked token, expected %v", err, ErrNotFound)
+	}
+
+	if err := d.DeleteUser(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetUser(ctx, "user"); err != ErrNotFound {
+		t.Errorf("got %v for a deleted consumer, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestKongClients(t *testing.T) {
+	d, s := newTestKong(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	const callback = "https://example.com/callback"
+
+	if _, err := d.CreateUser(ctx, &User{ID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.CreateClient(ctx, &Client{Name: "client"}); err == nil {
+		t.Error("created a client without a consumer")
+	}
+
+	created, err := d.CreateClient(ctx, &Client{Name: "client", RedirectURI: callback, ConsumerID: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kong lists every client whatever the querystring, so with more than
+	// one the lookup has to be by client_id
+	if _, err := d.CreateClient(ctx, &Client{Name: "other", ConsumerID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := d.GetClient(ctx, created.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ClientSecret != created.ClientSecret || c.RedirectURI != callback || c.Name != "client" {
+		t.Errorf("got %+v, expected the client as created", c)
+	}
+
+	tests := []struct {
+		name   string
+		client Client
+		err    bool
+	}{
+		{name: "known client", client: Client{ClientID: created.ClientID}},
+		{name: "unknown client", client: Client{ClientID: "unknown"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := d.Authorize(ctx, &test.client, &User{ID: "user"}, "")
+			if test.err {
+				if err == nil {
+					t.Errorf("authorized, redirecting to %s", r.RedirectURI)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(r.RedirectURI, callback+"?code=") {
+				t.Errorf("got redirect %s, expected a code for %s", r.RedirectURI, callback)
+			}
+		})
+	}
+
+	if err := d.DeleteClient(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetClient(ctx, created.ClientID); err != ErrNotFound {
+		t.Errorf("got %v for a deleted client, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestKongRetries(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		fail int
+		call func(d *Kong) error
+
+		// status is that of the error returned, 0 for none
+		status int
+		// left is how many failures are still to come
+		left int
+	}{
+		{name: "get", call: getUser},
+		{name: "get retried", fail: 2, call: getUser},
+		{name: "get exhausted", fail: 3, call: getUser, status: http.StatusServiceUnavailable},
+		{name: "get gives up", fail: 4, call: getUser, status: http.StatusServiceUnavailable, left: 1},
+		{name: "post retried", fail: 1, call: issueToken},
+		{name: "post exhausted", fail: 3, call: issueToken, status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, s := newTestKong(t)
+			defer s.Close()
+
+			if _, err := d.CreateUser(ctx, &User{ID: "user"}); err != nil {
+				t.Fatal(err)
+			}
+			s.FailNext = test.fail
+
+			err := test.call(d)
+
+			status := 0
+			if e, ok := err.(*KongError); ok {
+				status = e.StatusCode
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if status != test.status {
+				t.Errorf("got status %d, expected %d", status, test.status)
+			}
+			if s.FailNext != test.left {
+				t.Errorf("%d failures left, expected %d", s.FailNext, test.left)
+			}
+		})
+	}
+}
+
+func TestKongRetryCancelled(t *testing.T) {
+	d, s := newTestKong(t)
+	defer s.Close()
+	s.FailNext = kongRetries
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := d.GetUser(ctx, "user"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("took %s to give up, expected it to stop waiting with the context", elapsed)
+	}
+	if s.FailNext != kongRetries-1 {
+		t.Errorf("%d failures left, expected only the first attempt to be made", s.FailNext)
+	}
+}
+
+func getUser(d *Kong) error {
+	_, err := d.GetUser(context.Background(), "user")
+	return err
+}
+
+func issueToken(d *Kong) error {
+	return d.IssueToken(context.Background(), &Token{Token: "token", UserID: "user"})
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kongtest provides an in-memory stand-in for the parts of the Kong
+// Admin API and OAuth 2.0 plugin the Kong driver uses, for use in tests.
+package kongtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Server answers Admin API requests and the proxy's /oauth2/authorize
+type Server struct {
+	*httptest.Server
+
+	// Token is expected in the Kong-Admin-Token header of admin calls
+	Token string
+	// ProvisionKey is expected when authorizing
+	ProvisionKey string
+	// FailNext makes the next n requests fail with a 503
+	FailNext int
+
+	mu        sync.Mutex
+	next      int
+	Consumers map[string]map[string]interface{}
+	Clients   map[string]map[string]interface{}
+	Keys      map[string]string
+}
+
+// NewServer starts a stand-in accepting the given admin token
+func NewServer(token, provisionKey string) *Server {
+	s := &Server{
+		Token:        token,
+		ProvisionKey: provisionKey,
+		Consumers:    make(map[string]map[string]interface{}),
+		Clients:      make(map[string]map[string]interface{}),
+		Keys:         make(map[string]string),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+func (s *Server) id(prefix string) string {
+	s.next++
+	return fmt.Sprintf("%s-%d", prefix, s.next)
+}
+
+func (s *Server) consumer(idOrName string) map[string]interface{} {
+	for _, c := range s.Consumers {
+		if c["id"] == idOrName || c["username"] == idOrName {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.FailNext > 0 {
+		s.FailNext--
+		reply(w, http.StatusServiceUnavailable, map[string]string{"message": "Service unavailable"})
+		return
+	}
+
+	if r.URL.Path == "/oauth2/authorize" {
+		s.authorize(w, r)
+		return
+	}
+
+	if len(s.Token) > 0 && r.Header.Get("Kong-Admin-Token") != s.Token {
+		reply(w, http.StatusUnauthorized, map[string]string{"message": "Invalid credentials. Token or User credentials required"})
+		return
+	}
+
+	if r.Method == "POST" && r.Header.Get("Content-Type") != "application/json" {
+		reply(w, http.StatusUnsupportedMediaType, map[string]string{"message": "Unsupported Content-Type"})
+		return
+	}
+
+	body := map[string]interface{}{}
+	if r.Method == "POST" {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "consumers" && r.Method == "POST":
+		if s.consumer(fmt.Sprint(body["username"])) != nil {
+			reply(w, http.StatusConflict, map[string]string{"message": "UNIQUE violation detected on '{username=\"" + fmt.Sprint(body["username"]) + "\"}'"})
+			return
+		}
+		body["id"] = s.id("consumer")
+		s.Consumers[body["id"].(string)] = body
+		reply(w, http.StatusCreated, body)
+	case len(parts) == 1 && parts[0] == "oauth2" && r.Method == "GET":
+		// like Kong 1.0 and later, the querystring doesn't filter listings
+		data := []interface{}{}
+		for _, c := range s.Clients {
+			data = append(data, c)
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"data": data, "next": nil})
+	case len(parts) == 2 && parts[0] == "oauth2" && r.Method == "GET":
+		for id, c := range s.Clients {
+			if id == parts[1] || c["client_id"] == parts[1] {
+				reply(w, http.StatusOK, c)
+				return
+			}
+		}
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	case len(parts) >= 2 && parts[0] == "consumers":
+		c := s.consumer(parts[1])
+		if c == nil {
+			reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+			return
+		}
+		s.serveConsumer(w, r, c, parts[2:], body)
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	}
+}
+
+func (s *Server) serveConsumer(w http.ResponseWriter, r *http.Request, c map[string]interface{}, parts []string, body map[string]interface{}) {
+	consumerID := c["id"].(string)
+
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		reply(w, http.StatusOK, c)
+	case len(parts) == 0 && r.Method == "DELETE":
+		delete(s.Consumers, consumerID)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && parts[0] == "oauth2" && r.Method == "POST":
+		body["id"] = s.id("oauth2")
+		body["client_id"] = s.id("client")
+		body["client_secret"] = s.id("secret")
+		body["consumer"] = map[string]string{"id": consumerID}
+		s.Clients[body["id"].(string)] = body
+		reply(w, http.StatusCreated, body)
+	case len(parts) == 2 && parts[0] == "oauth2" && r.Method == "DELETE":
+		for id, cl := range s.Clients {
+			owner := cl["consumer"].(map[string]string)["id"]
+			if owner == consumerID && (cl["client_id"] == parts[1] || id == parts[1]) {
+				delete(s.Clients, id)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	case len(parts) == 1 && parts[0] == "key-auth" && r.Method == "POST":
+		key := fmt.Sprint(body["key"])
+		if _, ok := s.Keys[key]; ok {
+			reply(w, http.StatusConflict, map[string]string{"message": "UNIQUE violation detected on '{key=\"" + key + "\"}'"})
+			return
+		}
+		s.Keys[key] = consumerID
+		reply(w, http.StatusCreated, map[string]interface{}{"id": s.id("key"), "key": key, "consumer": map[string]string{"id": consumerID}})
+	case len(parts) == 2 && parts[0] == "key-auth" && r.Method == "GET":
+		if s.Keys[parts[1]] != consumerID {
+			reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"key": parts[1], "consumer": map[string]string{"id": consumerID}})
+	case len(parts) == 2 && parts[0] == "key-auth" && r.Method == "DELETE":
+		if s.Keys[parts[1]] != consumerID {
+			reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+			return
+		}
+		delete(s.Keys, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	}
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	body := map[string]string{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if body["provision_key"] != s.ProvisionKey {
+		reply(w, http.StatusBadRequest, map[string]string{"error": "invalid_provision_key", "error_description": "Invalid provision_key"})
+		return
+	}
+
+	for _, c := range s.Clients {
+		if c["client_id"] == body["client_id"] {
+			uris, _ := c["redirect_uris"].([]interface{})
+			if len(uris) == 0 {
+				break
+			}
+			reply(w, http.StatusOK, map[string]string{"redirect_uri": fmt.Sprintf("%s?code=%s", uris[0], s.id("code"))})
+			return
+		}
+	}
+
+	reply(w, http.StatusBadRequest, map[string]string{"error": "invalid_client", "error_description": "Invalid client authentication"})
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
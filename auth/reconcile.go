@@ -0,0 +1,269 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/2-IMMERSE/auth-service/audit"
+	"github.com/2-IMMERSE/auth-service/metrics"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Reconciler is implemented by drivers that keep their own copy of our tokens
+// and need to be brought back in line with the tokens collection periodically
+type Reconciler interface {
+	Reconcile(ctx context.Context, s storage.Store) (*ReconcileResult, error)
+
+	// IssuePending issues tokens handed out while the gateway was unavailable
+	IssuePending(ctx context.Context, s storage.Store) (int, error)
+}
+
+// ReconcileResult counts the drift found and fixed in a single run
+type ReconcileResult struct {
+	OrphanKeys    int
+	OrphanTokens  int
+	ExpiredTokens int
+	PendingTokens int
+}
+
+// pendingInterval is how often tokens waiting on the gateway are retried
+const pendingInterval = time.Second * 15
+
+// RunReconciler reconciles every interval, and issues pending tokens more
+// often, until the context is cancelled
+func RunReconciler(ctx context.Context, r Reconciler, s storage.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := time.NewTicker(pendingInterval)
+	defer pending.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pending.C:
+			n, err := r.IssuePending(ctx, s)
+
+			if err != nil && err != ErrUnavailable {
+				metrics.ReconcileErrors.WithLabelValues("issue_pending").Inc()
+				logrus.Errorf("Gateway: unable to issue pending tokens: %v", err)
+			}
+			metrics.ReconcileFixed.WithLabelValues("pending_tokens").Add(float64(n))
+		case <-ticker.C:
+			result, err := r.Reconcile(ctx, s)
+
+			metrics.ReconcileRuns.Inc()
+			if err != nil {
+				metrics.ReconcileErrors.WithLabelValues("reconcile").Inc()
+				logrus.Errorf("Gateway: reconcile failed: %v", err)
+				continue
+			}
+
+			metrics.ReconcileFixed.WithLabelValues("orphan_keys").Add(float64(result.OrphanKeys))
+			metrics.ReconcileFixed.WithLabelValues("orphan_tokens").Add(float64(result.OrphanTokens))
+			metrics.ReconcileFixed.WithLabelValues("expired_tokens").Add(float64(result.ExpiredTokens))
+			metrics.ReconcileFixed.WithLabelValues("pending_tokens").Add(float64(result.PendingTokens))
+			metrics.ReconcileLastDrift.Set(float64(result.OrphanKeys + result.OrphanTokens))
+
+			logrus.Debugf("Gateway: reconciled %+v", result)
+		}
+	}
+}
+
+// Reconcile compares the keys on the gateway with the tokens collection.
+// Expired tokens are removed from both, keys we issued that no longer have a
+// token are deleted from the gateway and tokens without a key are deleted
+// from our db as they can't be used. Pending tokens are issued first rather
+// than treated as orphans. With hash_keys enabled the gateway lists hashes
+// rather than the keys we created, so when none of our tokens is found no
+// orphans are removed instead of logging every user out.
+func (t *Tyk) Reconcile(ctx context.Context, s storage.Store) (*ReconcileResult, error) {
+	pending, err := t.IssuePending(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReconcileResult{
+		PendingTokens: pending,
+	}
+
+	// tokens issued while we are running may not be in the key list yet
+	started := time.Now().Add(-time.Minute)
+
+	keys, err := t.gateway.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	onGateway := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		onGateway[k] = true
+	}
+
+	now := time.Now()
+	known := make(map[string]bool)
+	orphans := []model.Token{}
+	found := 0
+
+	tokens, err := s.Tokens().All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if token.GatewayPending {
+			continue
+		}
+
+		known[token.Token] = true
+
+		switch {
+		case token.ExpiresAt.Before(now):
+			result.ExpiredTokens++
+			t.removeToken(ctx, s, token, "gateway.reconcile_expired")
+			if onGateway[token.Token] {
+				t.gateway.DeleteKey(ctx, token.Token)
+			}
+		case onGateway[token.Token]:
+			found++
+		case token.ID.Timestamp().Before(started):
+			orphans = append(orphans, token)
+		}
+	}
+
+	// a gateway that has none of our keys is hashing them, or isn't the one
+	// we issued them on, rather than every token being an orphan
+	if found == 0 && len(orphans) > 0 {
+		metrics.ReconcileErrors.WithLabelValues("orphan_tokens").Inc()
+		logrus.Warnf("Gateway: none of %d tokens is among the %d keys on the gateway, leaving them alone. Is hash_keys enabled?", len(orphans), len(keys))
+		return result, nil
+	}
+
+	for _, token := range orphans {
+		result.OrphanTokens++
+		t.removeToken(ctx, s, token, "gateway.reconcile_orphan_token")
+	}
+
+	for _, k := range keys {
+		if known[k] {
+			continue
+		}
+
+		// only remove keys we know we issued
+		session, err := t.gateway.GetKey(ctx, k)
+		if err != nil || session.MetaData["issuer"] != tykIssuer {
+			continue
+		}
+
+		if err := t.gateway.DeleteKey(ctx, k); err != nil {
+			logrus.Errorf("Gateway: unable to delete orphan key: %v", err)
+			continue
+		}
+
+		result.OrphanKeys++
+		audit.Record(ctx, s, model.AuditEvent{
+			Type:   "gateway.reconcile_orphan_key",
+			Source: "reconciler",
+			Token:  model.MaskToken(k),
+		})
+	}
+
+	return result, nil
+}
+
+// IssuePending creates keys for tokens handed out while the gateway was
+// unavailable. A token the gateway rejects is left pending for the next run
+// rather than holding up the rest, it only stops if the gateway goes away.
+// Tokens no policy applies to are removed, they would have been refused.
+func (t *Tyk) IssuePending(ctx context.Context, s storage.Store) (int, error) {
+	if !t.gateway.Available() {
+		return 0, ErrUnavailable
+	}
+
+	tokens, err := s.Tokens().Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	policies, err := s.Policies().All(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for _, token := range tokens {
+		if token.ExpiresAt.Before(time.Now()) {
+			t.removeToken(ctx, s, token, "gateway.pending_expired")
+			continue
+		}
+
+		u, err := s.Users().Get(ctx, token.User)
+		if err != nil {
+			t.removeToken(ctx, s, token, "gateway.pending_orphan")
+			continue
+		}
+
+		gt := NewToken(u, token)
+		gt.Policies = model.MatchPolicies(policies, u, token.Scope)
+		if err := t.IssueToken(ctx, gt); err == ErrUnavailable {
+			return issued, err
+		} else if err == ErrNoPolicy {
+			t.removeToken(ctx, s, token, "gateway.pending_no_policy")
+			continue
+		} else if err != nil {
+			metrics.ReconcileErrors.WithLabelValues("issue_pending").Inc()
+			logrus.Errorf("Gateway: unable to issue pending token %s: %v", model.MaskToken(token.Token), err)
+			continue
+		}
+
+		if err := s.Tokens().ClearPending(ctx, token.ID); err != nil {
+			return issued, err
+		}
+
+		issued++
+		audit.Record(ctx, s, model.AuditEvent{
+			Type:   "gateway.pending_issued",
+			Source: "reconciler",
+			User:   token.User,
+			Token:  model.MaskToken(token.Token),
+		})
+	}
+
+	return issued, nil
+}
+
+func (t *Tyk) removeToken(ctx context.Context, s storage.Store, token model.Token, event string) {
+	if err := s.Tokens().Delete(ctx, token.ID); err != nil && err != storage.ErrNotFound {
+		logrus.Errorf("Gateway: unable to remove token: %v", err)
+		return
+	}
+
+	audit.Record(ctx, s, model.AuditEvent{
+		Type:   event,
+		Source: "reconciler",
+		User:   token.User,
+		Token:  model.MaskToken(token.Token),
+	})
+}
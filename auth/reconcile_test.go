@@ -0,0 +1,150 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+)
+
+// addToken stores a token created age ago, issuing it on the gateway too
+// when issued is set
+func addToken(t *testing.T, d *Tyk, s storage.Store, token string, age, lifetime time.Duration, issued bool) {
+	ctx := context.Background()
+	created := time.Now().Add(-age)
+
+	m := model.Token{
+		ID:        primitive.NewObjectIDFromTimestamp(created),
+		Token:     token,
+		User:      primitive.NewObjectID(),
+		ExpiresAt: created.Add(lifetime),
+	}
+	if err := s.Tokens().Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if issued {
+		if err := d.IssueToken(ctx, &Token{Token: token, UserID: m.User.Hex(), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTykReconcile(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	store := memory.New()
+	ctx := context.Background()
+
+	addToken(t, d, store, "live", time.Hour, 2*time.Hour, true)
+	addToken(t, d, store, "orphan", time.Hour, 2*time.Hour, false)
+	addToken(t, d, store, "recent", 0, time.Hour, false)
+	addToken(t, d, store, "expired", 2*time.Hour, time.Hour, true)
+
+	// a key we issued whose token is gone, and one someone else issued
+	s.Keys["stray"] = json.RawMessage(`{"meta_data":{"issuer":"` + tykIssuer + `"}}`)
+	s.Keys["foreign"] = json.RawMessage(`{"meta_data":{}}`)
+
+	result, err := d.Reconcile(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *result != (ReconcileResult{OrphanKeys: 1, OrphanTokens: 1, ExpiredTokens: 1}) {
+		t.Errorf("got %+v, expected an orphan key, orphan token and expired token", result)
+	}
+
+	for token, kept := range map[string]bool{"live": true, "orphan": false, "recent": true, "expired": false} {
+		if _, err := store.Tokens().Get(ctx, token); (err == nil) != kept {
+			t.Errorf("token %s kept is %t, expected %t", token, err == nil, kept)
+		}
+	}
+
+	for key, kept := range map[string]bool{"live": true, "expired": false, "stray": false, "foreign": true} {
+		if _, ok := s.Keys[key]; ok != kept {
+			t.Errorf("key %s kept is %t, expected %t", key, ok, kept)
+		}
+	}
+}
+
+func TestTykReconcileHashedKeys(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	s.HashKeys = true
+	store := memory.New()
+	ctx := context.Background()
+
+	addToken(t, d, store, "first", time.Hour, 2*time.Hour, true)
+	addToken(t, d, store, "second", time.Hour, 2*time.Hour, true)
+
+	result, err := d.Reconcile(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.OrphanTokens != 0 || result.OrphanKeys != 0 {
+		t.Errorf("got %+v, expected nothing removed when the gateway lists hashes", result)
+	}
+
+	for _, token := range []string{"first", "second"} {
+		if _, err := store.Tokens().Get(ctx, token); err != nil {
+			t.Errorf("token %s removed: %v", token, err)
+		}
+		if _, ok := s.Keys[token]; !ok {
+			t.Errorf("key %s removed", token)
+		}
+	}
+}
+
+func TestTykIssuePendingNoPolicy(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	d.defaultPolicy = ""
+	store := memory.New()
+	ctx := context.Background()
+
+	u := model.User{ID: primitive.NewObjectID(), Email: "user@example.com"}
+	if err := store.Users().Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Tokens().Create(ctx, model.Token{
+		Token:          "pending",
+		User:           u.ID,
+		ExpiresAt:      time.Now().Add(time.Hour),
+		GatewayPending: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if issued, err := d.IssuePending(ctx, store); err != nil || issued != 0 {
+		t.Fatalf("got %d, %v, expected nothing issued", issued, err)
+	}
+
+	// removed rather than tried again on every run
+	if _, err := store.Tokens().Get(ctx, "pending"); err != storage.ErrNotFound {
+		t.Errorf("got %v for the token no policy applies to, expected %v", err, storage.ErrNotFound)
+	}
+	if _, ok := s.Keys["pending"]; ok {
+		t.Error("issued a key no policy applies to")
+	}
+}
@@ -14,6 +14,7 @@
 
 package auth
 
+// Redirect is where the user agent should be sent once a client is authorized
 type Redirect struct {
-
+	RedirectURI string `json:"redirect_uri"`
 }
@@ -0,0 +1,178 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Switch is a Driver whose gateway driver can be replaced, as when its
+// settings are reloaded, without interrupting calls already in progress
+type Switch struct {
+	current atomic.Value
+}
+
+// switched is a driver along with the handlers for the routes it registers
+type switched struct {
+	driver Driver
+	routes map[route]echo.HandlerFunc
+}
+
+type route struct {
+	method, path string
+}
+
+// NewSwitch returns a switch starting with d
+func NewSwitch(d Driver) *Switch {
+	s := &Switch{}
+	s.Set(d)
+	return s
+}
+
+// Driver returns the driver in use
+func (s *Switch) Driver() Driver {
+	return s.switched().driver
+}
+
+// Set replaces the driver for calls made from now on. It should be the same
+// kind of driver as before, the routes registered at startup are kept. The
+// driver replaced is closed, if it is an io.Closer, once calls in progress
+// can no longer pick it.
+func (s *Switch) Set(d Driver) {
+	// collect the handlers the driver registers so the routes registered
+	// at startup can send requests to whichever driver is current
+	e := echo.New()
+	g := e.Group("")
+
+	// creating the group adds routes of its own, which aren't the driver's
+	group := map[echo.Route]bool{}
+	for _, r := range e.Routes() {
+		group[*r] = true
+	}
+
+	d.RegisterRoutes(g)
+
+	routes := map[route]echo.HandlerFunc{}
+	for _, r := range e.Routes() {
+		if group[*r] {
+			continue
+		}
+
+		c := e.NewContext(nil, nil)
+		e.Router().Find(r.Method, r.Path, c)
+		routes[route{r.Method, r.Path}] = c.Handler()
+	}
+
+	old, _ := s.current.Swap(&switched{d, routes}).(*switched)
+	if old == nil || old.driver == d {
+		return
+	}
+
+	if c, ok := old.driver.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			logrus.Warnf("Gateway: unable to close the %T driver replaced: %v", old.driver, err)
+		}
+	}
+}
+
+func (s *Switch) switched() *switched {
+	return s.current.Load().(*switched)
+}
+
+// RegisterRoutes registers the routes of the current driver, handled by
+// whichever driver is current as requests arrive
+func (s *Switch) RegisterRoutes(g *echo.Group) {
+	for r := range s.switched().routes {
+		r := r
+		g.Add(r.method, r.path, func(c echo.Context) error {
+			if h, ok := s.switched().routes[r]; ok {
+				return h(c)
+			}
+
+			return echo.ErrNotFound
+		})
+	}
+}
+
+func (s *Switch) CreateUser(ctx context.Context, user *User) (*User, error) {
+	return s.Driver().CreateUser(ctx, user)
+}
+
+func (s *Switch) GetUser(ctx context.Context, userID string) (*User, error) {
+	return s.Driver().GetUser(ctx, userID)
+}
+
+func (s *Switch) DeleteUser(ctx context.Context, userID string) error {
+	return s.Driver().DeleteUser(ctx, userID)
+}
+
+func (s *Switch) CreateClient(ctx context.Context, client *Client) (*Client, error) {
+	return s.Driver().CreateClient(ctx, client)
+}
+
+func (s *Switch) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	return s.Driver().GetClient(ctx, clientID)
+}
+
+func (s *Switch) DeleteClient(ctx context.Context, client *Client) error {
+	return s.Driver().DeleteClient(ctx, client)
+}
+
+func (s *Switch) Authorize(ctx context.Context, client *Client, user *User, scope string) (*Redirect, error) {
+	return s.Driver().Authorize(ctx, client, user, scope)
+}
+
+func (s *Switch) IssueToken(ctx context.Context, token *Token) error {
+	return s.Driver().IssueToken(ctx, token)
+}
+
+func (s *Switch) RevokeToken(ctx context.Context, token *Token) error {
+	return s.Driver().RevokeToken(ctx, token)
+}
+
+// Reconcile reconciles with the current driver, if it is a Reconciler
+func (s *Switch) Reconcile(ctx context.Context, store storage.Store) (*ReconcileResult, error) {
+	if r, ok := s.Driver().(Reconciler); ok {
+		return r.Reconcile(ctx, store)
+	}
+
+	return nil, ErrNotSupported
+}
+
+// IssuePending issues pending tokens with the current driver, if it is a
+// Reconciler
+func (s *Switch) IssuePending(ctx context.Context, store storage.Store) (int, error) {
+	if r, ok := s.Driver().(Reconciler); ok {
+		return r.IssuePending(ctx, store)
+	}
+
+	return 0, ErrNotSupported
+}
+
+// Ping checks the gateway of the current driver, if it is a Pinger
+func (s *Switch) Ping(ctx context.Context) error {
+	if p, ok := s.Driver().(Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSwitchSetInFlight(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// next starts the driver switched to, has reports whether it was
+		// given a token and stop shuts it down
+		next func(t *testing.T) (d Driver, has func(token string) bool, stop func())
+	}{
+		{
+			name: "tyk",
+			next: func(t *testing.T) (Driver, func(string) bool, func()) {
+				d, s := newTestTyk(t)
+				return d, func(token string) bool { _, ok := s.Keys[token]; return ok }, s.Close
+			},
+		},
+		{
+			name: "kong",
+			next: func(t *testing.T) (Driver, func(string) bool, func()) {
+				d, s := newTestKong(t)
+				if _, err := d.CreateUser(ctx, &User{ID: "user"}); err != nil {
+					t.Fatal(err)
+				}
+				return d, func(token string) bool { _, ok := s.Keys[token]; return ok }, s.Close
+			},
+		},
+		{
+			name: "fake",
+			next: func(t *testing.T) (Driver, func(string) bool, func()) {
+				f := NewFake()
+				return f, func(token string) bool { return hasToken(f, token) }, func() {}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, s := newTestKong(t)
+			defer s.Close()
+			if _, err := first.CreateUser(ctx, &User{ID: "user"}); err != nil {
+				t.Fatal(err)
+			}
+
+			next, has, stop := test.next(t)
+			defer stop()
+
+			held := &heldDriver{Driver: first, entered: make(chan struct{}), release: make(chan struct{})}
+			sw := NewSwitch(held)
+
+			// the first call is held by the old driver while it is switched
+			done := make(chan error)
+			go func() {
+				done <- sw.IssueToken(ctx, &Token{Token: "before", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+			}()
+			<-held.entered
+
+			sw.Set(next)
+			if !held.closed {
+				t.Error("the old driver wasn't closed")
+			}
+
+			if err := sw.IssueToken(ctx, &Token{Token: "after", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+
+			close(held.release)
+			if err := <-done; err != nil {
+				t.Fatalf("call in flight: %v", err)
+			}
+
+			if _, ok := s.Keys["before"]; !ok {
+				t.Error("the call in flight didn't finish with the driver it started with")
+			}
+			if has("before") {
+				t.Error("the call in flight went to the new driver")
+			}
+			if !has("after") {
+				t.Error("the call after switching didn't go to the new driver")
+			}
+			if _, ok := s.Keys["after"]; ok {
+				t.Error("the call after switching went to the old driver")
+			}
+		})
+	}
+}
+
+func TestSwitchSetConcurrent(t *testing.T) {
+	a, b := NewFake(), NewFake()
+	sw := NewSwitch(a)
+	ctx := context.Background()
+
+	const calls = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- sw.IssueToken(ctx, &Token{Token: fmt.Sprint(i), UserID: "user"})
+		}(i)
+
+		if i%10 == 0 {
+			if i%20 == 0 {
+				sw.Set(b)
+			} else {
+				sw.Set(a)
+			}
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// every call went to one driver or the other
+	for i := 0; i < calls; i++ {
+		token := fmt.Sprint(i)
+		if hasToken(a, token) == hasToken(b, token) {
+			t.Errorf("token %s issued by both or neither driver", token)
+		}
+	}
+}
+
+// heldDriver holds calls to issue tokens until released, telling when one
+// has arrived
+type heldDriver struct {
+	Driver
+	entered, release chan struct{}
+	closed           bool
+}
+
+func (h *heldDriver) IssueToken(ctx context.Context, token *Token) error {
+	h.entered <- struct{}{}
+	<-h.release
+
+	return h.Driver.IssueToken(ctx, token)
+}
+
+func (h *heldDriver) Close() error {
+	h.closed = true
+	return h.Driver.(io.Closer).Close()
+}
+
+func hasToken(f *Fake, token string) bool {
+	for _, t := range f.Tokens() {
+		if t.Token == token {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Token is an access token issued to a user that the gateway needs to accept
+type Token struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"user_id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Roles of the user the token was issued to
+	Roles []string `json:"roles,omitempty"`
+
+	// Policies that apply to the token, highest priority first
+	Policies []model.Policy `json:"policies,omitempty"`
+}
+
+// NewToken describes a token in the form drivers expect
+func NewToken(u model.User, t model.Token) *Token {
+	gt := &Token{
+		Token:     t.Token,
+		UserID:    t.User.Hex(),
+		Scope:     t.Scope,
+		ExpiresAt: t.ExpiresAt,
+	}
+
+	if !u.ID.IsZero() {
+		gt.UserID = u.GatewayUserID()
+		gt.Roles = u.Roles
+	}
+
+	if !t.Client.IsZero() {
+		gt.ClientID = t.Client.Hex()
+	}
+
+	return gt
+}
+
+// RevokeUserTokens removes every token belonging to the user from both the
+// gateway and our db
+func RevokeUserTokens(ctx context.Context, store storage.Store, d Driver, u model.User) {
+	tokens, err := store.Tokens().ListByUser(ctx, u.ID)
+	if err != nil {
+		logrus.Errorf("Unable to find tokens for %s: %v", u.ID.Hex(), err)
+		return
+	}
+
+	for _, t := range tokens {
+		if err := d.RevokeToken(ctx, NewToken(u, t)); err != nil && err != ErrNotFound {
+			logrus.Errorf("Gateway: unable to revoke token: %v", err)
+		}
+
+		if err := store.Tokens().Delete(ctx, t.ID); err == nil {
+			metrics.TokensRevoked.Inc()
+		} else if err != storage.ErrNotFound {
+			logrus.Errorf("Unable to remove token for %s: %v", u.ID.Hex(), err)
+		}
+	}
+}
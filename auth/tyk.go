@@ -15,113 +15,557 @@
 package auth
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/gorilla/mux"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/2-IMMERSE/auth-service/audit"
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/metrics"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/trace"
+	"github.com/2-IMMERSE/auth-service/tyk"
+	"github.com/2-IMMERSE/auth-service/webhook"
+)
+
+// Tyk gateway events we reconcile, everything else is acknowledged and dropped
+const (
+	TykEventKeyExpired    = "KeyExpired"
+	TykEventQuotaExceeded = "QuotaExceeded"
+	TykEventTokenDeleted  = "TokenDeleted"
+	TykEventKeyDeleted    = "KeyDeleted"
 )
 
+// tykIssuer marks keys we created so the reconciler never touches anyone else's
+const tykIssuer = "2immerse-auth-service"
+
+// TykSecretHeader must be added to the webhook handler's header_map in the
+// Tyk API definition, set to the value of TYK_SHARED_SECRET
+const TykSecretHeader = "X-Tyk-Shared-Secret"
+
+// TykResponse is the envelope the dashboard API wraps most responses in
+type TykResponse struct {
+	Status  string          `json:"Status"`
+	Message string          `json:"Message"`
+	Meta    json.RawMessage `json:"Meta"`
+}
+
+// TykUser is a user as stored by the dashboard
 type TykUser struct {
-	Status  string `json:"Status"`
-	Message string `json:"Message"`
-	Meta    string `json:"Meta"`
+	ID           string `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	EmailAddress string `json:"email_address"`
+	Password     string `json:"password,omitempty"`
+	Active       bool   `json:"active"`
+}
+
+// TykEvent is the body posted by Tyk's default webhook event template
+type TykEvent struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	Origin  string `json:"origin"`
+	Key     string `json:"key"`
 }
 
 type Tyk struct {
-	api    string
-	secret string
-	client *http.Client
+	api       string
+	apiKey    string
+	secret    string
+	oauthAPI  string
+	oauthPath string
+	org       string
+	client    *http.Client
+	gateway   *tyk.Client
+
+	// stop stops finding the gateway in consul, nil when it isn't
+	stop func()
+
+	// policies are the gateway policies applied to keys for each role, and
+	// defaultPolicy to keys nothing else gives access to
+	policies      map[string][]string
+	defaultPolicy string
+
+	// limits are those of keys whose policies set none
+	limits tyk.Limits
 }
 
-func NewTyk() (*Tyk, error) {
-	api := os.Getenv("TYK_API")
-	if len(api) == 0 {
-		return nil, fmt.Errorf("TYK_API not set")
+// NewTyk creates a new instance of the Tyk auth driver. The gateway settings
+// are required to issue keys, the dashboard (TYK_API) is only needed to
+// manage users and TYK_OAUTH_API_ID to manage clients.
+func NewTyk(c config.Tyk, services Services) (*Tyk, error) {
+	gateway := tyk.Config{
+		Organisation: c.Org,
+		BaseURL:      c.URL,
+		Key:          c.Key,
+	}
+
+	if (len(gateway.BaseURL) == 0 && len(c.Service) == 0) || len(gateway.Organisation) == 0 || len(gateway.Key) == 0 {
+		return nil, fmt.Errorf("TYK_URL or TYK_SERVICE, TYK_ORG and TYK_KEY must be set")
+	}
+
+	policies, err := c.RolePolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	// instances found in consul are called in turn, as they come and go
+	var stop func()
+	if len(c.Service) > 0 {
+		if services == nil {
+			return nil, fmt.Errorf("TYK_SERVICE needs consul to find the gateway")
+		}
+
+		var next func() string
+		if next, stop, err = services.Balance(c.Service); err != nil {
+			return nil, err
+		}
+
+		gateway.Resolve = func() string {
+			return "http://" + next()
+		}
 	}
 
 	return &Tyk{
-		api:    api,
-		secret: os.Getenv("TYK_SHARED_SECRET"),
-		client: &http.Client{},
+		api:       c.API,
+		apiKey:    c.APIKey,
+		secret:    c.SharedSecret,
+		oauthAPI:  c.OAuthAPIID,
+		oauthPath: c.OAuthListenPath,
+		org:       gateway.Organisation,
+		client: trace.NewClient(http.Client{
+			Timeout: time.Second * 10,
+		}),
+		gateway:       tyk.NewClient(gateway),
+		stop:          stop,
+		policies:      policies,
+		defaultPolicy: c.DefaultPolicy,
+		limits: tyk.Limits{
+			Rate:             c.Limits.Rate,
+			Per:              c.Limits.Per,
+			QuotaMax:         c.Limits.QuotaMax,
+			QuotaRenewalRate: c.Limits.QuotaRenewalRate,
+		},
 	}, nil
 }
 
+// Close stops finding the gateway in consul and closes the connections
+// kept open to it. Calls in progress can still finish.
+func (t *Tyk) Close() error {
+	if t.stop != nil {
+		t.stop()
+	}
+
+	t.client.CloseIdleConnections()
+	t.gateway.Close()
+
+	return nil
+}
+
 // RegisterRoutes allows the driver to add additional routes at boot time
-func (t *Tyk) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/", t.notify).Methods("POST")
+func (t *Tyk) RegisterRoutes(g *echo.Group) {
+	if len(t.secret) == 0 {
+		logrus.Warn("Tyk: TYK_SHARED_SECRET not set, all gateway events will be discarded")
+	}
+
+	g.POST("/notify", t.notify)
 }
 
-// CreateUser creates a new consumer
-func (t *Tyk) CreateUser(user *User) (*User, error) {
-	data := url.Values{}
-	data.Add("email_address", user.Username)
-	data.Add("active", "1")
+// CreateUser creates a new dashboard user
+func (t *Tyk) CreateUser(ctx context.Context, user *User) (*User, error) {
+	if len(t.api) == 0 {
+		return user, nil
+	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/users", t.api), strings.NewReader(data.Encode()))
+	// users authenticate against us, never the dashboard, so the password
+	// only needs to be unguessable
+	body, err := json.Marshal(TykUser{
+		FirstName:    user.Username,
+		EmailAddress: user.Username,
+		Password:     uuid.NewV4().String(),
+		Active:       true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := t.client.Do(req)
-	if err != nil {
+	result := &TykResponse{}
+	if err := t.dashboard(ctx, "POST", "/api/users", bytes.NewReader(body), result); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	// depending on the dashboard version the id is either in the meta data
+	// or is the message itself
+	created := TykUser{}
+	if err := json.Unmarshal(result.Meta, &created); err == nil && len(created.ID) > 0 {
+		user.ID = created.ID
+	} else if len(result.Message) > 0 {
+		user.ID = result.Message
+	} else {
+		return nil, fmt.Errorf("tyk: no id returned for user %s", user.Username)
 	}
 
-	var result *TykUser
-	if err := json.Unmarshal(body, &result); err != nil {
+	return user, nil
+}
+
+// GetUser looks up a dashboard user
+func (t *Tyk) GetUser(ctx context.Context, userID string) (*User, error) {
+	if len(t.api) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	result := &TykUser{}
+	if err := t.dashboard(ctx, "GET", "/api/users/"+url.PathEscape(userID), nil, result); err != nil {
 		return nil, err
 	}
 
-	logrus.Infof("%+v", resp.Body)
-	logrus.Infof("%+v", result)
-	// set id of user fomr here
+	return &User{
+		ID:       result.ID,
+		Username: result.EmailAddress,
+	}, nil
+}
 
-	return user, nil
+// DeleteUser removes a dashboard user
+func (t *Tyk) DeleteUser(ctx context.Context, userID string) error {
+	if len(t.api) == 0 {
+		return ErrNotSupported
+	}
+
+	return t.dashboard(ctx, "DELETE", "/api/users/"+url.PathEscape(userID), nil, nil)
 }
 
-// GetUser looks up a consumer
-func (t *Tyk) GetUser(userID string) (*User, error) {
-	return nil, nil
+// CreateClient registers a new OAuth client against TYK_OAUTH_API_ID
+func (t *Tyk) CreateClient(ctx context.Context, client *Client) (*Client, error) {
+	if len(t.oauthAPI) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	result, err := t.gateway.CreateOAuthClient(ctx, tyk.OAuthClient{
+		APIID:       t.oauthAPI,
+		RedirectURI: client.RedirectURI,
+		MetaData: map[string]string{
+			"name":        client.Name,
+			"consumer_id": client.ConsumerID,
+		},
+	})
+	if err != nil {
+		return nil, tykError(err)
+	}
+
+	client.ClientID = result.ClientID
+	client.ClientSecret = result.Secret
+
+	return client, nil
 }
 
-// DeleteUser removes a consumer
-func (t *Tyk) DeleteUser(userID string) error {
-	return nil
+// GetClient looks up an OAuth client
+func (t *Tyk) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	if len(t.oauthAPI) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	result, err := t.gateway.GetOAuthClient(ctx, t.oauthAPI, clientID)
+	if err != nil {
+		return nil, tykError(err)
+	}
+
+	return &Client{
+		ClientID:     result.ClientID,
+		ClientSecret: result.Secret,
+		RedirectURI:  result.RedirectURI,
+		Name:         result.MetaData["name"],
+		ConsumerID:   result.MetaData["consumer_id"],
+	}, nil
+}
+
+// DeleteClient deletes an OAuth client
+func (t *Tyk) DeleteClient(ctx context.Context, client *Client) error {
+	if len(t.oauthAPI) == 0 {
+		return ErrNotSupported
+	}
+
+	return tykError(t.gateway.DeleteOAuthClient(ctx, t.oauthAPI, client.ClientID))
+}
+
+// Authorize asks the gateway for an authorization code for the user and
+// returns where the user agent should be redirected with it
+func (t *Tyk) Authorize(ctx context.Context, client *Client, user *User, scope string) (*Redirect, error) {
+	if len(t.oauthAPI) == 0 || len(t.oauthPath) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	redirectURI := client.RedirectURI
+	if len(redirectURI) == 0 {
+		c, err := t.GetClient(ctx, client.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		redirectURI = c.RedirectURI
+	}
+
+	rules := tyk.NewKey(t.org, t.limits)
+	rules.AccessRights = map[string]tyk.AccessRight{
+		t.oauthAPI: {
+			ID:       t.oauthAPI,
+			Versions: []string{"Default"},
+		},
+	}
+
+	result, err := t.gateway.AuthorizeClient(ctx, t.oauthPath, client.ClientID, redirectURI, rules)
+	if err != nil {
+		return nil, tykError(err)
+	}
+
+	return &Redirect{
+		RedirectURI: result.RedirectTo,
+	}, nil
 }
 
-// CreateClient creates a new application
-func (t *Tyk) CreateClient(client *Client) (*Client, error) {
-	return nil, nil
+// IssueToken creates a key on the gateway for the token, with entitlements
+// from the policies that apply to it
+func (t *Tyk) IssueToken(ctx context.Context, token *Token) error {
+	k := tyk.NewKey(t.org, t.limits)
+	k.Expires = token.ExpiresAt.Unix()
+	k.MetaData = map[string]interface{}{
+		"issuer":  tykIssuer,
+		"user_id": token.UserID,
+	}
+
+	if len(token.ClientID) > 0 {
+		k.MetaData["client_id"] = token.ClientID
+	}
+
+	if len(token.Scope) > 0 {
+		k.MetaData["scope"] = token.Scope
+	}
+
+	applyPolicies(&k, token.Policies)
+	for _, role := range token.Roles {
+		k.ApplyPolicies = merge(k.ApplyPolicies, t.policies[role])
+	}
+
+	// Tyk lets a key with neither access rights nor policies call every API
+	if len(k.AccessRights) == 0 && len(k.ApplyPolicies) == 0 {
+		if len(t.defaultPolicy) == 0 {
+			logrus.Warnf("Tyk: no policy applies to token %s of user %s and TYK_DEFAULT_POLICY isn't set, not issuing it", model.MaskToken(token.Token), token.UserID)
+			return ErrNoPolicy
+		}
+
+		k.ApplyPolicies = []string{t.defaultPolicy}
+	}
+
+	return tykError(t.gateway.CreateKey(ctx, token.Token, k))
 }
 
-// GetClient looks up an application
-func (t *Tyk) GetClient(clientID string) (*Client, error) {
-	return nil, nil
+// RevokeToken deletes the token's key from the gateway
+func (t *Tyk) RevokeToken(ctx context.Context, token *Token) error {
+	return tykError(t.gateway.DeleteKey(ctx, token.Token))
 }
 
-// DeleteClient deletes a client
-func (t *Tyk) DeleteClient(client *Client) error {
-	return nil
+// Ping checks the gateway is reachable
+func (t *Tyk) Ping(ctx context.Context) error {
+	return t.gateway.Ping(ctx)
+}
+
+// applyPolicies grants the key every API the policies give access to and the
+// limits of the highest priority policy that sets them
+func applyPolicies(k *tyk.Key, policies []model.Policy) {
+	k.AccessRights = make(map[string]tyk.AccessRight)
+	rateSet, quotaSet := false, false
+
+	for _, p := range policies {
+		if !rateSet && p.Rate > 0 && p.Per > 0 {
+			k.Allowance = p.Rate
+			k.Rate = p.Rate
+			k.Per = p.Per
+			rateSet = true
+		}
+
+		if !quotaSet && p.QuotaMax != 0 {
+			k.QuotaMax = p.QuotaMax
+			k.QuotaRemaining = p.QuotaMax
+			k.QuotaRenewalRate = p.QuotaRenewalRate
+			quotaSet = true
+		}
+
+		k.ApplyPolicies = append(k.ApplyPolicies, p.GatewayPolicies...)
+
+		for _, a := range p.AccessRights {
+			versions := a.Versions
+			if len(versions) == 0 {
+				versions = []string{"Default"}
+			}
+
+			if existing, ok := k.AccessRights[a.APIID]; ok {
+				versions = merge(existing.Versions, versions)
+			}
+
+			k.AccessRights[a.APIID] = tyk.AccessRight{
+				ID:       a.APIID,
+				Name:     a.APIName,
+				Versions: versions,
+			}
+		}
+	}
+}
+
+// merge appends the strings of b that a doesn't have to a copy of a
+func merge(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, v := range b {
+		found := false
+		for _, m := range merged {
+			if m == v {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			merged = append(merged, v)
+		}
+	}
+
+	return merged
+}
+
+// dashboard sends an authenticated request to the dashboard API and decodes
+// the response into v
+func (t *Tyk) dashboard(ctx context.Context, method, path string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, t.api+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Authorization", t.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := tyk.CheckResponse(resp); err != nil {
+		return tykError(err)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// the dashboard sometimes reports failures with a 200
+	status := TykResponse{}
+	if json.Unmarshal(data, &status) == nil && strings.EqualFold(status.Status, "error") {
+		return &tyk.Error{
+			StatusCode: resp.StatusCode,
+			Status:     status.Status,
+			Message:    status.Message,
+		}
+	}
+
+	return json.Unmarshal(data, v)
 }
 
-// Authorize attempts to authenticate a user
-func (t *Tyk) Authorize(client *Client, user *User, scope string) (*Redirect, error) {
-	return nil, nil
+// tykError maps Tyk's not found responses on to ErrNotFound
+func tykError(err error) error {
+	if tyk.IsNotFound(err) {
+		return ErrNotFound
+	}
+
+	if tyk.IsUnavailable(err) {
+		logrus.Warnf("Tyk: gateway unavailable: %v", err)
+		return ErrUnavailable
+	}
+
+	return err
 }
 
-func (t *Tyk) notify(w http.ResponseWriter, r *http.Request) {
-	// check shared secret matches otherwise discard.
-	// store updated user data in manager
+// notify receives events from the Tyk gateway's webhook event handler and
+// reconciles them with the tokens we have issued
+func (t *Tyk) notify(c echo.Context) error {
+	secret := c.Request().Header.Get(TykSecretHeader)
+	if len(t.secret) == 0 || subtle.ConstantTimeCompare([]byte(secret), []byte(t.secret)) != 1 {
+		logrus.Infof("Tyk: discarding event with invalid shared secret from %s", c.RealIP())
+		return response.NewError(http.StatusForbidden, response.CodeForbidden, "Invalid shared secret")
+	}
+
+	e := TykEvent{}
+	if err := c.Bind(&e); err != nil {
+		return response.MalformedBody(err)
+	}
+
+	logrus.Debugf("Tyk: received %s event for %s", e.Event, model.MaskToken(e.Key))
+
+	if len(e.Key) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	s := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	var err error
+	tk := model.Token{}
+
+	switch e.Event {
+	case TykEventKeyExpired, TykEventTokenDeleted, TykEventKeyDeleted:
+		// the key is no longer usable on the gateway so neither is our token
+		tk, err = s.Tokens().Remove(ctx, e.Key)
+		if err == nil {
+			if e.Event != TykEventKeyExpired {
+				metrics.TokensRevoked.Inc()
+			}
+			webhook.Publish(c, webhook.EventTokenRevoked, echo.Map{
+				"user":       tk.User,
+				"expires_at": tk.ExpiresAt,
+				"reason":     e.Event,
+			})
+		}
+	case TykEventQuotaExceeded:
+		tk, err = s.Tokens().SetQuotaExceeded(ctx, e.Key, time.Now())
+	default:
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err != nil && err != storage.ErrNotFound {
+		return response.Internal(err)
+	}
+
+	audit.Record(ctx, s, model.AuditEvent{
+		Type:    "gateway." + e.Event,
+		Source:  "tyk",
+		User:    tk.User,
+		Token:   model.MaskToken(e.Key),
+		Message: e.Message,
+		Data: map[string]interface{}{
+			"path":   e.Path,
+			"origin": e.Origin,
+			"known":  err == nil,
+		},
+	})
+
+	return c.NoContent(http.StatusNoContent)
 }
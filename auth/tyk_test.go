@@ -0,0 +1,353 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/tyk"
+	"github.com/2-IMMERSE/auth-service/tyk/tyktest"
+)
+
+// newTestTyk starts a stand-in gateway and dashboard and a driver using them
+func newTestTyk(t *testing.T) (*Tyk, *tyktest.Server) {
+	s := tyktest.NewServer("secret", "dashboard-key")
+
+	d, err := NewTyk(config.Tyk{
+		URL:             s.URL,
+		Org:             "org",
+		Key:             "secret",
+		API:             s.URL,
+		APIKey:          "dashboard-key",
+		OAuthAPIID:      "oauth-api",
+		OAuthListenPath: "/oauth/",
+		Policies:        []string{"ROLE_ADMIN=admin"},
+		DefaultPolicy:   "default",
+		Limits:          config.Default().Gateway.Tyk.Limits,
+	}, nil)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+
+	return d, s
+}
+
+func TestTykUsers(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	created, err := d.CreateUser(ctx, &User{Username: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.ID) == 0 {
+		t.Fatal("no id given to the created user")
+	}
+
+	if _, err := d.CreateUser(ctx, &User{Username: "user@example.com"}); err == nil {
+		t.Error("created a second user with the same email")
+	}
+
+	u, err := d.GetUser(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Username != "user@example.com" {
+		t.Errorf("got username %q, expected user@example.com", u.Username)
+	}
+
+	if err := d.DeleteUser(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.GetUser(ctx, created.ID); err != ErrNotFound {
+		t.Errorf("got %v for a deleted user, expected %v", err, ErrNotFound)
+	}
+
+	if err := d.DeleteUser(ctx, created.ID); err != ErrNotFound {
+		t.Errorf("got %v deleting a deleted user, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestTykClients(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	const callback = "https://example.com/callback"
+
+	created, err := d.CreateClient(ctx, &Client{Name: "client", RedirectURI: callback, ConsumerID: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := d.GetClient(ctx, created.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != (Client{ClientID: created.ClientID, ClientSecret: created.ClientSecret, RedirectURI: callback, Name: "client", ConsumerID: "user"}) {
+		t.Errorf("got %+v, expected the client as created", c)
+	}
+
+	tests := []struct {
+		name   string
+		client Client
+		err    bool
+	}{
+		{name: "redirect given", client: Client{ClientID: created.ClientID, RedirectURI: callback}},
+		{name: "redirect looked up", client: Client{ClientID: created.ClientID}},
+		{name: "other redirect", client: Client{ClientID: created.ClientID, RedirectURI: "https://example.org/callback"}, err: true},
+		{name: "unknown client", client: Client{ClientID: "unknown"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := d.Authorize(ctx, &test.client, &User{ID: "user"}, "")
+			if test.err {
+				if err == nil {
+					t.Errorf("authorized, redirecting to %s", r.RedirectURI)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(r.RedirectURI, callback+"?code=") {
+				t.Errorf("got redirect %s, expected a code for %s", r.RedirectURI, callback)
+			}
+		})
+	}
+
+	if err := d.DeleteClient(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.GetClient(ctx, created.ClientID); err != ErrNotFound {
+		t.Errorf("got %v for a deleted client, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestTykNotConfigured(t *testing.T) {
+	// only the gateway is set up, the dashboard and OAuth API aren't
+	d, err := NewTyk(config.Tyk{URL: "http://localhost", Org: "org", Key: "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"get user", func() error { _, err := d.GetUser(ctx, "user"); return err }},
+		{"delete user", func() error { return d.DeleteUser(ctx, "user") }},
+		{"create client", func() error { _, err := d.CreateClient(ctx, &Client{}); return err }},
+		{"get client", func() error { _, err := d.GetClient(ctx, "client"); return err }},
+		{"delete client", func() error { return d.DeleteClient(ctx, &Client{}) }},
+		{"authorize", func() error { _, err := d.Authorize(ctx, &Client{}, &User{}, ""); return err }},
+	}
+
+	for _, test := range tests {
+		if err := test.call(); err != ErrNotSupported {
+			t.Errorf("%s: got %v, expected %v", test.name, err, ErrNotSupported)
+		}
+	}
+}
+
+func TestTykIssueToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token Token
+
+		apis     []string
+		policies []string
+		rate     int
+	}{
+		{
+			name:     "no policy",
+			token:    Token{UserID: "user"},
+			apis:     []string{},
+			policies: []string{"default"},
+			rate:     1000,
+		},
+		{
+			name:     "role only",
+			token:    Token{UserID: "user", Roles: []string{"ROLE_ADMIN"}},
+			apis:     []string{},
+			policies: []string{"admin"},
+			rate:     1000,
+		},
+		{
+			name: "policies",
+			token: Token{UserID: "user", Policies: []model.Policy{
+				{Rate: 10, Per: 60, GatewayPolicies: []string{"gold"}, AccessRights: []model.PolicyAccess{{APIID: "a"}}},
+				{Rate: 100, Per: 60, AccessRights: []model.PolicyAccess{{APIID: "b"}, {APIID: "a"}}},
+			}},
+			apis:     []string{"a", "b"},
+			policies: []string{"gold"},
+			rate:     10,
+		},
+		{
+			name: "role",
+			token: Token{UserID: "user", Roles: []string{"ROLE_ADMIN"}, Policies: []model.Policy{
+				{AccessRights: []model.PolicyAccess{{APIID: "a"}}},
+			}},
+			apis:     []string{"a"},
+			policies: []string{"admin"},
+			rate:     1000,
+		},
+	}
+
+	d, s := newTestTyk(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.token
+			token.Token = strings.Replace(test.name, " ", "-", -1)
+			token.ExpiresAt = time.Now().Add(time.Hour)
+
+			if err := d.IssueToken(ctx, &token); err != nil {
+				t.Fatal(err)
+			}
+
+			k := tyk.Key{}
+			if err := json.Unmarshal(s.Keys[token.Token], &k); err != nil {
+				t.Fatalf("key not created: %v", err)
+			}
+
+			apis := []string{}
+			for id := range k.AccessRights {
+				apis = append(apis, id)
+			}
+			sort.Strings(apis)
+
+			if !reflect.DeepEqual(apis, test.apis) {
+				t.Errorf("got access to %v, expected %v", apis, test.apis)
+			}
+			if !reflect.DeepEqual(k.ApplyPolicies, test.policies) {
+				t.Errorf("got policies %v, expected %v", k.ApplyPolicies, test.policies)
+			}
+			if k.Rate != test.rate {
+				t.Errorf("got rate %d, expected %d", k.Rate, test.rate)
+			}
+			if k.Expires != token.ExpiresAt.Unix() {
+				t.Errorf("got expiry %d, expected %d", k.Expires, token.ExpiresAt.Unix())
+			}
+
+			if err := d.RevokeToken(ctx, &token); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.RevokeToken(ctx, &token); err != ErrNotFound {
+				t.Errorf("got %v revoking a revoked token, expected %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestTykNoPolicy(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+	d.defaultPolicy = ""
+	ctx := context.Background()
+
+	token := &Token{Token: "no-policy", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := d.IssueToken(ctx, token); err != ErrNoPolicy {
+		t.Errorf("got %v, expected %v", err, ErrNoPolicy)
+	}
+	if _, ok := s.Keys[token.Token]; ok {
+		t.Error("issued a key that could call every API")
+	}
+
+	token = &Token{Token: "role", UserID: "user", Roles: []string{"ROLE_ADMIN"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := d.IssueToken(ctx, token); err != nil {
+		t.Errorf("got %v for a token a role policy applies to", err)
+	}
+}
+
+func TestTykBreaker(t *testing.T) {
+	d, s := newTestTyk(t)
+	defer s.Close()
+
+	// a gateway that is down refuses connections
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	const cooloff = 20 * time.Millisecond
+
+	up := false
+	d.gateway = tyk.NewClient(tyk.Config{
+		Organisation: "org",
+		Key:          "secret",
+		Resolve: func() string {
+			if up {
+				return s.URL
+			}
+			return down.URL
+		},
+		RetryWait:        time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooloff:   cooloff,
+	})
+
+	steps := []struct {
+		name string
+		wait time.Duration
+		up   bool
+
+		err    error
+		issued bool
+		open   bool
+	}{
+		{name: "first failure", err: ErrUnavailable},
+		{name: "threshold reached", err: ErrUnavailable, open: true},
+		{name: "open while the gateway is back", up: true, err: ErrUnavailable, open: true},
+		{name: "trial fails", wait: 2 * cooloff, err: ErrUnavailable, open: true},
+		{name: "open again", up: true, err: ErrUnavailable, open: true},
+		{name: "trial succeeds", wait: 2 * cooloff, up: true, issued: true},
+		{name: "closed", up: true, issued: true},
+	}
+
+	for i, step := range steps {
+		time.Sleep(step.wait)
+		up = step.up
+
+		token := &Token{Token: "key-" + string('a'+rune(i)), UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+		err := d.IssueToken(context.Background(), token)
+
+		if err != step.err {
+			t.Errorf("%s: got %v, expected %v", step.name, err, step.err)
+		}
+		if _, ok := s.Keys[token.Token]; ok != step.issued {
+			t.Errorf("%s: key issued is %t, expected %t", step.name, ok, step.issued)
+		}
+		if open := !d.gateway.Available(); open != step.open {
+			t.Errorf("%s: breaker open is %t, expected %t", step.name, open, step.open)
+		}
+	}
+}
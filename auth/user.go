@@ -15,6 +15,6 @@
 package auth
 
 type User struct {
-	ID string `json:"id"`
+	ID       string `json:"id"`
 	Username string `json:"username"`
 }
@@ -0,0 +1,662 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/api"
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/encrypted"
+	"github.com/2-IMMERSE/auth-service/webhook"
+)
+
+// A command is chosen by the words after the flags, for example
+// `auth-service -storage sqlite user list`
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the service, the default", serve},
+	{"migrate", "bring the storage schema and indexes up to date", migrateCommand},
+	{"config print", "show the effective configuration with secrets redacted", configPrint},
+	{"user create", "create a user", userCreate},
+	{"user list", "list users", userList},
+	{"user set-password", "change a user's password and revoke their tokens", userSetPassword},
+	{"user grant-role", "give a user a role", userGrantRole},
+	{"token list", "list access tokens", tokenList},
+	{"token revoke", "revoke one token, or every token of a user", tokenRevoke},
+	{"device unlink", "remove a device", deviceUnlink},
+	{"key import", "store a key read from a file", keyImport},
+	{"key export", "write a stored key to a file", keyExport},
+	{"fixtures load", "load the users and keys in a fixtures directory", fixturesLoad},
+	{"encryption rotate", "seal stored secrets with the current master key", encryptionRotate},
+	{"api schemas", "generate the request schemas from the RAML API spec", apiSchemas},
+}
+
+// run finds the command named by args and runs it, returning the exit status
+func run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != c.name {
+			continue
+		}
+
+		// printing is how a broken configuration gets looked into
+		if c.name != "config print" {
+			if err := cfg.Validate(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+
+		if err := c.run(args[len(words):]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+			return 1
+		}
+
+		return 0
+	}
+
+	usage()
+	return 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-config file] [flags] [command]\n\nCommands:\n", os.Args[0])
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.description)
+	}
+	w.Flush()
+
+	fmt.Fprintf(os.Stderr, "\nRun a command with -h for its own flags. Settings come from the -config file\n(or AUTH_CONFIG), then the environment, then the flags below.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// withStore runs f against the configured storage, migrating it first as
+// serve would
+func withStore(f func(ctx context.Context, store storage.Store) error) error {
+	store, err := openStorage(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := migrate(store, false); err != nil {
+		return err
+	}
+
+	wrapped, err := encrypt(store, cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	return f(context.Background(), wrapped)
+}
+
+// readPassword returns the password given as a flag, or reads it from the
+// first line of stdin so it stays out of the process list
+func readPassword(password string) (string, error) {
+	if len(password) > 0 {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		if err == nil {
+			err = errors.New("no password given")
+		}
+		return "", err
+	}
+
+	return line, nil
+}
+
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "show pending changes without making them")
+	flags.Parse(args)
+
+	store, err := openStorage(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return migrate(store, *dryRun)
+}
+
+func configPrint(args []string) error {
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	format := flags.String("format", "yaml", "output format [yaml, toml]")
+	flags.Parse(args)
+
+	return cfg.Redacted().Write(os.Stdout, *format)
+}
+
+// migrate applies, or on a dry run lists, the changes the store needs
+func migrate(store storage.Store, dryRun bool) error {
+	m, ok := store.(storage.Migrator)
+	if !ok {
+		return nil
+	}
+
+	changes, err := m.Migrate(context.Background(), dryRun)
+	for _, change := range changes {
+		if dryRun {
+			fmt.Println("pending:", change)
+		} else {
+			logrus.Infof("Storage: %s", change)
+		}
+	}
+
+	if err == nil && dryRun && len(changes) == 0 {
+		fmt.Println("up to date")
+	}
+
+	return err
+}
+
+func userCreate(args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := flags.String("email", "", "email address of the user")
+	password := flags.String("password", "", "password, read from stdin when not given")
+	name := flags.String("name", "", "display name")
+	roles := flags.String("roles", "", "comma separated roles, such as ROLE_ADMIN")
+	flags.Parse(args)
+
+	if len(*email) == 0 {
+		return errors.New("-email is required")
+	}
+
+	plain, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	driver, err := auth.NewDriver(cfg.Gateway, gatewayServices(cfg.Gateway))
+	if err != nil {
+		return err
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		if _, err := store.Users().GetByEmail(ctx, *email); err == nil {
+			return fmt.Errorf("%s is already in use", *email)
+		}
+
+		u := model.User{
+			ID:            primitive.NewObjectID(),
+			Email:         *email,
+			DisplayName:   *name,
+			PlainPassword: plain,
+			Profile: &model.Profile{
+				Communal:  make(map[string]interface{}),
+				Companion: make(map[string]interface{}),
+			},
+		}
+		if len(*roles) > 0 {
+			u.Roles = strings.Split(*roles, ",")
+		}
+
+		if err := u.HashPassword(); err != nil {
+			return err
+		}
+
+		gu, err := driver.CreateUser(ctx, &auth.User{
+			ID:       u.ID.Hex(),
+			Username: u.Email,
+		})
+		if err != nil {
+			return fmt.Errorf("gateway: %v", err)
+		}
+		u.GatewayID = gu.ID
+
+		if err := store.Users().Create(ctx, u); err != nil {
+			return err
+		}
+
+		if err := webhook.Enqueue(ctx, store, webhook.EventUserCreated, u); err != nil {
+			logrus.Errorf("Webhooks: %v", err)
+		}
+
+		fmt.Println(u.ID.Hex())
+		return nil
+	})
+}
+
+func userList(args []string) error {
+	flags := flag.NewFlagSet("user list", flag.ExitOnError)
+	page := storage.Page{}
+	flags.IntVar(&page.Offset, "offset", 0, "number of users to skip")
+	flags.IntVar(&page.Limit, "limit", 0, "number of users to list, 0 for all")
+	flags.StringVar(&page.Sort, "sort", "email", "field to sort by, prefixed with - to reverse")
+	flags.Parse(args)
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		users, count, err := store.Users().List(ctx, page)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEMAIL\tNAME\tROLES")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.ID.Hex(), u.Email, u.DisplayName, strings.Join(u.Roles, ","))
+		}
+		w.Flush()
+
+		fmt.Printf("%d of %d users\n", len(users), count)
+		return nil
+	})
+}
+
+func userSetPassword(args []string) error {
+	flags := flag.NewFlagSet("user set-password", flag.ExitOnError)
+	email := flags.String("email", "", "email address of the user")
+	password := flags.String("password", "", "new password, read from stdin when not given")
+	flags.Parse(args)
+
+	plain, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	driver, err := auth.NewDriver(cfg.Gateway, gatewayServices(cfg.Gateway))
+	if err != nil {
+		return err
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		u, err := store.Users().GetByEmail(ctx, *email)
+		if err != nil {
+			return fmt.Errorf("user %s: %v", *email, err)
+		}
+
+		u.PlainPassword = plain
+		if err := u.HashPassword(); err != nil {
+			return err
+		}
+
+		if err := store.Users().Update(ctx, u.ID, u); err != nil {
+			return err
+		}
+
+		auth.RevokeUserTokens(ctx, store, driver, u)
+
+		if err := webhook.Enqueue(ctx, store, webhook.EventUserUpdated, map[string]string{"id": u.ID.Hex()}); err != nil {
+			logrus.Errorf("Webhooks: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func userGrantRole(args []string) error {
+	flags := flag.NewFlagSet("user grant-role", flag.ExitOnError)
+	email := flags.String("email", "", "email address of the user")
+	role := flags.String("role", "", "role to grant, such as ROLE_ADMIN")
+	flags.Parse(args)
+
+	if len(*role) == 0 {
+		return errors.New("-role is required")
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		u, err := store.Users().GetByEmail(ctx, *email)
+		if err != nil {
+			return fmt.Errorf("user %s: %v", *email, err)
+		}
+
+		if u.HasRole(*role) {
+			return nil
+		}
+
+		u.Roles = append(u.Roles, *role)
+		if err := store.Users().Update(ctx, u.ID, u); err != nil {
+			return err
+		}
+
+		if err := webhook.Enqueue(ctx, store, webhook.EventUserUpdated, map[string]string{"id": u.ID.Hex()}); err != nil {
+			logrus.Errorf("Webhooks: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func tokenList(args []string) error {
+	flags := flag.NewFlagSet("token list", flag.ExitOnError)
+	email := flags.String("email", "", "only list the tokens of this user")
+	flags.Parse(args)
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		var tokens []model.Token
+		var err error
+
+		if len(*email) > 0 {
+			var u model.User
+			if u, err = store.Users().GetByEmail(ctx, *email); err != nil {
+				return fmt.Errorf("user %s: %v", *email, err)
+			}
+			tokens, err = store.Tokens().ListByUser(ctx, u.ID)
+		} else {
+			tokens, err = store.Tokens().All(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TOKEN\tUSER\tSCOPE\tEXPIRES\tPENDING")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", model.MaskToken(t.Token), t.User.Hex(), t.Scope, t.ExpiresAt.Format("2006-01-02 15:04"), t.GatewayPending)
+		}
+		w.Flush()
+
+		return nil
+	})
+}
+
+func tokenRevoke(args []string) error {
+	flags := flag.NewFlagSet("token revoke", flag.ExitOnError)
+	token := flags.String("token", "", "the token to revoke")
+	email := flags.String("email", "", "revoke every token of this user instead")
+	flags.Parse(args)
+
+	if len(*token) == 0 && len(*email) == 0 {
+		return errors.New("-token or -email is required")
+	}
+
+	driver, err := auth.NewDriver(cfg.Gateway, gatewayServices(cfg.Gateway))
+	if err != nil {
+		return err
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		if len(*email) > 0 {
+			u, err := store.Users().GetByEmail(ctx, *email)
+			if err != nil {
+				return fmt.Errorf("user %s: %v", *email, err)
+			}
+
+			auth.RevokeUserTokens(ctx, store, driver, u)
+			return nil
+		}
+
+		t, err := store.Tokens().Remove(ctx, *token)
+		if err != nil {
+			return err
+		}
+
+		u, _ := store.Users().Get(ctx, t.User)
+		if err := driver.RevokeToken(ctx, auth.NewToken(u, t)); err != nil && err != auth.ErrNotFound {
+			logrus.Errorf("Gateway: unable to revoke token: %v", err)
+		}
+
+		err = webhook.Enqueue(ctx, store, webhook.EventTokenRevoked, map[string]interface{}{
+			"user":       t.User,
+			"expires_at": t.ExpiresAt,
+		})
+		if err != nil {
+			logrus.Errorf("Webhooks: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func deviceUnlink(args []string) error {
+	flags := flag.NewFlagSet("device unlink", flag.ExitOnError)
+	id := flags.String("id", "", "id of the device")
+	flags.Parse(args)
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		d, err := store.Devices().Get(ctx, *id)
+		if err != nil {
+			return fmt.Errorf("device %s: %v", *id, err)
+		}
+
+		if err := store.Devices().Delete(ctx, d.ID); err != nil {
+			return err
+		}
+
+		d.Code = ""
+		if err := webhook.Enqueue(ctx, store, webhook.EventDeviceUnlinked, d); err != nil {
+			logrus.Errorf("Webhooks: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func keyImport(args []string) error {
+	flags := flag.NewFlagSet("key import", flag.ExitOnError)
+	file := flags.String("file", "", "file to read the key from")
+	title := flags.String("title", "", "title of the key")
+	id := flags.String("id", "", "id to store the key under, a new one when not given")
+	flags.Parse(args)
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+
+	k := model.Key{
+		ID:    primitive.NewObjectID(),
+		Title: *title,
+		Data:  data,
+	}
+	k.GenerateSlug()
+
+	if len(*id) > 0 {
+		if k.ID, err = primitive.ObjectIDFromHex(*id); err != nil {
+			return err
+		}
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		if err := store.Keys().Create(ctx, k); err != nil {
+			return err
+		}
+
+		fmt.Println(k.ID.Hex())
+		return nil
+	})
+}
+
+func keyExport(args []string) error {
+	flags := flag.NewFlagSet("key export", flag.ExitOnError)
+	id := flags.String("id", "", "id of the key")
+	out := flags.String("out", "", "file to write the key to, stdout when not given")
+	flags.Parse(args)
+
+	keyID, err := primitive.ObjectIDFromHex(*id)
+	if err != nil {
+		return err
+	}
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		k, err := store.Keys().Get(ctx, keyID)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", *id, err)
+		}
+
+		if len(*out) == 0 {
+			_, err := os.Stdout.Write(k.Data)
+			return err
+		}
+
+		return ioutil.WriteFile(*out, k.Data, 0600)
+	})
+}
+
+func fixturesLoad(args []string) error {
+	flags := flag.NewFlagSet("fixtures load", flag.ExitOnError)
+	dir := flags.String("dir", cfg.Fixtures, "directory holding users.json and keys")
+	flags.Parse(args)
+
+	return withStore(func(ctx context.Context, store storage.Store) error {
+		return loadFixtures(store, *dir)
+	})
+}
+
+func encryptionRotate(args []string) error {
+	flags := flag.NewFlagSet("encryption rotate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "count what needs sealing again without changing anything")
+	flags.Parse(args)
+
+	if len(cfg.Encryption.Key) == 0 {
+		return errors.New("encryption.key (ENCRYPTION_KEY) must be set")
+	}
+
+	keyring, err := encrypted.NewKeyring(cfg.Encryption.Key, cfg.Encryption.PreviousKeys)
+	if err != nil {
+		return err
+	}
+
+	// the store is used as it is, so values are read and written sealed
+	store, err := openStorage(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := migrate(store, false); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	keys, err := store.Keys().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	rotatedKeys := 0
+	for _, k := range keys {
+		data, changed, err := keyring.Rotate(k.Data)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", k.ID.Hex(), err)
+		}
+
+		if !changed {
+			continue
+		}
+
+		rotatedKeys++
+		if *dryRun {
+			continue
+		}
+
+		k.Data = data
+		if err := store.Keys().Upsert(ctx, k); err != nil {
+			return fmt.Errorf("key %s: %v", k.ID.Hex(), err)
+		}
+	}
+
+	rotatedClients := 0
+	page := storage.Page{Limit: 100, Sort: "id"}
+	for {
+		clients, _, err := store.Clients().List(ctx, page)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range clients {
+			if len(c.ClientSecret) == 0 {
+				continue
+			}
+
+			secret, changed, err := keyring.Rotate([]byte(c.ClientSecret))
+			if err != nil {
+				return fmt.Errorf("client %s: %v", c.ID.Hex(), err)
+			}
+
+			if !changed {
+				continue
+			}
+
+			rotatedClients++
+			if *dryRun {
+				continue
+			}
+
+			c.ClientSecret = string(secret)
+			if err := store.Clients().Replace(ctx, c); err != nil {
+				return fmt.Errorf("client %s: %v", c.ID.Hex(), err)
+			}
+		}
+
+		if len(clients) < page.Limit {
+			break
+		}
+		page.Offset += page.Limit
+	}
+
+	if *dryRun {
+		fmt.Printf("%d keys and %d clients need sealing with the current master key\n", rotatedKeys, rotatedClients)
+	} else {
+		fmt.Printf("%d keys and %d clients sealed with the current master key\n", rotatedKeys, rotatedClients)
+	}
+
+	return nil
+}
+
+func apiSchemas(args []string) error {
+	flags := flag.NewFlagSet("api schemas", flag.ExitOnError)
+	raml := flags.String("raml", cfg.API, "the API spec")
+	out := flags.String("out", cfg.Schema, "directory the schemas are written to")
+	flags.Parse(args)
+
+	spec, err := api.Load(*raml)
+	if err != nil {
+		return err
+	}
+
+	written, err := spec.WriteSchemas(*out)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range written {
+		fmt.Println(filepath.Join(*out, name))
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config describes how the service is configured. Settings come from
+// defaults, a YAML or TOML file, the environment and flags, each overriding
+// the one before.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config is everything the service can be configured with. Fields tagged env
+// or flag can also be set that way, secret ones are redacted when printed
+// and can be read from the file named by the variable with _FILE appended.
+type Config struct {
+	Listen    string `yaml:"listen" toml:"listen" env:"LISTEN" flag:"listen" usage:"[hostname:port] to listen on"`
+	Debug     bool   `yaml:"debug" toml:"debug" env:"DEBUG" flag:"debug" usage:"enable debug"`
+	LogFormat string `yaml:"log_format" toml:"log_format" env:"LOG_FORMAT" flag:"log-format" usage:"format of the service and access logs [json, text]"`
+	Procs     int    `yaml:"procs" toml:"procs" env:"PROCS" flag:"procs" usage:"max number of CPUs that can be used simultaneously. Less than 1 for default (number of cores)."`
+	Schema    string `yaml:"schema" toml:"schema" env:"SCHEMA_DIR" flag:"schema" usage:"directory holding the JSON schemas requests are validated against"`
+	Fixtures  string `yaml:"fixtures" toml:"fixtures" env:"FIXTURES_DIR" flag:"fixtures" usage:"directory holding the users and keys loaded at startup"`
+	API       string `yaml:"api" toml:"api" env:"API_SPEC" flag:"api" usage:"RAML spec of the API, used to describe it at /openapi.json"`
+
+	// ShutdownTimeout is how long requests and background work get to
+	// finish once the service is told to stop
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to wait for requests and background work to finish when stopping"`
+
+	Storage    Storage    `yaml:"storage" toml:"storage"`
+	Encryption Encryption `yaml:"encryption" toml:"encryption"`
+	Gateway    Gateway    `yaml:"gateway" toml:"gateway"`
+	Tokens     Tokens     `yaml:"tokens" toml:"tokens"`
+	CORS       CORS       `yaml:"cors" toml:"cors"`
+	Consul     Consul     `yaml:"consul" toml:"consul"`
+}
+
+// Storage selects and configures the storage backend
+type Storage struct {
+	Backend string `yaml:"backend" toml:"backend" env:"STORAGE" flag:"storage" usage:"storage backend to use [mongo, postgres, sqlite, memory]"`
+	Mongo   Mongo  `yaml:"mongo" toml:"mongo"`
+	SQL     SQL    `yaml:"sql" toml:"sql"`
+}
+
+// Encryption configures the envelope encryption of key data and client
+// secrets in storage
+type Encryption struct {
+	// Key is a base64 encoded 32 byte master key, without one nothing is
+	// encrypted
+	Key string `yaml:"key" toml:"key" env:"ENCRYPTION_KEY" secret:"true"`
+
+	// PreviousKeys can still decrypt until `encryption rotate` has sealed
+	// everything with Key
+	PreviousKeys []string `yaml:"previous_keys" toml:"previous_keys" env:"ENCRYPTION_PREVIOUS_KEYS" secret:"true"`
+}
+
+type Mongo struct {
+	// Service is a host:port, a mongodb:// URI or a service to find in consul
+	Service        string        `yaml:"service" toml:"service" env:"MONGODB_SERVICE" flag:"mongo" usage:"MongoDB host:port, URI or consul service name" secret:"url"`
+	Database       string        `yaml:"database" toml:"database" env:"MONGODB_DATABASE" flag:"mongo-database" usage:"MongoDB database name"`
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout" flag:"mongo-timeout" usage:"deadline for each MongoDB operation"`
+	MaxPool        uint64        `yaml:"max_pool" toml:"max_pool" flag:"mongo-max-pool" usage:"maximum number of MongoDB connections"`
+	MinPool        uint64        `yaml:"min_pool" toml:"min_pool" flag:"mongo-min-pool" usage:"number of MongoDB connections kept open when idle"`
+	ReadConcern    string        `yaml:"read_concern" toml:"read_concern" flag:"mongo-read-concern" usage:"MongoDB read concern level [local, majority, linearizable, available, snapshot]"`
+	WriteConcern   string        `yaml:"write_concern" toml:"write_concern" flag:"mongo-write-concern" usage:"MongoDB write concern, majority or a number of nodes"`
+	ReadPreference string        `yaml:"read_preference" toml:"read_preference" flag:"mongo-read-preference" usage:"MongoDB read preference [primary, primaryPreferred, secondary, secondaryPreferred, nearest]"`
+}
+
+type SQL struct {
+	DSN      string        `yaml:"dsn" toml:"dsn" env:"SQL_DSN" flag:"sql-dsn" usage:"postgres connection string or sqlite database file" secret:"url"`
+	Timeout  time.Duration `yaml:"timeout" toml:"timeout" flag:"sql-timeout" usage:"deadline for each SQL statement"`
+	MaxConns int           `yaml:"max_conns" toml:"max_conns" flag:"sql-max-conns" usage:"maximum number of open postgres connections, 0 for no limit"`
+}
+
+// Gateway selects and configures the API gateway driver
+type Gateway struct {
+	Driver            string        `yaml:"driver" toml:"driver" env:"AUTH_DRIVER" flag:"driver" usage:"gateway driver to use [none, kong, tyk]"`
+	ReconcileInterval time.Duration `yaml:"reconcile_interval" toml:"reconcile_interval" flag:"reconcile-interval" usage:"how often to reconcile tokens with the gateway, 0 to disable"`
+	Kong              Kong          `yaml:"kong" toml:"kong"`
+	Tyk               Tyk           `yaml:"tyk" toml:"tyk"`
+}
+
+type Kong struct {
+	API          string `yaml:"api" toml:"api" env:"KONG_API"`
+	Admin        string `yaml:"admin" toml:"admin" env:"KONG_ADMIN"`
+	AccessKey    string `yaml:"access_key" toml:"access_key" env:"KONG_ACCESS_KEY" secret:"true"`
+	AccessHeader string `yaml:"access_header" toml:"access_header" env:"KONG_ACCESS_HEADER"`
+	ProvisionKey string `yaml:"provision_key" toml:"provision_key" env:"KONG_PROVISION_KEY" secret:"true"`
+}
+
+type Tyk struct {
+	URL             string `yaml:"url" toml:"url" env:"TYK_URL"`
+	Org             string `yaml:"org" toml:"org" env:"TYK_ORG"`
+	Key             string `yaml:"key" toml:"key" env:"TYK_KEY" secret:"true"`
+	API             string `yaml:"api" toml:"api" env:"TYK_API"`
+	APIKey          string `yaml:"api_key" toml:"api_key" env:"TYK_API_KEY" secret:"true"`
+	SharedSecret    string `yaml:"shared_secret" toml:"shared_secret" env:"TYK_SHARED_SECRET" secret:"true"`
+	OAuthAPIID      string `yaml:"oauth_api_id" toml:"oauth_api_id" env:"TYK_OAUTH_API_ID"`
+	OAuthListenPath string `yaml:"oauth_listen_path" toml:"oauth_listen_path" env:"TYK_OAUTH_LISTEN_PATH"`
+
+	// Service is a consul service the gateway is found as, instead of at URL
+	Service string `yaml:"service" toml:"service" env:"TYK_SERVICE"`
+
+	// Policies are role=policy pairs, applying policies defined on the
+	// gateway to the keys of users with the role
+	Policies []string `yaml:"policies" toml:"policies" env:"TYK_POLICIES"`
+
+	// DefaultPolicy is applied to keys no other policy gives access to,
+	// without one such keys aren't issued as Tyk would let them call every API
+	DefaultPolicy string `yaml:"default_policy" toml:"default_policy" env:"TYK_DEFAULT_POLICY"`
+
+	// Limits are given to keys whose policies set none
+	Limits TykLimits `yaml:"limits" toml:"limits"`
+}
+
+// TykLimits are the rate limit and quota of a key, a quota_max of -1 is no
+// quota
+type TykLimits struct {
+	Rate             int `yaml:"rate" toml:"rate" env:"TYK_KEY_RATE"`
+	Per              int `yaml:"per" toml:"per" env:"TYK_KEY_PER"`
+	QuotaMax         int `yaml:"quota_max" toml:"quota_max" env:"TYK_KEY_QUOTA_MAX"`
+	QuotaRenewalRate int `yaml:"quota_renewal_rate" toml:"quota_renewal_rate" env:"TYK_KEY_QUOTA_RENEWAL_RATE"`
+}
+
+// RolePolicies are the gateway policies applied for each role
+func (t Tyk) RolePolicies() (map[string][]string, error) {
+	policies := map[string][]string{}
+	for _, p := range t.Policies {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("policies %q isn't a role=policy pair", p)
+		}
+
+		role := strings.TrimSpace(parts[0])
+		policies[role] = append(policies[role], strings.TrimSpace(parts[1]))
+	}
+
+	return policies, nil
+}
+
+// Tokens configures the access tokens handed to users
+type Tokens struct {
+	Lifetime        time.Duration `yaml:"lifetime" toml:"lifetime" env:"TOKEN_LIFETIME" flag:"token-lifetime" usage:"how long access tokens are valid for"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" toml:"cleanup_interval" flag:"token-cleanup-interval" usage:"how often expired tokens and device codes are removed, 0 to disable"`
+	Cookie          Cookie        `yaml:"cookie" toml:"cookie"`
+}
+
+// Cookie configures the cookie the token is also set in
+type Cookie struct {
+	Name   string `yaml:"name" toml:"name" env:"COOKIE_NAME"`
+	Path   string `yaml:"path" toml:"path" env:"COOKIE_PATH"`
+	Domain string `yaml:"domain" toml:"domain" env:"COOKIE_DOMAIN"`
+	// Lifetime of 0 makes it a session cookie
+	Lifetime time.Duration `yaml:"lifetime" toml:"lifetime" env:"COOKIE_LIFETIME"`
+	Secure   bool          `yaml:"secure" toml:"secure" env:"COOKIE_SECURE"`
+	HTTPOnly bool          `yaml:"http_only" toml:"http_only" env:"COOKIE_HTTP_ONLY"`
+	// SameSite is lax, strict or none, the browser's default when empty
+	SameSite string `yaml:"same_site" toml:"same_site" env:"COOKIE_SAME_SITE"`
+}
+
+type CORS struct {
+	AllowOrigins     []string `yaml:"allow_origins" toml:"allow_origins" env:"CORS_ALLOW_ORIGINS" flag:"cors-allow-origins" usage:"comma separated origins allowed to make cross site requests"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           int      `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+// Consul configures registering the service in Consul and reading settings
+// from its key/value store. Consul services are also used for storage and
+// the gateway when they are given by name.
+type Consul struct {
+	Register      bool          `yaml:"register" toml:"register" env:"CONSUL_REGISTER" flag:"consul-register" usage:"register the service in consul, with a health check"`
+	Service       string        `yaml:"service" toml:"service" env:"CONSUL_SERVICE"`
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval" env:"CONSUL_CHECK_INTERVAL"`
+
+	// Address is where other services reach this one, the agent's node
+	// address when empty
+	Address string `yaml:"address" toml:"address" env:"CONSUL_SERVICE_ADDRESS"`
+
+	// KV is a key/value store prefix to read settings from, named by their
+	// path in the configuration file such as cors/allow_origins. They are
+	// watched and override every other source.
+	KV string `yaml:"kv" toml:"kv" env:"CONSUL_KV" flag:"consul-kv" usage:"consul key/value prefix to read settings from, none when empty"`
+}
+
+// Default is the configuration before anything is loaded
+func Default() *Config {
+	return &Config{
+		Listen:    ":8080",
+		LogFormat: "json",
+		Schema:    "./schema",
+		Fixtures:  "./fixtures",
+		API:       "./api/auth-service.raml",
+
+		ShutdownTimeout: 10 * time.Second,
+		Storage: Storage{
+			Backend: "mongo",
+			Mongo: Mongo{
+				Service:  "localhost:27017",
+				Database: "auth",
+				Timeout:  5 * time.Second,
+				MaxPool:  100,
+			},
+			SQL: SQL{
+				Timeout: 5 * time.Second,
+			},
+		},
+		Gateway: Gateway{
+			ReconcileInterval: 10 * time.Minute,
+			Kong: Kong{
+				AccessHeader: "Kong-Admin-Token",
+			},
+			Tyk: Tyk{
+				Limits: TykLimits{
+					Rate:             1000,
+					Per:              60,
+					QuotaMax:         -1,
+					QuotaRenewalRate: 60,
+				},
+			},
+		},
+		Tokens: Tokens{
+			Lifetime:        7 * 24 * time.Hour,
+			CleanupInterval: time.Minute,
+			Cookie: Cookie{
+				Name:     "2immerse_token",
+				Path:     "/",
+				Lifetime: 36 * time.Hour,
+			},
+		},
+		CORS: CORS{
+			AllowOrigins:     []string{"*"},
+			AllowCredentials: true,
+			MaxAge:           3600,
+		},
+		Consul: Consul{
+			Service:       "auth-service",
+			CheckInterval: 10 * time.Second,
+		},
+	}
+}
+
+// Validate checks the configuration makes sense, reporting every problem
+// rather than just the first
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(len(c.Listen) > 0, "listen must be set")
+	check(c.LogFormat == "json" || c.LogFormat == "text", "log_format %q isn't one of json or text", c.LogFormat)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+
+	switch c.Storage.Backend {
+	case "mongo":
+		check(len(c.Storage.Mongo.Service) > 0, "storage.mongo.service must be set")
+		check(len(c.Storage.Mongo.Database) > 0, "storage.mongo.database must be set")
+		check(c.Storage.Mongo.Timeout > 0, "storage.mongo.timeout must be positive")
+		check(c.Storage.Mongo.MinPool <= c.Storage.Mongo.MaxPool || c.Storage.Mongo.MaxPool == 0,
+			"storage.mongo.min_pool can't be more than max_pool")
+	case "postgres":
+		check(len(c.Storage.SQL.DSN) > 0, "storage.sql.dsn must be set for postgres")
+	case "sqlite", "memory":
+	default:
+		check(false, "storage.backend %q isn't one of mongo, postgres, sqlite or memory", c.Storage.Backend)
+	}
+
+	if len(c.Encryption.Key) > 0 {
+		for _, key := range append([]string{c.Encryption.Key}, c.Encryption.PreviousKeys...) {
+			if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 32 {
+				check(false, "encryption keys must be 32 bytes encoded in base64, as made by `openssl rand -base64 32`")
+				break
+			}
+		}
+	} else {
+		check(len(c.Encryption.PreviousKeys) == 0, "encryption.previous_keys need encryption.key to be set")
+	}
+
+	switch c.Gateway.Driver {
+	case "", "none":
+	case "kong":
+		check(len(c.Gateway.Kong.API) > 0, "gateway.kong.api (KONG_API) must be set")
+		check(len(c.Gateway.Kong.Admin) > 0, "gateway.kong.admin (KONG_ADMIN) must be set")
+	case "tyk":
+		check(len(c.Gateway.Tyk.URL) > 0 || len(c.Gateway.Tyk.Service) > 0, "gateway.tyk.url (TYK_URL) or gateway.tyk.service (TYK_SERVICE) must be set")
+		check(len(c.Gateway.Tyk.Org) > 0, "gateway.tyk.org (TYK_ORG) must be set")
+		check(len(c.Gateway.Tyk.Key) > 0, "gateway.tyk.key (TYK_KEY) must be set")
+		if _, err := c.Gateway.Tyk.RolePolicies(); err != nil {
+			check(false, "gateway.tyk.%v", err)
+		}
+		check(c.Gateway.Tyk.Limits.Rate > 0 && c.Gateway.Tyk.Limits.Per > 0, "gateway.tyk.limits.rate and per must be positive")
+		check(c.Gateway.Tyk.Limits.QuotaMax >= -1, "gateway.tyk.limits.quota_max must be -1 for no quota or more")
+	default:
+		check(false, "gateway.driver %q isn't one of none, kong or tyk", c.Gateway.Driver)
+	}
+	check(c.Gateway.ReconcileInterval >= 0, "gateway.reconcile_interval can't be negative")
+
+	check(c.Tokens.Lifetime > 0, "tokens.lifetime must be positive")
+	check(c.Tokens.CleanupInterval >= 0, "tokens.cleanup_interval can't be negative")
+	check(len(c.Tokens.Cookie.Name) > 0, "tokens.cookie.name must be set")
+	check(c.Tokens.Cookie.Lifetime >= 0, "tokens.cookie.lifetime can't be negative")
+	if _, err := c.Tokens.Cookie.SameSiteMode(); err != nil {
+		check(false, "tokens.cookie.%v", err)
+	}
+
+	check(len(c.CORS.AllowOrigins) > 0, "cors.allow_origins must list at least one origin, * for any")
+	check(c.CORS.MaxAge >= 0, "cors.max_age can't be negative")
+
+	if c.Consul.Register {
+		check(len(c.Consul.Service) > 0, "consul.service must be set to register")
+		check(c.Consul.CheckInterval > 0, "consul.check_interval must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// SameSiteMode is the http.SameSite the cookie should be set with
+func (c Cookie) SameSiteMode() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+
+	return 0, fmt.Errorf("same_site %q isn't one of lax, strict or none", c.SameSite)
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "sync/atomic"
+
+// Holder keeps the configuration in effect so it can be replaced by a reload
+// while requests are reading it
+type Holder struct {
+	current atomic.Value
+}
+
+// NewHolder returns a holder with c in effect
+func NewHolder(c *Config) *Holder {
+	h := &Holder{}
+	h.Set(c)
+	return h
+}
+
+// Get returns the configuration in effect, which must not be modified
+func (h *Holder) Get() *Config {
+	return h.current.Load().(*Config)
+}
+
+// Set puts c in effect for everything calling Get from now on
+func (h *Holder) Set(c *Config) {
+	h.current.Store(c)
+}
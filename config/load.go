@@ -0,0 +1,310 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from the defaults, the file named by
+// -config or AUTH_CONFIG, the environment and then the flags in args, which
+// are parsed with fs. Flags for every setting tagged with one are added to
+// fs first.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+
+	file := fs.String("config", os.Getenv("AUTH_CONFIG"), "YAML or TOML file to read the configuration from")
+	bindFlags(fs, reflect.ValueOf(c).Elem())
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// the flags given win over the file and environment, so they are set
+	// again once those are read
+	given := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	if len(*file) > 0 {
+		if err := LoadFile(*file, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem()); err != nil {
+		return nil, err
+	}
+
+	for name, value := range given {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("-%s: %v", name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// LoadFile reads a YAML or TOML file, chosen by its extension, over c.
+// Settings missing from the file are left as they were.
+func LoadFile(path string, c *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		_, err = toml.Decode(string(data), c)
+	default:
+		return fmt.Errorf("config %s: unknown format, use .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// ApplyKV sets settings from a key/value store, each named by its path in
+// a YAML file such as cors/allow_origins. Lists are comma separated. Keys
+// that aren't settings are reported rather than ignored.
+func ApplyKV(c *Config, values map[string]string) error {
+	fields := map[string]reflect.Value{}
+	paths(reflect.ValueOf(c).Elem(), "", fields)
+
+	problems := []string{}
+	for key, s := range values {
+		v, ok := fields[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: no such setting", key))
+			continue
+		}
+
+		if err := set(v, strings.TrimSpace(s)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("settings in consul: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// paths collects every setting of the struct v by its path in a YAML file
+func paths(v reflect.Value, prefix string, fields map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if field.Type.Kind() == reflect.Struct {
+			paths(v.Field(i), name+"/", fields)
+			continue
+		}
+
+		fields[name] = v.Field(i)
+	}
+}
+
+// applyEnv sets every field tagged env whose variable is set
+func applyEnv(v reflect.Value) error {
+	return walk(v, func(field reflect.StructField, value reflect.Value) error {
+		name := field.Tag.Get("env")
+		if len(name) == 0 {
+			return nil
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok && len(field.Tag.Get("secret")) > 0 {
+			// mounted secrets, as docker and kubernetes provide them
+			file, found := os.LookupEnv(name + "_FILE")
+			if !found {
+				return nil
+			}
+
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %v", name, err)
+			}
+
+			env = strings.TrimSpace(string(data))
+			if value.Kind() == reflect.Slice {
+				env = strings.Join(strings.Fields(env), ",")
+			}
+		} else if !ok {
+			return nil
+		}
+
+		if err := set(value, env); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		return nil
+	})
+}
+
+// bindFlags adds a flag to fs for every field tagged with one
+func bindFlags(fs *flag.FlagSet, v reflect.Value) {
+	walk(v, func(field reflect.StructField, value reflect.Value) error {
+		name := field.Tag.Get("flag")
+		if len(name) == 0 {
+			return nil
+		}
+
+		usage := field.Tag.Get("usage")
+		if env := field.Tag.Get("env"); len(env) > 0 {
+			usage += " (" + env + ")"
+		}
+
+		if value.Kind() == reflect.Bool {
+			fs.Var(boolValue{value}, name, usage)
+		} else {
+			fs.Var(fieldValue{value}, name, usage)
+		}
+
+		return nil
+	})
+}
+
+// walk calls f with every field of the struct v that isn't itself a struct
+func walk(v reflect.Value, f func(reflect.StructField, reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := walk(value, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f(field, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into the field v
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+
+	return nil
+}
+
+// fieldValue lets a config field be set as a flag
+type fieldValue struct {
+	v reflect.Value
+}
+
+func (f fieldValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+
+	if f.v.Kind() == reflect.Slice {
+		return strings.Join(f.v.Interface().([]string), ",")
+	}
+
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f fieldValue) Set(s string) error {
+	return set(f.v, s)
+}
+
+// boolValue is a fieldValue that can be given without a value, as -debug
+type boolValue fieldValue
+
+func (b boolValue) String() string {
+	if !b.v.IsValid() {
+		return "false"
+	}
+
+	return fieldValue(b).String()
+}
+
+func (b boolValue) Set(s string) error {
+	return set(b.v, s)
+}
+
+func (b boolValue) IsBoolFlag() bool {
+	return true
+}
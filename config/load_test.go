@@ -0,0 +1,276 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes data to a file named name in a directory removed when the
+// test ends
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "auth.yaml", "listen: file:8080\nlog_format: text\n")
+	tomlFile := writeFile(t, "auth.toml", "listen = \"file:8080\"\nlog_format = \"text\"\n")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+
+		listen    string
+		logFormat string
+	}{
+		{name: "defaults", listen: ":8080", logFormat: "json"},
+		{name: "yaml file", args: []string{"-config", yamlFile}, listen: "file:8080", logFormat: "text"},
+		{name: "toml file", args: []string{"-config", tomlFile}, listen: "file:8080", logFormat: "text"},
+		{name: "file from env", env: map[string]string{"AUTH_CONFIG": yamlFile}, listen: "file:8080", logFormat: "text"},
+		{name: "env over defaults", env: map[string]string{"LISTEN": "env:8080"}, listen: "env:8080", logFormat: "json"},
+		{
+			name:      "env over file",
+			env:       map[string]string{"LISTEN": "env:8080"},
+			args:      []string{"-config", yamlFile},
+			listen:    "env:8080",
+			logFormat: "text",
+		},
+		{
+			name:      "flag over env",
+			env:       map[string]string{"LISTEN": "env:8080", "LOG_FORMAT": "json"},
+			args:      []string{"-config", yamlFile, "-listen", "flag:8080"},
+			listen:    "flag:8080",
+			logFormat: "json",
+		},
+		{name: "flag over file", args: []string{"-listen", "flag:8080", "-config", yamlFile}, listen: "flag:8080", logFormat: "text"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			c, err := Load(flag.NewFlagSet("auth", flag.ContinueOnError), test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Listen != test.listen || c.LogFormat != test.logFormat {
+				t.Errorf("got listen %q and log format %q, expected %q and %q",
+					c.Listen, c.LogFormat, test.listen, test.logFormat)
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	t.Setenv("DEBUG", "true")
+	t.Setenv("SHUTDOWN_TIMEOUT", "3s")
+	t.Setenv("CORS_ALLOW_ORIGINS", "https://a.example.com,https://b.example.com")
+
+	c, err := Load(flag.NewFlagSet("auth", flag.ContinueOnError), []string{"-procs", "2", "-mongo-max-pool", "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Debug || c.ShutdownTimeout != 3*time.Second || c.Procs != 2 || c.Storage.Mongo.MaxPool != 50 {
+		t.Errorf("got debug %t, shutdown timeout %s, procs %d and max pool %d",
+			c.Debug, c.ShutdownTimeout, c.Procs, c.Storage.Mongo.MaxPool)
+	}
+	if origins := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(c.CORS.AllowOrigins, origins) {
+		t.Errorf("got origins %v, expected %v", c.CORS.AllowOrigins, origins)
+	}
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "soon")
+	if _, err := Load(flag.NewFlagSet("auth", flag.ContinueOnError), nil); err == nil {
+		t.Error("loaded an invalid duration")
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	key := writeFile(t, "tyk-key", "file-secret\n")
+	previous := writeFile(t, "previous-keys", "key-a\nkey-b\n")
+	listen := writeFile(t, "listen", "file:8080")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+
+		key      string
+		previous []string
+		listen   string
+		err      bool
+	}{
+		{name: "none", listen: ":8080"},
+		{name: "secret", env: map[string]string{"TYK_KEY_FILE": key}, key: "file-secret", listen: ":8080"},
+		{
+			name:     "list",
+			env:      map[string]string{"ENCRYPTION_PREVIOUS_KEYS_FILE": previous},
+			previous: []string{"key-a", "key-b"},
+			listen:   ":8080",
+		},
+		{
+			name:   "variable over file",
+			env:    map[string]string{"TYK_KEY": "env-secret", "TYK_KEY_FILE": key},
+			key:    "env-secret",
+			listen: ":8080",
+		},
+		{name: "not a secret", env: map[string]string{"LISTEN_FILE": listen}, listen: ":8080"},
+		{name: "missing file", env: map[string]string{"TYK_KEY_FILE": filepath.Join(t.TempDir(), "missing")}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			c, err := Load(flag.NewFlagSet("auth", flag.ContinueOnError), nil)
+			if test.err {
+				if err == nil {
+					t.Error("loaded with a secret file that can't be read")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Gateway.Tyk.Key != test.key {
+				t.Errorf("got key %q, expected %q", c.Gateway.Tyk.Key, test.key)
+			}
+			if len(c.Encryption.PreviousKeys) != 0 || len(test.previous) != 0 {
+				if !reflect.DeepEqual(c.Encryption.PreviousKeys, test.previous) {
+					t.Errorf("got previous keys %q, expected %q", c.Encryption.PreviousKeys, test.previous)
+				}
+			}
+			if c.Listen != test.listen {
+				t.Errorf("got listen %q, expected %q", c.Listen, test.listen)
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      func(c *Config)
+		get      func(c *Config) interface{}
+		expected interface{}
+	}{
+		{
+			name:     "secret",
+			set:      func(c *Config) { c.Gateway.Tyk.Key = "secret" },
+			get:      func(c *Config) interface{} { return c.Gateway.Tyk.Key },
+			expected: "REDACTED",
+		},
+		{
+			name:     "empty secret",
+			set:      func(c *Config) { c.Gateway.Kong.ProvisionKey = "" },
+			get:      func(c *Config) interface{} { return c.Gateway.Kong.ProvisionKey },
+			expected: "",
+		},
+		{
+			name:     "encryption key",
+			set:      func(c *Config) { c.Encryption.Key = "c2VjcmV0" },
+			get:      func(c *Config) interface{} { return c.Encryption.Key },
+			expected: "REDACTED",
+		},
+		{
+			name:     "secret list",
+			set:      func(c *Config) { c.Encryption.PreviousKeys = []string{"a", "b"} },
+			get:      func(c *Config) interface{} { return c.Encryption.PreviousKeys },
+			expected: []string{"REDACTED", "REDACTED"},
+		},
+		{
+			name:     "url password",
+			set:      func(c *Config) { c.Storage.SQL.DSN = "postgres://auth:secret@db:5432/auth?sslmode=disable" },
+			get:      func(c *Config) interface{} { return c.Storage.SQL.DSN },
+			expected: "postgres://auth:REDACTED@db:5432/auth?sslmode=disable",
+		},
+		{
+			name:     "key=value password",
+			set:      func(c *Config) { c.Storage.SQL.DSN = "host=db user=auth password=secret dbname=auth" },
+			get:      func(c *Config) interface{} { return c.Storage.SQL.DSN },
+			expected: "host=db user=auth password=REDACTED dbname=auth",
+		},
+		{
+			name:     "url without password",
+			set:      func(c *Config) { c.Storage.Mongo.Service = "mongodb://db:27017/auth" },
+			get:      func(c *Config) interface{} { return c.Storage.Mongo.Service },
+			expected: "mongodb://db:27017/auth",
+		},
+		{
+			name:     "mongo password",
+			set:      func(c *Config) { c.Storage.Mongo.Service = "mongodb://auth:secret@db:27017/auth" },
+			get:      func(c *Config) interface{} { return c.Storage.Mongo.Service },
+			expected: "mongodb://auth:REDACTED@db:27017/auth",
+		},
+		{
+			name:     "not a secret",
+			set:      func(c *Config) { c.Gateway.Tyk.Org = "org" },
+			get:      func(c *Config) interface{} { return c.Gateway.Tyk.Org },
+			expected: "org",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Default()
+			test.set(c)
+			original := test.get(c)
+
+			if got := test.get(c.Redacted()); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+			if got := test.get(c); !reflect.DeepEqual(got, original) {
+				t.Errorf("redacting changed the configuration to %q", got)
+			}
+		})
+	}
+}
+
+func TestWriteRedacted(t *testing.T) {
+	c := Default()
+	c.Encryption.Key = "encryption-hunter2"
+	c.Encryption.PreviousKeys = []string{"previous-hunter2"}
+	c.Storage.SQL.DSN = "postgres://auth:dsn-hunter2@db/auth"
+	c.Gateway.Kong.AccessKey = "kong-hunter2"
+	c.Gateway.Tyk.SharedSecret = "tyk-hunter2"
+
+	for _, format := range []string{"yaml", "toml"} {
+		b := &bytes.Buffer{}
+		if err := c.Redacted().Write(b, format); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(b.String(), "hunter2") {
+			t.Errorf("%s: secrets printed:\n%s", format, b)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+var passwordPattern = regexp.MustCompile(`(?i)(password=)\S+`)
+
+// Redacted returns a copy of the configuration that is safe to print, with
+// secrets and the passwords in connection strings replaced
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.CORS.AllowOrigins = append([]string{}, c.CORS.AllowOrigins...)
+
+	walk(reflect.ValueOf(&r).Elem(), func(field reflect.StructField, value reflect.Value) error {
+		if value.Kind() == reflect.Slice && field.Tag.Get("secret") == "true" {
+			hidden := make([]string, value.Len())
+			for i := range hidden {
+				hidden[i] = redacted
+			}
+			value.Set(reflect.ValueOf(hidden))
+			return nil
+		}
+
+		if value.Kind() != reflect.String || len(value.String()) == 0 {
+			return nil
+		}
+
+		switch field.Tag.Get("secret") {
+		case "true":
+			value.SetString(redacted)
+		case "url":
+			value.SetString(redactURL(value.String()))
+		}
+
+		return nil
+	})
+
+	return &r
+}
+
+// redactURL hides the password of a URL or key=value connection string
+func redactURL(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			return u.String()
+		}
+	}
+
+	return passwordPattern.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// Write encodes the configuration as yaml or toml
+func (c *Config) Write(w io.Writer, format string) error {
+	switch format {
+	case "yaml", "yml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "toml":
+		return toml.NewEncoder(w).Encode(c)
+	}
+
+	return fmt.Errorf("unknown format %s, use yaml or toml", format)
+}
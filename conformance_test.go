@@ -0,0 +1,454 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/api"
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/health"
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+	"github.com/2-IMMERSE/auth-service/tools"
+)
+
+// A step is a request replayed against the service by TestConformance. Values
+// saved from earlier responses are available to later steps as {name}.
+type step struct {
+	route string
+	as    string
+	body  string
+	form  bool
+	raw   bool
+
+	status int
+
+	// save maps a name to a field of the response, such as id or 0.id
+	save map[string]string
+}
+
+// steps go through every documented route, most of them as a user would
+// use them and some with requests that must be turned away
+var steps = []step{
+	{route: "GET /healthcheck", status: 204},
+	{route: "GET /healthcheck/live", status: 204},
+	{route: "GET /healthcheck/ready", status: 200},
+	{route: "GET /healthcheck/reload", status: 200},
+	{route: "GET /openapi.json", status: 200},
+	{route: "GET /metrics", status: 200},
+
+	{route: "POST /users", body: `{"email": "user@example.com", "password": "secret", "display_name": "User"}`, status: 201, save: map[string]string{"user": "id"}},
+	{route: "POST /users", body: `{"email": "other@example.com"}`, status: 422},
+	{route: "POST /users", body: `{"email": "other@example.com", "password": "secret", "roles": ["ROLE_ADMIN"]}`, status: 422},
+	{route: "POST /users", body: `{"email": "user@example.com", "password": "secret"}`, status: 409},
+	{route: "POST /auth/tokens", body: `{"username": "admin@example.com", "password": "secret"}`, status: 201, save: map[string]string{"admin": "access_token"}},
+	{route: "POST /auth/tokens", body: "username=user@example.com&password=secret&scope=conformance", form: true, status: 201, save: map[string]string{"token": "access_token"}},
+	{route: "POST /auth/tokens", body: `{"username": "user@example.com", "password": "wrong"}`, status: 400},
+
+	{route: "GET /me", status: 401},
+	{route: "GET /me", as: "token", status: 200},
+	{route: "PATCH /me", as: "token", body: `{"first_name": "Con"}`, status: 204},
+	{route: "PATCH /me", as: "token", body: `{"roles": ["ROLE_ADMIN"]}`, status: 422},
+	{route: "GET /me/profile", as: "token", status: 200},
+	{route: "PATCH /me/profile", as: "token", body: `{"communal": {"volume": 3}}`, status: 204},
+	{route: "GET /me/roles", as: "token", status: 200},
+	{route: "GET /me/groups", as: "token", status: 200},
+
+	{route: "GET /users", as: "token", status: 403},
+	{route: "GET /users", as: "admin", status: 200},
+	{route: "GET /users/{user}", as: "admin", status: 200},
+	{route: "GET /users/{missing}", as: "admin", status: 404},
+	{route: "PATCH /users/{user}", as: "admin", body: `{"groups": ["conformance"]}`, status: 204},
+	{route: "PATCH /users/{user}", as: "admin", body: `{}`, status: 422},
+	{route: "GET /users/{user}/profile", as: "admin", status: 200},
+	{route: "PATCH /users/{user}/profile", as: "admin", body: `{"companion": {}}`, status: 204},
+	{route: "GET /users/{user}/roles", as: "admin", status: 200},
+	{route: "GET /users/{user}/groups", as: "admin", status: 200},
+
+	{route: "POST /devices", body: `{}`, status: 201, save: map[string]string{"device": "id", "code": "code"}},
+	{route: "POST /devices", body: `{"type": "tablet"}`, status: 422},
+	{route: "GET /devices/{device}", status: 204},
+	{route: "POST /me/link", as: "token", body: `{"code": "{code}"}`, status: 204},
+	{route: "POST /me/link", as: "token", body: `{"code": "unknown"}`, status: 404},
+	{route: "GET /devices/{device}", status: 200},
+	{route: "GET /devices", as: "token", status: 200},
+
+	{route: "POST /keys", body: "key data", raw: true, status: 201, save: map[string]string{"key": "id"}},
+	{route: "GET /keys", as: "admin", status: 200},
+	{route: "GET /keys/{key}", as: "admin", status: 200},
+	{route: "GET /keys/{missing}", as: "admin", status: 404},
+
+	{route: "POST /clients", as: "admin", body: `{"name": "conformance"}`, status: 201, save: map[string]string{"client": "id"}},
+	{route: "POST /clients", as: "admin", body: `{}`, status: 400},
+	{route: "GET /clients", as: "admin", status: 200},
+	{route: "GET /clients/{client}", as: "admin", status: 200},
+
+	{route: "POST /policies", as: "admin", body: `{"name": "conformance", "roles": ["ROLE_USER"], "rate": 10, "per": 60}`, status: 201, save: map[string]string{"policy": "id"}},
+	{route: "POST /policies", as: "admin", body: `{"rate": 10}`, status: 400},
+	{route: "GET /policies", as: "admin", status: 200},
+	{route: "GET /policies/{policy}", as: "admin", status: 200},
+	{route: "PUT /policies/{policy}", as: "admin", body: `{"name": "conformance", "priority": 1}`, status: 204},
+
+	{route: "POST /webhooks", as: "admin", body: `{"url": "https://example.com/hook", "events": ["user.updated"], "active": true}`, status: 201, save: map[string]string{"webhook": "id"}},
+	{route: "POST /webhooks", as: "admin", body: `{"url": "example"}`, status: 400},
+	{route: "GET /webhooks", as: "admin", status: 200},
+	{route: "GET /webhooks/{webhook}", as: "admin", status: 200},
+	{route: "PATCH /me", as: "token", body: `{"last_name": "Formance"}`, status: 204},
+	{route: "GET /webhooks/{webhook}/deliveries", as: "admin", status: 200, save: map[string]string{"delivery": "0.id"}},
+	{route: "POST /webhooks/{webhook}/deliveries/{delivery}/redeliver", as: "admin", status: 202},
+	{route: "PATCH /webhooks/{webhook}", as: "admin", body: `{"url": "https://example.com/hook", "active": false}`, status: 204},
+
+	{route: "POST /admin/reload", as: "token", status: 403},
+	{route: "POST /admin/reload", as: "admin", status: 200},
+
+	{route: "DELETE /webhooks/{webhook}", as: "admin", status: 204},
+	{route: "DELETE /policies/{policy}", as: "admin", status: 204},
+	{route: "DELETE /clients/{client}", as: "admin", status: 204},
+	{route: "DELETE /devices/{device}", as: "token", status: 204},
+	{route: "POST /auth/revoke", as: "token", body: `{}`, status: 422},
+	{route: "POST /auth/revoke", as: "token", body: `{"token": "{token}"}`, status: 204},
+	{route: "GET /me", as: "token", status: 403},
+	{route: "DELETE /users/{user}", as: "admin", status: 204},
+}
+
+// conformance replays the steps against the service, collecting the ways in
+// which it differs from the API spec
+type conformance struct {
+	spec     *api.Spec
+	e        *echo.Echo
+	vars     map[string]string
+	problems []string
+}
+
+func (c *conformance) problem(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func TestConformance(t *testing.T) {
+	// the steps make requests that are meant to fail, which get logged
+	if !testing.Verbose() {
+		logrus.SetOutput(ioutil.Discard)
+		defer logrus.SetOutput(os.Stderr)
+	}
+
+	settings := config.Default()
+
+	spec, err := api.Load(settings.API)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &conformance{
+		spec: spec,
+		vars: map[string]string{"missing": primitive.NewObjectID().Hex()},
+	}
+
+	c.checkSchemas(settings.Schema)
+
+	if err := c.setup(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	c.checkRoutes()
+	c.checkDescriptions()
+
+	for _, s := range steps {
+		status := c.replay(s)
+		t.Logf("%d %s", status, s.route)
+	}
+
+	for _, p := range c.problems {
+		t.Error(p)
+	}
+}
+
+// checkSchemas makes sure the schemas in dir are the ones the spec generates
+func (c *conformance) checkSchemas(dir string) {
+	files := map[string]interface{}{api.RoutesFile: c.spec.Routes()}
+	for name, schema := range c.spec.Schemas() {
+		files[name+".json"] = schema
+	}
+
+	for name, generated := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			c.problem("%s: missing, run api schemas", name)
+			continue
+		}
+
+		if !sameJSON(data, generated) {
+			c.problem("%s: out of date, run api schemas", name)
+		}
+	}
+}
+
+func sameJSON(data []byte, v interface{}) bool {
+	expected, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+
+	var a, b interface{}
+	if json.Unmarshal(data, &a) != nil || json.Unmarshal(expected, &b) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// setup builds the service on memory storage and a fake gateway, with an
+// admin to make requests as
+func (c *conformance) setup(settings *config.Config) error {
+	store := memory.New()
+
+	admin := model.User{
+		ID:            primitive.NewObjectID(),
+		Email:         "admin@example.com",
+		PlainPassword: "secret",
+		Roles:         []string{"ROLE_ADMIN"},
+	}
+	if err := admin.HashPassword(); err != nil {
+		return err
+	}
+	if err := store.Users().Create(context.Background(), admin); err != nil {
+		return err
+	}
+
+	holder := config.NewHolder(settings)
+
+	v, err := tools.NewValidator(settings.Schema)
+	if err != nil {
+		return err
+	}
+
+	driver := auth.NewSwitch(auth.NewFake())
+	cors := middleware.NewReloadable(corsMiddleware(settings.CORS))
+	reloader := newReloader(holder, cors, v, driver, store, nil, nil)
+
+	checker := health.NewChecker(health.DefaultTimeout)
+	addChecks(checker, store, driver, v)
+
+	c.e = newServer(store, driver, v, c.spec, holder, cors, reloader, checker, ioutil.Discard)
+
+	return nil
+}
+
+// checkRoutes compares the mounted routes with the documented ones
+func (c *conformance) checkRoutes() {
+	mounted := map[string]bool{}
+	for _, r := range api.Mounted(c.e) {
+		// gateway routes belong to the driver
+		if strings.HasPrefix(r.Path+"/", "/gateway/") {
+			continue
+		}
+
+		key := r.Method + " " + r.Path
+		mounted[key] = true
+		if _, ok := c.spec.Methods[key]; !ok {
+			c.problem("%s: mounted but not documented", key)
+		}
+	}
+
+	for _, m := range c.spec.Sorted() {
+		key := m.Method + " " + m.Path
+		if !mounted[key] && !strings.HasPrefix(m.Path, "/gateway/") {
+			c.problem("%s: documented but not mounted", key)
+		}
+	}
+}
+
+// checkDescriptions makes sure every operation served at /openapi.json is
+// described, which it is when the API spec describes the route
+func (c *conformance) checkDescriptions() {
+	rec := httptest.NewRecorder()
+	c.e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/openapi.json", nil))
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Description string `json:"description"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		c.problem("GET /openapi.json: %d: %v", rec.Code, err)
+		return
+	}
+
+	var missing []string
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			if len(strings.TrimSpace(op.Description)) == 0 {
+				missing = append(missing, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	sort.Strings(missing)
+
+	for _, route := range missing {
+		c.problem("%s: no description at /openapi.json, describe it in the API spec", route)
+	}
+}
+
+// replay makes the request of s, checking the response against the spec,
+// and returns its status
+func (c *conformance) replay(s step) int {
+	vars := make([]string, 0, 2*len(c.vars))
+	for name, value := range c.vars {
+		vars = append(vars, "{"+name+"}", value)
+	}
+	expand := strings.NewReplacer(vars...)
+
+	parts := strings.SplitN(s.route, " ", 2)
+	method, path := parts[0], expand.Replace(parts[1])
+
+	req := httptest.NewRequest(method, path, strings.NewReader(expand.Replace(s.body)))
+	switch {
+	case s.form:
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	case s.raw:
+		req.Header.Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	case len(s.body) > 0:
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if len(s.as) > 0 {
+		req.Header.Set(echo.HeaderAuthorization, c.vars[s.as])
+	}
+
+	rec := httptest.NewRecorder()
+	c.e.ServeHTTP(rec, req)
+
+	if rec.Code != s.status {
+		c.problem("%s: got %d, expected %d: %s", s.route, rec.Code, s.status, strings.TrimSpace(rec.Body.String()))
+		return rec.Code
+	}
+
+	var body interface{}
+	contentType := rec.Header().Get(echo.HeaderContentType)
+	isJSON := strings.HasPrefix(contentType, echo.MIMEApplicationJSON) || strings.HasPrefix(contentType, response.MIMEProblemJSON)
+	if isJSON {
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			c.problem("%s: response is not JSON: %v", s.route, err)
+			return rec.Code
+		}
+	}
+
+	// later steps need these whether or not the response is as documented
+	for name, field := range s.save {
+		value, ok := lookup(body, field)
+		if !ok {
+			c.problem("%s: response has no %s", s.route, field)
+			continue
+		}
+		c.vars[name] = value
+	}
+
+	m := c.documented(method, parts[1])
+	if m == nil {
+		c.problem("%s: not documented", s.route)
+		return rec.Code
+	}
+
+	r, ok := m.Responses[rec.Code]
+	if !ok {
+		c.problem("%s: status %d is not documented", s.route, rec.Code)
+		return rec.Code
+	}
+
+	if r.Body == nil || !isJSON {
+		return rec.Code
+	}
+
+	schema, _ := json.Marshal(c.spec.Schema(r.Body))
+	if err := tools.ValidateDocument(schema, body); err != nil {
+		c.problem("%s: %d response doesn't match %s: %v", s.route, rec.Code, describe(r.Body), err)
+	}
+
+	return rec.Code
+}
+
+// documented finds the method a step requests, {name} in its path standing
+// for any parameter
+func (c *conformance) documented(method, path string) *api.Method {
+	parts := strings.Split(path, "/")
+
+next:
+	for _, m := range c.spec.Methods {
+		route := strings.Split(m.Path, "/")
+		if m.Method != method || len(route) != len(parts) {
+			continue
+		}
+
+		for i := range parts {
+			param := strings.HasPrefix(route[i], ":") && strings.HasPrefix(parts[i], "{")
+			if route[i] != parts[i] && !param {
+				continue next
+			}
+		}
+
+		return m
+	}
+
+	return nil
+}
+
+func describe(t *api.Type) string {
+	if t.Type == "array" && t.Items != nil {
+		return describe(t.Items) + "[]"
+	}
+
+	return t.Type
+}
+
+// lookup finds a field in a decoded response, numbers indexing arrays
+func lookup(body interface{}, field string) (string, bool) {
+	for _, key := range strings.Split(field, ".") {
+		switch v := body.(type) {
+		case map[string]interface{}:
+			body = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(v) {
+				return "", false
+			}
+			body = v[i]
+		default:
+			return "", false
+		}
+	}
+
+	if body == nil {
+		return "", false
+	}
+
+	return fmt.Sprint(body), true
+}
@@ -15,67 +15,146 @@
 package consul
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/consul/api"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
 )
 
+// attempts is how many times a lookup is tried before giving up
+const attempts = 10
+
 // Client is a convenience wrapper for the consul api
 type Client struct {
 	consul *api.Client
+
+	// ctx is cancelled by Close, stopping every watch
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*Service
 }
 
-// NewClient creates a new consul api wrapper
+// NewClient connects to the local agent, found as CONSUL_HTTP_ADDR
+// describes, waiting for it to reach a leader
 func NewClient() (*Client, error) {
 	consul, err := api.NewClient(api.DefaultConfig())
 	if err != nil {
 		return nil, err
 	}
 
-	try := 0
-	for {
-		try++
+	for try := 0; ; try++ {
+		start := time.Now()
 		status, err := consul.Status().Leader()
-		if err != nil {
-			logrus.Infof("Consul: waiting... %s", err)
-			if try > 10 {
-				return nil, err
-			}
-		} else {
+		metrics.Consul.Observe("leader", start, err)
+
+		if err == nil {
 			logrus.Debugf("Consul: %s", status)
 			break
 		}
+
+		if try+1 >= attempts {
+			return nil, err
+		}
+
+		logrus.Infof("Consul: waiting... %s", err)
+		time.Sleep(backoff(try))
 	}
 
 	logrus.Debug("Consul: connected")
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		consul: consul,
+		consul:   consul,
+		ctx:      ctx,
+		cancel:   cancel,
+		services: map[string]*Service{},
 	}, nil
 }
 
-// LookupServicePort looks up the first port on the first node that a service is running on
-func (c *Client) LookupServicePort(name string) (int, error) {
+// Close stops watching services and settings
+func (c *Client) Close() {
+	c.cancel()
+}
+
+// Ping checks the agent can reach a Consul leader
+func (c *Client) Ping() error {
+	start := time.Now()
+	_, err := c.consul.Status().Leader()
+	metrics.Consul.Observe("leader", start, err)
+
+	return err
+}
+
+// Instances looks up the host:port of every healthy instance of a service,
+// retrying until there is at least one
+func (c *Client) Instances(name string) ([]string, error) {
 	logrus.Debugf("Consul: Looking up service %s...", name)
-	catalog := c.consul.Catalog()
-
-	try := 0
-	for {
-		try++
-		services, _, err := catalog.Service(name, "", nil)
-		if err != nil {
-			if try > 10 {
-				return 0, err
-			}
-		} else {
-			if len(services) < 1 {
-				if try > 10 {
-					return 0, fmt.Errorf("Consul: No services found called %s", name)
-				}
-			} else {
-				return services[0].ServicePort, nil
-			}
+
+	for try := 0; ; try++ {
+		instances, _, err := c.healthy(name, nil)
+		if err == nil && len(instances) == 0 {
+			err = fmt.Errorf("Consul: no healthy instances of %s", name)
+		}
+
+		if err == nil {
+			return instances, nil
+		}
+
+		if try+1 >= attempts {
+			return nil, err
 		}
+
+		logrus.Debugf("Consul: %s, retrying", err)
+		time.Sleep(backoff(try))
+	}
+}
+
+// healthy looks up the instances of a service passing their health checks
+func (c *Client) healthy(name string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	start := time.Now()
+	entries, meta, err := c.consul.Health().Service(name, "", true, q)
+	metrics.Consul.Observe("health_service", start, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instances := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// services registered without an address share their node's
+		address := e.Service.Address
+		if len(address) == 0 {
+			address = e.Node.Address
+		}
+
+		instances = append(instances, fmt.Sprintf("%s:%d", address, e.Service.Port))
 	}
+
+	return instances, meta, nil
+}
+
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// backoff is how long to wait before the next try, doubling from 100ms up
+// to 5s
+func backoff(try int) time.Duration {
+	if try > 5 {
+		return 5 * time.Second
+	}
+
+	return 100 * time.Millisecond << uint(try)
 }
@@ -0,0 +1,133 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
+)
+
+// KV holds the values stored under a prefix of the key/value store
+type KV struct {
+	c      *Client
+	prefix string
+
+	mu     sync.RWMutex
+	values map[string]string
+	index  uint64
+}
+
+// KV reads the values under prefix, which can then be watched
+func (c *Client) KV(prefix string) (*KV, error) {
+	kv := &KV{
+		c:      c,
+		prefix: strings.TrimSuffix(prefix, "/") + "/",
+	}
+
+	values, meta, err := kv.list(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	kv.values, kv.index = values, meta.LastIndex
+	return kv, nil
+}
+
+// Values returns a copy of the values, by key relative to the prefix
+func (kv *KV) Values() map[string]string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	values := make(map[string]string, len(kv.values))
+	for k, v := range kv.values {
+		values[k] = v
+	}
+
+	return values
+}
+
+// Watch calls changed whenever the values change, until the client is
+// closed
+func (kv *KV) Watch(changed func()) {
+	go func() {
+		for try := 0; ; {
+			kv.mu.RLock()
+			index := kv.index
+			kv.mu.RUnlock()
+
+			q := (&api.QueryOptions{WaitIndex: index, WaitTime: waitTime}).WithContext(kv.c.ctx)
+			values, meta, err := kv.list(q)
+
+			if kv.c.ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				logrus.Warnf("Consul: unable to watch %s: %v", kv.prefix, err)
+				if !sleep(kv.c.ctx, backoff(try)) {
+					return
+				}
+				try++
+				continue
+			}
+			try = 0
+
+			if meta.LastIndex == index {
+				continue
+			}
+
+			kv.mu.Lock()
+			kv.values = values
+			// the index can go backwards, such as when the leader changes
+			kv.index = meta.LastIndex
+			if meta.LastIndex < index {
+				kv.index = 0
+			}
+			kv.mu.Unlock()
+
+			logrus.Infof("Consul: settings under %s changed", kv.prefix)
+			changed()
+		}
+	}()
+}
+
+func (kv *KV) list(q *api.QueryOptions) (map[string]string, *api.QueryMeta, error) {
+	start := time.Now()
+	pairs, meta, err := kv.c.consul.KV().List(kv.prefix, q)
+	metrics.Consul.Observe("kv_list", start, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]string{}
+	for _, p := range pairs {
+		key := strings.TrimPrefix(p.Key, kv.prefix)
+
+		// folders have no value of their own
+		if len(key) == 0 || strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		values[key] = string(p.Value)
+	}
+
+	return values, meta, nil
+}
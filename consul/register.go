@@ -0,0 +1,74 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
+)
+
+// deregisterAfter is how long an instance can fail its check before the
+// agent removes it, so ones that crashed don't linger
+const deregisterAfter = time.Minute
+
+// Registration describes an instance of this service to register
+type Registration struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+
+	// Check is a URL the agent gets every CheckInterval, the instance
+	// being healthy while it answers with a 2xx status
+	Check         string
+	CheckInterval time.Duration
+}
+
+// Register adds an instance to the agent's catalog, with its health check
+func (c *Client) Register(r Registration) error {
+	start := time.Now()
+	err := c.consul.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:      r.ID,
+		Name:    r.Name,
+		Address: r.Address,
+		Port:    r.Port,
+		Check: &api.AgentServiceCheck{
+			HTTP:                           r.Check,
+			Interval:                       r.CheckInterval.String(),
+			Timeout:                        r.CheckInterval.String(),
+			DeregisterCriticalServiceAfter: deregisterAfter.String(),
+		},
+	})
+	metrics.Consul.Observe("register", start, err)
+
+	if err != nil {
+		return fmt.Errorf("Consul: unable to register %s: %v", r.ID, err)
+	}
+
+	return nil
+}
+
+// Deregister removes the instance with id from the agent's catalog
+func (c *Client) Deregister(id string) error {
+	start := time.Now()
+	err := c.consul.Agent().ServiceDeregister(id)
+	metrics.Consul.Observe("deregister", start, err)
+
+	return err
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/consul/api"
+)
+
+// waitTime is how long a blocking query waits for a change
+const waitTime = 5 * time.Minute
+
+// Service keeps track of the healthy instances of a service as they change
+type Service struct {
+	name string
+
+	// ctx is cancelled once nothing uses the service, stopping its watch
+	ctx    context.Context
+	cancel context.CancelFunc
+	users  int
+
+	mu        sync.RWMutex
+	instances []string
+	next      uint32
+}
+
+// Watch resolves a service to its healthy instances and keeps them up to
+// date until Unwatch is called as many times as Watch, or the client is
+// closed. Services are only watched once however many times they are asked
+// for.
+func (c *Client) Watch(name string) (*Service, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.services[name]; ok {
+		s.users++
+		return s, nil
+	}
+
+	instances, err := c.Instances(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	s := &Service{name: name, ctx: ctx, cancel: cancel, users: 1, instances: instances}
+	c.services[name] = s
+
+	go c.watch(s)
+
+	return s, nil
+}
+
+// Unwatch stops watching a service once nothing else that called Watch
+// uses it
+func (c *Client) Unwatch(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.services[name]
+	if !ok {
+		return
+	}
+
+	if s.users--; s.users == 0 {
+		s.cancel()
+		delete(c.services, name)
+	}
+}
+
+// Balance picks an instance of the service for each call, in turn, until
+// stop is called
+func (c *Client) Balance(name string) (next func() string, stop func(), err error) {
+	s, err := c.Watch(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return s.Next, func() { once.Do(func() { c.Unwatch(name) }) }, nil
+}
+
+// Instances returns the host:port of every healthy instance
+func (s *Service) Instances() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.instances...)
+}
+
+// Next returns the instance after the one it returned last
+func (s *Service) Next() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := atomic.AddUint32(&s.next, 1)
+	return s.instances[int(n)%len(s.instances)]
+}
+
+// watch makes blocking queries for changes to the service's instances,
+// backing off when Consul can't be reached. The last instances found are
+// kept while there are none, as calls to them may still succeed.
+func (c *Client) watch(s *Service) {
+	var index uint64
+	for try := 0; ; {
+		q := (&api.QueryOptions{WaitIndex: index, WaitTime: waitTime}).WithContext(s.ctx)
+		instances, meta, err := c.healthy(s.name, q)
+
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logrus.Warnf("Consul: unable to watch %s: %v", s.name, err)
+			if !sleep(s.ctx, backoff(try)) {
+				return
+			}
+			try++
+			continue
+		}
+		try = 0
+
+		// the index can go backwards, such as when the leader changes
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+
+		if meta.LastIndex == index {
+			continue
+		}
+		index = meta.LastIndex
+
+		if len(instances) == 0 {
+			logrus.Warnf("Consul: no healthy instances of %s, keeping the last ones", s.name)
+			continue
+		}
+
+		s.mu.Lock()
+		s.instances = instances
+		s.mu.Unlock()
+
+		logrus.Infof("Consul: %s instances are %v", s.name, instances)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health reports whether the service and the things it depends on
+// are working, and whether it has finished starting up.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Status of a check, or of the service as a whole
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// DefaultTimeout is how long checks get before they count as down
+const DefaultTimeout = 2 * time.Second
+
+// errPending is the error of startup steps that haven't finished
+var errPending = errors.New("pending")
+
+// CheckFunc reports whether a dependency can be used
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of a check or startup step
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Optional bool    `json:"optional"`
+	Latency  float64 `json:"latency_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check. The service is degraded when only
+// optional checks fail, and not ready when anything else does.
+type Report struct {
+	Status string   `json:"status"`
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name     string
+	optional bool
+	fn       CheckFunc
+}
+
+type step struct {
+	name    string
+	started time.Time
+	took    time.Duration
+	err     error
+}
+
+// Checker runs the checks of the service's dependencies and keeps track of
+// the startup steps readiness waits for
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []check
+	steps  []*step
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Checker{timeout: timeout}
+}
+
+// Add checks a dependency on every report. The service can do without the
+// optional ones, though not as well.
+func (h *Checker) Add(name string, optional bool, fn CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, check{name: name, optional: optional, fn: fn})
+}
+
+// Require holds readiness back until the named startup step is done
+func (h *Checker) Require(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.steps = append(h.steps, &step{name: name, started: time.Now(), err: errPending})
+}
+
+// Done finishes a startup step, which keeps the service unready if it
+// failed
+func (h *Checker) Done(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.steps {
+		if s.name == name {
+			s.took = time.Since(s.started)
+			s.err = err
+		}
+	}
+}
+
+// Check runs every check at once, each for no longer than the timeout
+func (h *Checker) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	checks := append([]check(nil), h.checks...)
+	results := make([]Result, len(checks), len(checks)+len(h.steps))
+	for _, s := range h.steps {
+		results = append(results, result(s.name, false, s.took, s.err))
+	}
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Ready: true, Checks: results}
+	for _, r := range results {
+		switch {
+		case r.Status == StatusUp:
+		case r.Optional:
+			if report.Status == StatusUp {
+				report.Status = StatusDegraded
+			}
+		default:
+			report.Status = StatusDown
+			report.Ready = false
+		}
+	}
+
+	return report
+}
+
+// run calls the check, giving up on it when ctx is done even if it
+// doesn't
+func run(ctx context.Context, c check) Result {
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return result(c.name, c.optional, time.Since(start), err)
+}
+
+func result(name string, optional bool, took time.Duration, err error) Result {
+	r := Result{
+		Name:     name,
+		Status:   StatusUp,
+		Optional: optional,
+		Latency:  float64(took.Nanoseconds()) / 1e6,
+	}
+
+	if err != nil {
+		r.Status = StatusDown
+		r.Error = err.Error()
+	}
+
+	return r
+}
@@ -0,0 +1,342 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lifecycle starts the service one step at a time, runs its
+// background workers and stops everything again in reverse when it is told
+// to.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// a worker that panics is restarted after a backoff, doubling up to
+// maxBackoff while it keeps panicking
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// StartFunc starts a step, ctx is cancelled if the service is told to stop
+// before it has finished starting
+type StartFunc func(ctx context.Context) error
+
+// StopFunc undoes a step, giving up when ctx is done
+type StopFunc func(ctx context.Context) error
+
+// WorkerFunc runs in the background until ctx is cancelled
+type WorkerFunc func(ctx context.Context)
+
+type step struct {
+	name  string
+	start StartFunc
+	stop  StopFunc
+}
+
+type worker struct {
+	name string
+	run  WorkerFunc
+}
+
+// Manager starts steps in the order they are added and stops the ones that
+// started in reverse, all within the shutdown timeout
+type Manager struct {
+	timeout time.Duration
+	steps   []step
+	failed  chan error
+
+	mu      sync.Mutex
+	workers []worker
+	running map[string]int
+	dead    map[string]int
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		failed:  make(chan error, 1),
+		running: map[string]int{},
+		dead:    map[string]int{},
+	}
+}
+
+// Add adds a step, stop may be nil when there is nothing to undo
+func (m *Manager) Add(name string, start StartFunc, stop StopFunc) {
+	m.steps = append(m.steps, step{name: name, start: start, stop: stop})
+}
+
+// Go registers a background worker, run by the step Workers adds. It can be
+// called from the steps before that one.
+func (m *Manager) Go(name string, run WorkerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.workers = append(m.workers, worker{name: name, run: run})
+}
+
+// Workers adds the step starting every worker, which share a context.
+// Stopping it cancels them and waits for them to return.
+func (m *Manager) Workers() {
+	m.Add("workers", m.startWorkers, m.stopWorkers)
+}
+
+// Fail stops the service because of something that went wrong once it had
+// started, such as the HTTP server
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// Run starts every step then waits for one of signals, or a failure, before
+// stopping them. It returns why the service couldn't start or had to stop.
+func (m *Manager) Run(signals ...os.Signal) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, signals...)
+	defer signal.Stop(quit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// failure is only read once ctx is done
+	var failure error
+	go func() {
+		select {
+		case sig := <-quit:
+			logrus.Infof("Lifecycle: %s received, stopping", sig)
+		case failure = <-m.failed:
+			logrus.Errorf("Lifecycle: %v, stopping", failure)
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
+
+	var err error
+	started := 0
+	for _, s := range m.steps {
+		// told to stop while starting, the remaining steps are skipped
+		if ctx.Err() != nil {
+			break
+		}
+
+		logrus.Debugf("Lifecycle: starting %s", s.name)
+		if err = s.start(ctx); err != nil {
+			err = fmt.Errorf("%s: %v", s.name, err)
+			break
+		}
+		started++
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		// a step that gave up because we were told to stop hasn't failed
+		if err != nil {
+			logrus.Debugf("Lifecycle: %v", err)
+		}
+		err = failure
+	case err == nil:
+		logrus.Info("Lifecycle: started")
+		<-ctx.Done()
+		err = failure
+	}
+
+	m.stop(started)
+
+	return err
+}
+
+// stop undoes the first n steps in reverse
+func (m *Manager) stop(n int) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	for i := n - 1; i >= 0; i-- {
+		s := m.steps[i]
+		if s.stop == nil {
+			continue
+		}
+
+		logrus.Debugf("Lifecycle: stopping %s", s.name)
+		if err := s.stop(ctx); err != nil {
+			logrus.Errorf("Lifecycle: stopping %s: %v", s.name, err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		logrus.Warnf("Lifecycle: gave up waiting after %s", m.timeout)
+	}
+}
+
+func (m *Manager) startWorkers(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// workers outlive startup, so they aren't cancelled with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	for _, w := range m.workers {
+		m.running[w.name]++
+		m.wg.Add(1)
+		go m.run(ctx, w)
+	}
+
+	return nil
+}
+
+// run runs a worker until it returns, restarting it when it panics
+func (m *Manager) run(ctx context.Context, w worker) {
+	defer func() {
+		m.mu.Lock()
+		m.running[w.name]--
+		if m.running[w.name] == 0 {
+			delete(m.running, w.name)
+		}
+		m.mu.Unlock()
+
+		m.wg.Done()
+	}()
+
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		if !m.runOnce(ctx, w) || ctx.Err() != nil {
+			return
+		}
+
+		// one that ran for a while before panicking starts over
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		logrus.Warnf("Lifecycle: restarting worker %s in %s", w.name, backoff)
+		m.setDead(w.name, 1)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		m.setDead(w.name, -1)
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runOnce runs a worker, returning whether it panicked
+func (m *Manager) runOnce(ctx context.Context, w worker) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Lifecycle: worker %s panicked: %v", w.name, r)
+			panicked = true
+		}
+	}()
+
+	logrus.Debugf("Lifecycle: worker %s started", w.name)
+	w.run(ctx)
+
+	return false
+}
+
+func (m *Manager) setDead(name string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dead[name] += n
+	if m.dead[name] == 0 {
+		delete(m.dead, name)
+	}
+}
+
+// Check reports the workers waiting to be restarted after a panic, as a
+// health check
+func (m *Manager) Check(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.dead) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for name := range m.dead {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("%v panicked", names)
+}
+
+func (m *Manager) stopWorkers(ctx context.Context) error {
+	m.mu.Lock()
+	m.cancel()
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := []string{}
+	for name := range m.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("%v still running", names)
+}
+
+// Every makes a worker calling fn every interval, or one that does nothing
+// when interval isn't positive
+func Every(interval time.Duration, fn WorkerFunc) WorkerFunc {
+	return func(ctx context.Context) {
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	}
+}
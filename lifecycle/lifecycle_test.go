@@ -0,0 +1,60 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkerPanic(t *testing.T) {
+	m := New(time.Second)
+
+	started := make(chan int)
+	runs := 0
+	m.Go("flaky", func(ctx context.Context) {
+		runs++
+		started <- runs
+		if runs == 1 {
+			panic("first run")
+		}
+		<-ctx.Done()
+	})
+	m.startWorkers(context.Background())
+
+	<-started
+	// the panic is recovered before the worker is marked dead
+	deadline := time.Now().Add(minBackoff / 2)
+	for m.Check(context.Background()) == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := m.Check(context.Background()); err == nil {
+		t.Error("a worker waiting to be restarted isn't reported")
+	}
+
+	select {
+	case <-started:
+	case <-time.After(2 * minBackoff):
+		t.Fatal("the worker wasn't restarted")
+	}
+	if err := m.Check(context.Background()); err != nil {
+		t.Errorf("got %v once the worker was restarted", err)
+	}
+
+	if err := m.stopWorkers(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -19,204 +19,554 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"gopkg.in/mgo.v2/bson"
-
-	mgo "gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	em "github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
 
+	"github.com/2-IMMERSE/auth-service/api"
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/config"
 	"github.com/2-IMMERSE/auth-service/consul"
+	"github.com/2-IMMERSE/auth-service/health"
+	"github.com/2-IMMERSE/auth-service/lifecycle"
+	"github.com/2-IMMERSE/auth-service/metrics"
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/server"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/encrypted"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+	"github.com/2-IMMERSE/auth-service/storage/mongo"
+	"github.com/2-IMMERSE/auth-service/storage/sql"
 	"github.com/2-IMMERSE/auth-service/tools"
+	"github.com/2-IMMERSE/auth-service/webhook"
 )
 
-const (
-	version = "1.0.0"
-	dbName  = "auth"
-)
+const version = "1.0.0"
 
 var (
-	debugFlag        = flag.Bool("debug", false, "enable debug")
 	versionFlag      = flag.Bool("version", false, "show version and exit")
-	maxProcs         = flag.Int("procs", 0, "max number of CPUs that can be used simultaneously. Less than 1 for default (number of cores).")
-	expireTokensFlag = flag.Bool("expire-tokens", false, "expire access tokens")
+	expireTokensFlag = flag.Bool("expire-tokens", false, "deprecated, tokens are always removed once they expire")
+
+	// cfg is the effective configuration, loaded before any command runs
+	cfg *config.Config
 
-	listenAddr = flag.String("listen", ":8080", "[hostname:port] to listen on")
+	// consulClient is connected to the first time it is needed
+	consulOnce   sync.Once
+	consulClient *consul.Client
+	consulErr    error
 )
 
-func init() {
-	flag.Parse()
+// usesConsul reports whether the storage is found through Consul, rather
+// than at a configured address
+func usesConsul(c config.Storage) bool {
+	return c.Backend == "mongo" && !strings.Contains(c.Mongo.Service, ":")
+}
 
-	if *debugFlag {
-		logrus.SetLevel(logrus.DebugLevel)
+func getMongoAddress() (string, error) {
+	mongoService := cfg.Storage.Mongo.Service
+
+	if !usesConsul(cfg.Storage) {
+		return mongoService, nil
 	}
 
-	if *versionFlag {
-		println("Auth Service V%s", version)
-		os.Exit(1)
+	client, err := getConsul()
+	if err != nil {
+		return "", fmt.Errorf("Consul: %s", err)
 	}
 
-	setMaxProcs()
+	// the driver follows the replica set from any of these, so they
+	// aren't watched
+	instances, err := client.Instances(mongoService)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(instances, ","), nil
 }
 
-func getMongoAddress() string {
-	mongoService := os.Getenv("MONGODB_SERVICE")
-	if len(mongoService) == 0 {
-		mongoService = "localhost:27017"
+// getConsul connects to the local Consul agent the first time it is called
+func getConsul() (*consul.Client, error) {
+	consulOnce.Do(func() {
+		consulClient, consulErr = consul.NewClient()
+	})
+
+	return consulClient, consulErr
+}
+
+// gatewayServices is where the driver finds the gateway when it is
+// configured by service name, nil otherwise
+func gatewayServices(c config.Gateway) auth.Services {
+	if c.Driver != auth.DriverTyk || len(c.Tyk.Service) == 0 {
+		return nil
 	}
 
-	if strings.Contains(mongoService, ":") {
-		return mongoService
+	client, err := getConsul()
+	if err != nil {
+		logrus.Errorf("Consul: %s", err)
+		return nil
 	}
 
-	client, err := consul.NewClient()
+	return client
+}
+
+// registration describes this instance to Consul, which checks it is ready
+// to handle requests
+func registration(c *config.Config) (consul.Registration, error) {
+	_, listen, err := net.SplitHostPort(c.Listen)
 	if err != nil {
-		logrus.Fatalf("Consul: %s", err)
+		return consul.Registration{}, fmt.Errorf("listen: %v", err)
 	}
 
-	port, err := client.LookupServicePort(mongoService)
+	port, err := strconv.Atoi(listen)
 	if err != nil {
-		logrus.Fatal(err)
+		return consul.Registration{}, fmt.Errorf("listen: port %s isn't a number", listen)
 	}
 
-	return fmt.Sprintf("%s.service.consul:%d", mongoService, port)
+	host, err := os.Hostname()
+	if err != nil {
+		return consul.Registration{}, err
+	}
+
+	address := c.Consul.Address
+	if len(address) == 0 {
+		address = host
+	}
+
+	return consul.Registration{
+		ID:            fmt.Sprintf("%s-%s-%d", c.Consul.Service, host, port),
+		Name:          c.Consul.Service,
+		Address:       address,
+		Port:          port,
+		Check:         fmt.Sprintf("http://%s/healthcheck/ready", net.JoinHostPort(address, listen)),
+		CheckInterval: c.Consul.CheckInterval,
+	}, nil
 }
 
 func main() {
-	allowOrigins := os.Getenv("CORS_ALLOW_ORIGINS")
-	if len(allowOrigins) == 0 {
-		allowOrigins = "*"
-	}
+	flag.Usage = usage
 
-	logrus.Debugf("Allowing origin: %s", allowOrigins)
+	var err error
+	if cfg, err = config.Load(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	logrus.Debugf("Dialing mongo...")
-	s, err := mgo.DialWithTimeout(getMongoAddress(), 1*time.Second)
-	if err != nil {
-		logrus.Fatal(err)
+	logrus.SetFormatter(logFormatter(cfg.LogFormat))
+	if cfg.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
 	}
-	db := &mgo.Database{
-		Session: s,
-		Name:    dbName,
+
+	if *versionFlag {
+		println("Auth Service V%s", version)
+		os.Exit(1)
 	}
-	defer db.Session.Close()
 
-	db.Session.SetMode(mgo.Monotonic, true)
+	setMaxProcs()
 
-	e := echo.New()
-	e.HideBanner = true
-	if *debugFlag {
-		e.Logger.SetLevel(log.DEBUG)
+	os.Exit(run(flag.Args()))
+}
+
+// serve runs the service until it receives SIGTERM or SIGINT. It starts in
+// order, each step relying on the ones before, is only registered once it
+// is listening and stops in reverse.
+func serve(args []string) error {
+	if *expireTokensFlag {
+		logrus.Warn("-expire-tokens is deprecated, tokens are always removed once they expire")
 	}
 
-	e.Pre(em.RemoveTrailingSlash())
-	e.Use(em.Recover())
-	e.Use(em.LoggerWithConfig(em.LoggerConfig{
-		Skipper: func(c echo.Context) bool {
-			if c.Path() == "/healthcheck" {
-				return true
-			}
+	l := lifecycle.New(cfg.ShutdownTimeout)
+
+	var (
+		kv       *consul.KV
+		store    storage.Store
+		holder   *config.Holder
+		cors     *middleware.Reloadable
+		driver   *auth.Switch
+		v        *tools.Validator
+		reloader *reloader
+		e        *echo.Echo
+	)
+
+	// not ready until the indexes and fixtures are in place
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Require("migrations")
+	checker.Require("fixtures")
+
+	// nor while a worker that panicked waits to be restarted
+	checker.Add("workers", false, l.Check)
+
+	// settings in consul override the rest, and are reloaded as they change
+	l.Add("config", func(ctx context.Context) error {
+		if len(cfg.Consul.KV) == 0 {
+			return nil
+		}
+
+		client, err := getConsul()
+		if err != nil {
+			return err
+		}
+
+		if kv, err = client.KV(cfg.Consul.KV); err != nil {
+			return err
+		}
+
+		if err := config.ApplyKV(cfg, kv.Values()); err != nil {
+			return err
+		}
+
+		return cfg.Validate()
+	}, nil)
+
+	l.Add("storage", func(ctx context.Context) (err error) {
+		store, err = openStorage(cfg.Storage)
+		return err
+	}, func(ctx context.Context) error {
+		return store.Close()
+	})
+
+	l.Add("migrations", func(ctx context.Context) error {
+		if err := migrate(store, false); err != nil {
+			return fmt.Errorf("%s, run migrate -dry-run to see what is pending", err)
+		}
+		checker.Done("migrations", nil)
+
+		return nil
+	}, nil)
+
+	// migrations work on what is stored, everything after on what it holds
+	l.Add("encryption", func(ctx context.Context) error {
+		sealed, err := encrypt(store, cfg.Encryption)
+		if err != nil {
+			return err
+		}
+		store = sealed
+
+		return nil
+	}, nil)
+
+	l.Add("fixtures", func(ctx context.Context) error {
+		logrus.Info("Loading fixtures...")
+		err := loadFixtures(store, cfg.Fixtures)
+		checker.Done("fixtures", err)
+
+		return err
+	}, nil)
+
+	l.Add("integrations", func(ctx context.Context) error {
+		holder = config.NewHolder(cfg)
+		cors = middleware.NewReloadable(corsMiddleware(cfg.CORS))
+
+		gateway, err := auth.NewDriver(cfg.Gateway, gatewayServices(cfg.Gateway))
+		if err != nil {
+			return fmt.Errorf("gateway: %v", err)
+		}
+		logrus.Infof("Gateway: using %T driver", gateway)
+
+		// the driver is replaced when its settings are reloaded
+		driver = auth.NewSwitch(gateway)
+
+		if _, ok := gateway.(auth.Reconciler); ok && cfg.Gateway.ReconcileInterval > 0 {
+			l.Go("reconcile", func(ctx context.Context) {
+				auth.RunReconciler(ctx, driver, store, cfg.Gateway.ReconcileInterval)
+			})
+		}
+
+		if v, err = tools.NewValidator(cfg.Schema); err != nil {
+			return fmt.Errorf("validation: %v", err)
+		}
+		reloader = newReloader(holder, cors, v, driver, store, kv, os.Args[1:])
+
+		if kv != nil {
+			kv.Watch(func() {
+				reloader.Reload()
+			})
+		}
 
-			return false
-		},
+		addChecks(checker, store, driver, v)
+		if consulClient != nil || cfg.Consul.Register {
+			checker.Add("consul", true, func(ctx context.Context) error {
+				client, err := getConsul()
+				if err != nil {
+					return err
+				}
+
+				return client.Ping()
+			})
+		}
+
+		return nil
+	}, func(ctx context.Context) error {
+		// stops watching services and settings
+		if consulClient != nil {
+			consulClient.Close()
+		}
+
+		return nil
+	})
+
+	l.Go("webhooks", func(ctx context.Context) {
+		webhook.NewDispatcher(store).Run(ctx)
+	})
+
+	l.Go("token cleanup", lifecycle.Every(cfg.Tokens.CleanupInterval, func(ctx context.Context) {
+		auth.RemoveExpiredTokens(ctx, store)
 	}))
-	e.Use(em.CORSWithConfig(em.CORSConfig{
-		AllowOrigins:     []string{allowOrigins, "*"},
-		ExposeHeaders:    []string{"Content-Range"},
-		AllowCredentials: true,
-		AllowMethods:     []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE, echo.OPTIONS},
-		MaxAge:           3600,
+
+	l.Go("code expiry", lifecycle.Every(cfg.Tokens.CleanupInterval, func(ctx context.Context) {
+		auth.RemoveExpiredCodes(ctx, store)
 	}))
-	e.Use(middleware.MGO(db))
-	e.Use(middleware.Token(db))
-	e.Use(middleware.Error())
 
-	if *debugFlag {
-		e.Use(em.BodyDump(func(c echo.Context, reqBody, resBody []byte) {
-			if c.Path() == "/healthcheck" {
+	l.Go("reload", func(ctx context.Context) {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-hup:
+				logrus.Info("Reload: SIGHUP received")
+				reloader.Reload()
 			}
+		}
+	})
+
+	l.Workers()
+
+	l.Add("http", func(ctx context.Context) error {
+		// the service runs without a spec, it's only used to describe the API
+		spec, err := api.Load(cfg.API)
+		if err != nil {
+			logrus.Errorf("API spec: %s, /openapi.json will only list the routes", err)
+		}
 
-			if len(reqBody) > 0 {
-				logrus.Debugf("Request: %s Body: %s", c.Path(), reqBody)
+		e = newServer(store, driver, v, spec, holder, cors, reloader, checker, os.Stdout)
+		metrics.Registry.MustRegister(metrics.NewStoreCollector(store))
+
+		// listening here means a port that's taken stops startup
+		if e.Listener, err = net.Listen("tcp", cfg.Listen); err != nil {
+			return err
+		}
+
+		go func() {
+			if err := e.Start(cfg.Listen); err != nil && err != http.ErrServerClosed {
+				l.Fail(fmt.Errorf("http: %v", err))
+			}
+		}()
+		logrus.Infof("listening on %s!", cfg.Listen)
+
+		return nil
+	}, func(ctx context.Context) error {
+		return e.Shutdown(ctx)
+	})
+
+	// no more requests are sent once we're out of the catalog, so it's the
+	// first thing undone
+	if cfg.Consul.Register {
+		var id string
+		l.Add("consul", func(ctx context.Context) error {
+			client, err := getConsul()
+			if err != nil {
+				return err
 			}
 
-			if len(resBody) > 0 {
-				logrus.Debugf("Response: %s Body: %s", c.Path(), resBody)
+			r, err := registration(cfg)
+			if err != nil {
+				return err
 			}
-		}))
+
+			if err := client.Register(r); err != nil {
+				return err
+			}
+			id = r.ID
+			logrus.Infof("Consul: registered as %s", id)
+
+			return nil
+		}, func(ctx context.Context) error {
+			return consulClient.Deregister(id)
+		})
 	}
 
-	logrus.Debug("Mounting services...")
-	server.MountHealthcheckServer("/healthcheck", e, *debugFlag)
+	return l.Run(syscall.SIGTERM, os.Interrupt)
+}
+
+// newServer sets up the middleware and mounts every service, writing the
+// access log to accessLog
+func newServer(store storage.Store, driver *auth.Switch, v *tools.Validator, spec *api.Spec, holder *config.Holder, cors *middleware.Reloadable, reloader server.Reloader, checker *health.Checker, accessLog io.Writer) *echo.Echo {
+	c := holder.Get()
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HTTPErrorHandler = middleware.ErrorHandler("/auth/")
+	if c.Debug {
+		e.Logger.SetLevel(log.DEBUG)
+	}
+
+	access := logrus.New()
+	access.Out = accessLog
+	access.Formatter = logFormatter(c.LogFormat)
+
+	// probes are made too often to be worth logging
+	skipHealthcheck := func(c echo.Context) bool {
+		switch c.Path() {
+		case "/healthcheck", "/healthcheck/live", "/healthcheck/ready":
+			return true
+		}
+
+		return false
+	}
+
+	e.Pre(em.RemoveTrailingSlash())
+	e.Use(middleware.RequestID())
+	e.Use(em.Recover())
+	e.Use(middleware.AccessLog(access, skipHealthcheck))
+	e.Use(middleware.Metrics())
 
-	v := tools.NewValidator("./schema")
+	e.Use(cors.Middleware())
+	e.Use(middleware.Config(holder))
+	e.Use(middleware.Storage(store))
+	e.Use(middleware.Token(store))
+	e.Use(middleware.Error())
+	e.Use(middleware.Validator(v))
 
-	server.MountAuthServer("/auth", e, v)
-	server.MountUserServer("/users", e, v)
-	server.MountMeServer("/me", e, v)
+	if c.Debug {
+		e.Use(middleware.BodyDump(skipHealthcheck))
+	}
+
+	logrus.Debug("Mounting services...")
+	server.MountHealthcheckServer("/healthcheck", e, c.Debug, reloader, checker)
+	server.MountAdminServer("/admin", e, reloader)
+	server.MountOpenAPIServer("/openapi.json", e, spec, version)
+	server.MountMetricsServer("/metrics", e)
+
+	server.MountAuthServer("/auth", e, v, driver)
+	server.MountUserServer("/users", e, v, driver)
+	server.MountMeServer("/me", e, v, driver)
 	server.MountKeyServer("/keys", e, v)
 	server.MountDeviceServer("/devices", e, v)
+	server.MountWebhookServer("/webhooks", e, v)
+	server.MountClientServer("/clients", e, v, driver)
+	server.MountPolicyServer("/policies", e, v)
 
-	if *expireTokensFlag {
-		index := mgo.Index{
-			Key:         []string{"token"},
-			Unique:      true,
-			DropDups:    true,
-			Background:  true,
-			Sparse:      true,
-			ExpireAfter: time.Hour * time.Duration(24),
+	driver.RegisterRoutes(e.Group("/gateway/" + c.Gateway.Driver))
+
+	return e
+}
+
+// openStorage connects to the configured storage backend
+func openStorage(c config.Storage) (storage.Store, error) {
+	switch c.Backend {
+	case "mongo":
+		address, err := getMongoAddress()
+		if err != nil {
+			return nil, err
 		}
-		db.C("token").EnsureIndex(index)
+
+		logrus.Debugf("Dialing mongo...")
+		s, err := mongo.Dial(mongo.Options{
+			URI:            address,
+			Database:       c.Mongo.Database,
+			Timeout:        c.Mongo.Timeout,
+			ConnectTimeout: 1 * time.Second,
+			MaxPoolSize:    c.Mongo.MaxPool,
+			MinPoolSize:    c.Mongo.MinPool,
+			ReadConcern:    c.Mongo.ReadConcern,
+			WriteConcern:   c.Mongo.WriteConcern,
+			ReadPreference: c.Mongo.ReadPreference,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	case "postgres", "sqlite":
+		dsn := c.SQL.DSN
+		if len(dsn) == 0 && c.Backend == "sqlite" {
+			dsn = "auth.db"
+		}
+
+		logrus.Debugf("Opening %s...", c.Backend)
+		s, err := sql.Open(sql.Options{
+			Dialect:      c.Backend,
+			DSN:          dsn,
+			Timeout:      c.SQL.Timeout,
+			MaxOpenConns: c.SQL.MaxConns,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	case "memory":
+		logrus.Warn("Storage: using memory, nothing will be kept after a restart")
+		return memory.New(), nil
 	}
 
-	unique := mgo.Index{
-		Key:      []string{"$text:id"},
-		Unique:   true,
-		DropDups: true,
+	return nil, fmt.Errorf("unknown storage backend %s", c.Backend)
+}
+
+// encrypt wraps store so key data and client secrets are sealed, when a
+// master key is configured
+func encrypt(store storage.Store, c config.Encryption) (storage.Store, error) {
+	if len(c.Key) == 0 {
+		return store, nil
 	}
-	db.C("devices").EnsureIndex(unique)
 
-	logrus.Info("Loading fixtures...")
-	loadUserFixtures(db)
-	loadKeyFixtures(db)
+	keyring, err := encrypted.NewKeyring(c.Key, c.PreviousKeys)
+	if err != nil {
+		return nil, err
+	}
 
-	// start server listening
-	go func() {
-		logrus.Infof("listening on %s!", *listenAddr)
-		if err := e.Start(*listenAddr); err != nil {
-			logrus.Error(err)
-			logrus.Info("Shutting down server")
-		}
-	}()
+	return encrypted.New(store, keyring), nil
+}
 
-	// handle graceful shutdowns
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+// addChecks adds the checks of the storage, the gateway and the schemas
+func addChecks(h *health.Checker, store storage.Store, driver *auth.Switch, v *tools.Validator) {
+	h.Add("storage", false, store.Ping)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		logrus.Fatal(err)
+	// without the gateway tokens are deferred until it is back
+	if _, ok := driver.Driver().(auth.Pinger); ok {
+		h.Add("gateway", true, driver.Ping)
 	}
+
+	// requests are validated against the schemas loaded before a failed
+	// reload
+	h.Add("schema", true, func(ctx context.Context) error {
+		return v.Err()
+	})
+}
+
+// loadFixtures upserts the users and keys in dir, there being no user
+// fixtures when users.json doesn't exist
+func loadFixtures(s storage.Store, dir string) error {
+	if err := loadUserFixtures(s, dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return loadKeyFixtures(s, dir)
 }
 
-func loadUserFixtures(db *mgo.Database) error {
+func loadUserFixtures(s storage.Store, dir string) error {
 	var users []*model.User
-	data, err := ioutil.ReadFile("./fixtures/users.json")
+	data, err := ioutil.ReadFile(path.Join(dir, "users.json"))
 	if err != nil {
 		return err
 	}
@@ -225,27 +575,45 @@ func loadUserFixtures(db *mgo.Database) error {
 		return err
 	}
 
-	collection := db.C("users")
 	for _, u := range users {
 		u.HashPassword()
-		collection.Upsert(bson.M{"email": u.Email}, u)
+		if err := s.Users().UpsertByEmail(context.Background(), *u); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func loadKeyFixtures(db *mgo.Database) error {
-	collection := db.C("keys")
-	files, _ := ioutil.ReadDir("./fixtures/keys")
+func loadKeyFixtures(s storage.Store, dir string) error {
+	dir = path.Join(dir, "keys")
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
 	for _, f := range files {
 		if !f.IsDir() {
-			data, _ := ioutil.ReadFile(path.Join("./fixtures/keys", f.Name()))
-			id := path.Base(f.Name())
-			collection.Insert(&model.Key{
-				ID:   bson.ObjectIdHex(id),
+			id, err := primitive.ObjectIDFromHex(path.Base(f.Name()))
+			if err != nil {
+				logrus.Warnf("Fixtures: skipping %s, keys are named by their id", f.Name())
+				continue
+			}
+
+			data, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+			if err != nil {
+				return err
+			}
+
+			// upserted so a changed file rotates the stored key
+			if err := s.Keys().Upsert(context.Background(), model.Key{
+				ID:   id,
 				Data: data,
-			})
+			}); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -0,0 +1,187 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics collects the service's Prometheus metrics: requests
+// served, logins, tokens and devices, the drift reconciled with the gateway,
+// the expired entries cleaned up and the calls made to Tyk and Consul.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth"
+
+// Results of a login
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+var (
+	// Registry holds every metric the service exposes, rather than the
+	// default registry which any imported package could add to
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Password logins, by whether they succeeded.",
+	}, []string{"result"})
+
+	TokensIssued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Access tokens issued.",
+	})
+
+	TokensRevoked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_revoked_total",
+		Help:      "Access tokens revoked before they expired.",
+	})
+
+	DeviceLinks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_links_total",
+		Help:      "Devices linked to a user with their code.",
+	})
+
+	ReconcileRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "runs_total",
+		Help:      "Reconciliations of the tokens with the gateway.",
+	})
+
+	ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "errors_total",
+		Help:      "Failed reconciliations and pending tokens that couldn't be issued, by operation.",
+	}, []string{"operation"})
+
+	ReconcileFixed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "fixed_total",
+		Help:      "Drift between the tokens and the gateway that was fixed, by kind.",
+	}, []string{"kind"})
+
+	ReconcileLastDrift = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "last_drift",
+		Help:      "Orphaned keys and tokens found by the last reconciliation.",
+	})
+
+	CleanupRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cleanup",
+		Name:      "removed_total",
+		Help:      "Expired entries removed, by kind.",
+	}, []string{"kind"})
+
+	CleanupErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cleanup",
+		Name:      "errors_total",
+		Help:      "Cleanups that failed, by kind.",
+	}, []string{"kind"})
+
+	// Tyk and Consul time the calls made to them
+	Tyk    = newClient("tyk")
+	Consul = newClient("consul")
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		Logins,
+		TokensIssued,
+		TokensRevoked,
+		DeviceLinks,
+		ReconcileRuns,
+		ReconcileErrors,
+		ReconcileFixed,
+		ReconcileLastDrift,
+		CleanupRemoved,
+		CleanupErrors,
+		Tyk.duration,
+		Tyk.errors,
+		Consul.duration,
+		Consul.errors,
+	)
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Client records the latency and failures of calls to a dependency, by
+// operation
+type Client struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func newClient(subsystem string) *Client {
+	return &Client{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken by calls, including retries, by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Calls that failed, by operation.",
+		}, []string{"operation"}),
+	}
+}
+
+// Observe records a call to operation that started at start and returned
+// err
+func (c *Client) Observe(operation string, start time.Time, err error) {
+	c.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.errors.WithLabelValues(operation).Inc()
+	}
+}
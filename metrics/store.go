@@ -0,0 +1,73 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// scrapeTimeout bounds the queries made for each scrape
+const scrapeTimeout = 5 * time.Second
+
+var (
+	activeTokens = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "active_tokens"),
+		"Access tokens that haven't expired.",
+		nil, nil,
+	)
+
+	unpairedDevices = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "unpaired_devices"),
+		"Devices waiting to be linked to a user.",
+		nil, nil,
+	)
+)
+
+// StoreCollector counts active tokens and unpaired devices when scraped, so
+// the gauges are right however many instances share the storage
+type StoreCollector struct {
+	store storage.Store
+}
+
+func NewStoreCollector(store storage.Store) *StoreCollector {
+	return &StoreCollector{store: store}
+}
+
+func (s *StoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeTokens
+	ch <- unpairedDevices
+}
+
+func (s *StoreCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	if count, err := s.store.Tokens().CountActive(ctx, time.Now()); err != nil {
+		ch <- prometheus.NewInvalidMetric(activeTokens, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(activeTokens, prometheus.GaugeValue, float64(count))
+	}
+
+	if count, err := s.store.Devices().CountUnlinked(ctx); err != nil {
+		ch <- prometheus.NewInvalidMetric(unpairedDevices, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(unpairedDevices, prometheus.GaugeValue, float64(count))
+	}
+}
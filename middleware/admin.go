@@ -15,23 +15,27 @@
 package middleware
 
 import (
+	"net/http"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+
 	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
 )
 
 func Admin() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if c.Get("user") == nil {
-				return echo.ErrUnauthorized
+				return response.NewError(http.StatusUnauthorized, response.CodeUnauthorized, "An access token is required")
 			}
 
 			user := c.Get("user").(model.User)
 
 			if !user.HasRole("ROLE_ADMIN") {
 				logrus.Debugf("Illegal access for none admin")
-				return echo.ErrForbidden
+				return response.NewError(http.StatusForbidden, response.CodeForbidden, "Only administrators can do this")
 			}
 
 			return next(c)
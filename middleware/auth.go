@@ -15,7 +15,11 @@
 package middleware
 
 import (
+	"net/http"
+
 	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/response"
 )
 
 // very simple middleware that checks if we have a user and if not aborts the request
@@ -23,7 +27,7 @@ func Auth() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if c.Get("user") == nil {
-				return echo.ErrUnauthorized
+				return response.NewError(http.StatusUnauthorized, response.CodeUnauthorized, "An access token is required")
 			}
 
 			return next(c)
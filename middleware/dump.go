@@ -0,0 +1,139 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo"
+	em "github.com/labstack/echo/middleware"
+
+	"github.com/2-IMMERSE/auth-service/response"
+)
+
+// redacted replaces the value of secret fields in dumped bodies
+const redacted = "[REDACTED]"
+
+// secretFields are never logged, matched case insensitively against JSON
+// object keys and form fields
+var secretFields = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"secret":        true,
+	"code":          true,
+	"provision_key": true,
+	"key":           true,
+}
+
+// BodyDump logs request and response bodies for debugging, with passwords,
+// tokens and other secrets redacted. Bodies that are neither JSON nor forms,
+// such as key data, are only logged by size.
+func BodyDump(skip func(c echo.Context) bool) echo.MiddlewareFunc {
+	return em.BodyDumpWithConfig(em.BodyDumpConfig{
+		Skipper: func(c echo.Context) bool {
+			return skip != nil && skip(c)
+		},
+		Handler: func(c echo.Context, reqBody, resBody []byte) {
+			if len(reqBody) > 0 {
+				Log(c).Debugf("Request body: %s", Redact(c.Request().Header.Get(echo.HeaderContentType), reqBody))
+			}
+
+			if len(resBody) > 0 {
+				Log(c).Debugf("Response body: %s", Redact(c.Response().Header().Get(echo.HeaderContentType), resBody))
+			}
+		},
+	})
+}
+
+// Redact returns body, of the given content type, with secrets replaced
+func Redact(contentType string, body []byte) string {
+	switch {
+	case strings.HasPrefix(contentType, response.MIMEProblemJSON):
+		// errors never hold secrets, and their code is worth seeing
+		return string(body)
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return fmt.Sprintf("[%d bytes of malformed JSON]", len(body))
+		}
+
+		data, _ := json.Marshal(redactValue(v))
+		return string(data)
+	case strings.HasPrefix(contentType, echo.MIMEApplicationForm):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Sprintf("[%d bytes of malformed form]", len(body))
+		}
+
+		for name := range form {
+			if secretFields[strings.ToLower(name)] {
+				form[name] = []string{redacted}
+			}
+		}
+
+		return form.Encode()
+	}
+
+	return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+}
+
+// RedactURI returns a request URI with the value of secret query parameters,
+// such as an access_token or authorization code, replaced
+func RedactURI(uri string) string {
+	i := strings.IndexByte(uri, '?')
+	if i < 0 {
+		return uri
+	}
+
+	params := strings.Split(uri[i+1:], "&")
+	for j, param := range params {
+		raw := strings.SplitN(param, "=", 2)[0]
+		name, err := url.QueryUnescape(raw)
+		if err != nil {
+			name = raw
+		}
+
+		if secretFields[strings.ToLower(name)] {
+			params[j] = raw + "=" + redacted
+		}
+	}
+
+	return uri[:i+1] + strings.Join(params, "&")
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if secretFields[strings.ToLower(k)] {
+				value[k] = redacted
+			} else {
+				value[k] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+
+	return v
+}
@@ -15,23 +15,122 @@
 package middleware
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 )
 
+// Error writes any error returned by the rest of the chain as a problem
 func Error() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) (err error) {
 			if err = next(c); err != nil {
-				switch e := err.(type) {
-				case response.Error:
-					c.JSON(e.Status(), e)
-				default:
-					c.Error(e)
-				}
+				c.Error(err)
+			}
+
+			return nil
+		}
+	}
+}
+
+// ErrorHandler sends every error as an RFC 7807 problem, including those
+// echo raises itself such as unknown routes and recovered panics. Requests
+// to paths under the oauth prefixes also get RFC 6749 error and
+// error_description members. Internal errors are logged with a correlation
+// id which is sent in place of their cause.
+func ErrorHandler(oauth ...string) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			Log(c).Errorf("%s %s: %v after the response was sent", c.Request().Method, c.Request().URL.Path, err)
+			return
+		}
+
+		e := problem(err)
+		e.Instance = c.Request().URL.Path
+
+		if e.StatusCode >= 500 {
+			// the request id finds the log entry, and any the gateway wrote
+			e.CorrelationID, _ = c.Get("request_id").(string)
+			if len(e.CorrelationID) == 0 {
+				e.CorrelationID = uuid.NewV4().String()
 			}
+			Log(c).WithField("correlation_id", e.CorrelationID).Errorf("%s %s: %v", c.Request().Method, e.Instance, err)
+		}
+
+		for _, prefix := range oauth {
+			if strings.HasPrefix(e.Instance, prefix) {
+				e.OAuth = oauthError(e)
+			}
+		}
+
+		if e.StatusCode == http.StatusUnauthorized {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+		}
 
+		body, merr := json.Marshal(e)
+		if merr != nil {
+			logrus.Errorf("Unable to encode error: %v", merr)
+			c.NoContent(e.StatusCode)
 			return
 		}
+
+		if c.Request().Method == http.MethodHead {
+			c.NoContent(e.StatusCode)
+			return
+		}
+
+		c.Blob(e.StatusCode, response.MIMEProblemJSON, body)
+	}
+}
+
+// problem turns any error into one we can send, a missing record being a 404
+// and anything unexpected an internal error
+func problem(err error) response.Error {
+	switch e := err.(type) {
+	case response.Error:
+		if len(e.Code) == 0 {
+			e.Code = response.CodeForStatus(e.StatusCode)
+		}
+
+		return e
+	case *echo.HTTPError:
+		if e.Code >= 500 {
+			return response.Internal(e)
+		}
+
+		return response.NewError(e.Code, response.CodeForStatus(e.Code), fmt.Sprint(e.Message))
 	}
+
+	switch err {
+	case storage.ErrNotFound:
+		return response.NotFound("Resource")
+	case storage.ErrDuplicate:
+		return response.NewError(http.StatusConflict, response.CodeConflict, "Resource already exists")
+	}
+
+	return response.Internal(err)
+}
+
+// oauthError is the RFC 6749 error code for e
+func oauthError(e response.Error) string {
+	switch {
+	case e.Code == response.CodeInvalidCredentials:
+		return response.OAuthInvalidGrant
+	case e.Code == response.CodeInvalidToken || e.StatusCode == http.StatusUnauthorized:
+		return response.OAuthInvalidToken
+	case e.StatusCode == http.StatusServiceUnavailable:
+		return response.OAuthUnavailable
+	case e.StatusCode >= 500:
+		return response.OAuthServerError
+	}
+
+	return response.OAuthInvalidRequest
 }
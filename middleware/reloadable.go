@@ -0,0 +1,48 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/labstack/echo"
+)
+
+// Reloadable is a middleware that can be replaced while requests are being
+// served. Requests already in it finish with the one they started with.
+type Reloadable struct {
+	current atomic.Value
+}
+
+// NewReloadable wraps m so it can be replaced later
+func NewReloadable(m echo.MiddlewareFunc) *Reloadable {
+	r := &Reloadable{}
+	r.Set(m)
+	return r
+}
+
+// Set replaces the middleware for requests arriving from now on
+func (r *Reloadable) Set(m echo.MiddlewareFunc) {
+	r.current.Store(m)
+}
+
+// Middleware runs whichever middleware is current as the request arrives
+func (r *Reloadable) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return r.current.Load().(echo.MiddlewareFunc)(next)(c)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/trace"
+)
+
+// RequestID gives every request an id, taken from X-Request-ID when the
+// caller sent a usable one, and joins the W3C trace it arrived with or
+// starts a new one. Both are sent back and carried by the request context,
+// so the gateway drivers pass them on.
+func RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			id := req.Header.Get(trace.HeaderRequestID)
+			if !validRequestID(id) {
+				id = uuid.NewV4().String()
+			}
+
+			parent, ok := trace.Parse(req.Header.Get(trace.HeaderTraceParent), req.Header.Get(trace.HeaderTraceState))
+			if !ok {
+				parent = trace.New()
+			}
+
+			ctx := trace.WithRequestID(req.Context(), id)
+			ctx = trace.NewContext(ctx, parent)
+			c.SetRequest(req.WithContext(ctx))
+
+			c.Set("request_id", id)
+			c.Response().Header().Set(trace.HeaderRequestID, id)
+
+			return next(c)
+		}
+	}
+}
+
+// validRequestID accepts ids of up to 128 printable ASCII characters, so
+// whatever the caller sent is safe to log and echo back
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Log is a logger for the request, with its id, route and user
+func Log(c echo.Context) *logrus.Entry {
+	return logrus.WithFields(requestFields(c))
+}
+
+func requestFields(c echo.Context) logrus.Fields {
+	fields := logrus.Fields{
+		"method": c.Request().Method,
+		"route":  c.Path(),
+	}
+
+	if id, ok := c.Get("request_id").(string); ok {
+		fields["request_id"] = id
+	}
+
+	if u, ok := c.Get("user").(model.User); ok {
+		fields["user"] = u.ID.Hex()
+	}
+
+	if p, ok := trace.FromContext(c.Request().Context()); ok {
+		fields["trace_id"] = p.TraceID
+	}
+
+	return fields
+}
+
+// AccessLog logs each request once it has been handled, skipping those
+// skip reports true for
+func AccessLog(logger *logrus.Logger, skip func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip != nil && skip(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			logger.WithFields(requestFields(c)).WithFields(logrus.Fields{
+				"uri":        RedactURI(c.Request().RequestURI),
+				"status":     c.Response().Status,
+				"latency_ms": float64(time.Since(start).Nanoseconds()) / 1e6,
+				"bytes_in":   c.Request().ContentLength,
+				"bytes_out":  c.Response().Size,
+				"remote_ip":  c.RealIP(),
+				"user_agent": c.Request().UserAgent(),
+			}).Info("request")
+
+			return nil
+		}
+	}
+}
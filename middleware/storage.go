@@ -16,15 +16,15 @@ package middleware
 
 import (
 	"github.com/labstack/echo"
-	mgo "gopkg.in/mgo.v2"
+
+	"github.com/2-IMMERSE/auth-service/storage"
 )
 
-func MGO(db *mgo.Database) echo.MiddlewareFunc {
+// Storage makes the store available to handlers as "storage"
+func Storage(s storage.Store) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			s := db.Session.Clone()
-			defer s.Close()
-			c.Set("mgo_db", s.DB(db.Name))
+			c.Set("storage", s)
 			return next(c)
 		}
 	}
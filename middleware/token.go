@@ -15,52 +15,66 @@
 package middleware
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/config"
 	"github.com/2-IMMERSE/auth-service/model"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
-	"strings"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 )
 
+var invalidToken = response.NewError(http.StatusForbidden, response.CodeInvalidToken, "The access token is invalid or has expired")
+
 // if there is an access token provided we need to extract it and lookup the user based on the token
 // should we lookup the user now or make it explicit?
-func Token(db *mgo.Database) echo.MiddlewareFunc {
+func Token(s storage.Store) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			
+
 			// Check submitted tokens for all routes except when creating new users
 			if strings.Contains(c.Path(), "/tokens") && c.Request().Method == "POST" {
-				return next(c);
+				return next(c)
 			}
 
 			token := c.QueryParam("access_token")
 			if len(token) == 0 {
 				token = c.Request().Header.Get("Authorization")
+
+				// the bare token is accepted as well as the standard bearer form
+				if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+					token = token[7:]
+				}
 			}
 			if len(token) == 0 {
 				// fall back to cookie
-				if cookie, err := c.Request().Cookie("2immerse_token"); err == nil {
+				if cookie, err := c.Request().Cookie(c.Get("config").(*config.Config).Tokens.Cookie.Name); err == nil {
 					token = cookie.Value
 				}
 			}
 
 			if len(token) > 0 {
 				// we have a token so now we lookup the user
-				s := db.Session.Clone()
-				defer s.Close()
+				ctx := c.Request().Context()
 
-				t := model.Token{}
-				if err := s.DB(db.Name).C("tokens").Find(bson.M{"token": token}).One(&t); err != nil {
-					logrus.Errorf("Error fetching token: %v", err)
-					return echo.ErrForbidden
+				t, err := s.Tokens().Get(ctx, token)
+				if err == storage.ErrNotFound {
+					logrus.Debugf("Unknown token %s", model.MaskToken(token))
+					return invalidToken
+				} else if err != nil {
+					return response.Internal(err)
 				}
 
 				// we have the token so now get the user
-				u := model.User{}
-				if err := s.DB(db.Name).C("users").Find(bson.M{"_id": t.User}).One(&u); err != nil {
-					logrus.Errorf("Error fetching user: %v", err)
-					return echo.ErrForbidden
+				u, err := s.Users().Get(ctx, t.User)
+				if err == storage.ErrNotFound {
+					logrus.Debugf("Token %s belongs to a removed user", model.MaskToken(token))
+					return invalidToken
+				} else if err != nil {
+					return response.Internal(err)
 				}
 
 				c.Set("user", u)
@@ -16,32 +16,86 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"strings"
 
 	"github.com/labstack/echo"
-	jsval "github.com/lestrrat-go/jsval"
+
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/tools"
 )
 
-func Validator(v *jsval.JSVal) echo.MiddlewareFunc {
+// Validator checks request bodies against the schema the API spec gives
+// their route. The schema is looked up on each request so a reload applies
+// straight away. JSON bodies are validated as decoded, forms as an object of
+// their values and anything else as a string.
+func Validator(v *tools.Validator) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			if v == nil {
+			name, ok := v.Route(c.Request().Method, c.Path())
+			if !ok || !v.Has(name) {
 				return next(c)
 			}
 
-			reqBody := []byte{}
-
-			if c.Request().Body != nil {
-				reqBody, _ = ioutil.ReadAll(c.Request().Body)
+			body, err := requestBody(c)
+			if err != nil {
+				return response.MalformedBody(err)
 			}
-			c.Request().Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
 
-			// now we have the body validate it
-			if err := v.Validate(reqBody); err != nil {
-				return err
+			if err := v.Validate(name, body); err != nil {
+				if verr, ok := err.(*tools.ValidationError); ok {
+					return response.Error{
+						Code:       response.CodeValidationFailed,
+						Message:    verr.Message,
+						Fields:     verr.Fields,
+						StatusCode: http.StatusUnprocessableEntity,
+					}
+				}
+
+				return response.Internal(err)
 			}
 
 			return next(c)
 		}
 	}
 }
+
+// requestBody decodes the body for validation, leaving it in place for the
+// handler to bind
+func requestBody(c echo.Context) (interface{}, error) {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+
+	if strings.HasPrefix(contentType, echo.MIMEApplicationForm) || strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		form, err := c.FormParams()
+		if err != nil {
+			return nil, err
+		}
+
+		object := map[string]interface{}{}
+		for key, values := range form {
+			object[key] = values[0]
+		}
+
+		return object, nil
+	}
+
+	reqBody := []byte{}
+
+	if c.Request().Body != nil {
+		reqBody, _ = ioutil.ReadAll(c.Request().Body)
+	}
+	c.Request().Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+
+	if !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+		return string(reqBody), nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(reqBody, &body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The AuditEvent type records something that happened to a user or token that
+// did not come from the user themselves, such as changes made by the gateway.
+type AuditEvent struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Type      string                 `bson:"type" json:"type"`
+	Source    string                 `bson:"source" json:"source"`
+	User      primitive.ObjectID     `bson:"user,omitempty" json:"user,omitzero"`
+	Token     string                 `bson:"token,omitempty" json:"token,omitempty"`
+	Message   string                 `bson:"message,omitempty" json:"message,omitempty"`
+	Data      map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt time.Time              `bson:"created" json:"created_at"`
+}
+
+// MaskToken hides all but the last few characters of a token so it can be
+// referenced in logs and audit events without being usable.
+func MaskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+
+	return "****" + token[len(token)-4:]
+}
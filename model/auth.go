@@ -18,4 +18,5 @@ type Auth struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Token    string `json:"token"`
+	Scope    string `json:"scope"`
 }
@@ -14,10 +14,13 @@
 
 package model
 
-import "gopkg.in/mgo.v2/bson"
+import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type Client struct {
-	ID           bson.ObjectId `bson:"_id,omitempty" jsonapi:"primary,clients"`
-	ClientID     string        `bson:"client_id" jsonapi:"attr,client_id"`
-	ClientSecret string        `bson:"client_secret" jsonapi:"attr,client_secret"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id" jsonapi:"primary,clients"`
+	ClientID     string             `bson:"client_id" json:"client_id" jsonapi:"attr,client_id"`
+	ClientSecret string             `bson:"client_secret" json:"client_secret,omitempty" jsonapi:"attr,client_secret"`
+	Name         string             `bson:"name" json:"name" jsonapi:"attr,name"`
+	RedirectURI  string             `bson:"redirect_uri,omitempty" json:"redirect_uri,omitempty" jsonapi:"attr,redirect_uri"`
+	Owner        primitive.ObjectID `bson:"owner,omitempty" json:"owner,omitzero" jsonapi:"attr,owner"`
 }
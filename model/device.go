@@ -17,19 +17,26 @@ package model
 import (
 	"fmt"
 	"math/rand"
+	"time"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const DeviceTypeCommunal = "communal"
 const DeviceTypeCompanion = "companion"
 
+// DeviceCodeLifetime is how long a generated code can be used to link a device
+const DeviceCodeLifetime = 24 * time.Hour
+
 type Device struct {
-	ID    string        `bson:"_id" json:"id"`
-	Type  string        `bson:"type" json:"type"`
-	Code  string        `bson:"code" json:"code,omitempty"`
-	Owner bson.ObjectId `bson:"owner,omitempty" json:"owner,omitempty"`
-	Aux   string        `bson:"aux,omitempty" json:"aux,omitempty"`
+	ID    string             `bson:"_id" json:"id"`
+	Type  string             `bson:"type" json:"type"`
+	Code  string             `bson:"code" json:"code,omitempty"`
+	Owner primitive.ObjectID `bson:"owner,omitempty" json:"owner,omitzero"`
+	Aux   string             `bson:"aux,omitempty" json:"aux,omitempty"`
+	// CodeExpires is when an unlinked device and its generated code are
+	// removed, it is cleared once the device is linked
+	CodeExpires time.Time `bson:"code_expires,omitempty" json:"-"`
 }
 
 func (d *Device) GenerateId() {
@@ -42,4 +49,10 @@ func (d *Device) RefreshCode() {
 	str := generateToken(5, false)
 
 	d.Code = fmt.Sprintf("%s%d%s", str[:2], num, str[2:len(str)])
+	d.CodeExpires = time.Now().Add(DeviceCodeLifetime)
+}
+
+// CodeExpired checks if the device's generated code can no longer be used
+func (d *Device) CodeExpired() bool {
+	return !d.CodeExpires.IsZero() && time.Now().After(d.CodeExpires)
 }
@@ -16,14 +16,14 @@ package model
 
 import (
 	"github.com/gosimple/slug"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Key struct {
-	ID    bson.ObjectId `bson:"_id,omitempty" json:"id"`
-	Title string        `bson:"title" json:"title,omitempty"`
-	Slug  string        `bson:"slug" json:"slug,omitempty"`
-	Data  []byte        `bson:"data,omitempty" json:"data"`
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title string             `bson:"title" json:"title,omitempty"`
+	Slug  string             `bson:"slug" json:"slug,omitempty"`
+	Data  []byte             `bson:"data,omitempty" json:"data"`
 }
 
 func (k *Key) GenerateSlug() {
@@ -0,0 +1,96 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The Policy type maps users onto gateway entitlements. A policy applies to a
+// token when the user has one of its roles, is in one of its groups and asked
+// for one of its scopes; an empty list matches anything.
+type Policy struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name     string             `bson:"name" json:"name"`
+	Roles    []string           `bson:"roles,omitempty" json:"roles,omitempty"`
+	Groups   []string           `bson:"groups,omitempty" json:"groups,omitempty"`
+	Scopes   []string           `bson:"scopes,omitempty" json:"scopes,omitempty"`
+	Priority int                `bson:"priority" json:"priority"`
+
+	// GatewayPolicies are ids of policies defined on the gateway itself
+	GatewayPolicies []string       `bson:"gateway_policies,omitempty" json:"gateway_policies,omitempty"`
+	AccessRights    []PolicyAccess `bson:"access_rights,omitempty" json:"access_rights,omitempty"`
+
+	// Limits, the highest priority matching policy wins
+	Rate             int `bson:"rate" json:"rate"`
+	Per              int `bson:"per" json:"per"`
+	QuotaMax         int `bson:"quota_max" json:"quota_max"`
+	QuotaRenewalRate int `bson:"quota_renewal_rate" json:"quota_renewal_rate"`
+}
+
+// PolicyAccess grants access to versions of an API on the gateway
+type PolicyAccess struct {
+	APIID    string   `bson:"api_id" json:"api_id"`
+	APIName  string   `bson:"api_name,omitempty" json:"api_name,omitempty"`
+	Versions []string `bson:"versions,omitempty" json:"versions,omitempty"`
+}
+
+// Matches checks if the policy applies to a token for the user and scope
+func (p *Policy) Matches(u User, scope string) bool {
+	if len(p.Roles) > 0 && !containsAny(p.Roles, u.Roles) {
+		return false
+	}
+
+	if len(p.Groups) > 0 && !containsAny(p.Groups, u.Groups) {
+		return false
+	}
+
+	if len(p.Scopes) > 0 && !containsAny(p.Scopes, []string{scope}) {
+		return false
+	}
+
+	return true
+}
+
+// MatchPolicies returns the policies that apply to the user and scope, highest
+// priority first
+func MatchPolicies(policies []Policy, u User, scope string) []Policy {
+	matched := []Policy{}
+	for _, p := range policies {
+		if p.Matches(u, scope) {
+			matched = append(matched, p)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
+
+	return matched
+}
+
+func containsAny(haystack []string, needles []string) bool {
+	for _, h := range haystack {
+		for _, n := range needles {
+			if h == n {
+				return true
+			}
+		}
+	}
+
+	return false
+}
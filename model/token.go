@@ -18,7 +18,7 @@ import (
 	"math/rand"
 	"time"
 
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const letterBytesLower = "abcdefghijklmnopqrstuvwxyz"
@@ -30,18 +30,26 @@ const (
 )
 
 type Token struct {
-	ID        bson.ObjectId `bson:"_id,omitempty" json:"-"`
-	ExpiresAt time.Time     `bson:"expires" json:"expires_at"`
-	Token     string        `bson:"token" json:"access_token"`
-	User      bson.ObjectId `bson:"user" json:"-"`
-	Client    bson.ObjectId `bson:"client,omitempty" json:"-"`
-	Aux       string        `bson:"aux,omitempty" json:"aux,omitempty"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ExpiresAt time.Time          `bson:"expires" json:"expires_at"`
+	Token     string             `bson:"token" json:"access_token"`
+	User      primitive.ObjectID `bson:"user" json:"-"`
+	Client    primitive.ObjectID `bson:"client,omitempty" json:"-"`
+	Aux       string             `bson:"aux,omitempty" json:"aux,omitempty"`
+	Scope     string             `bson:"scope,omitempty" json:"scope,omitempty"`
+
+	// QuotaExceededAt is set when the gateway reports the token has used up its quota
+	QuotaExceededAt time.Time `bson:"quota_exceeded,omitempty" json:"-"`
+
+	// GatewayPending is set when the gateway was down as the token was issued
+	GatewayPending bool `bson:"gateway_pending,omitempty" json:"-"`
 }
 
-func NewToken(user User) Token {
+// NewToken creates a token for user that is valid for lifetime
+func NewToken(user User, lifetime time.Duration) Token {
 	return Token{
-		ID:        bson.NewObjectId(),
-		ExpiresAt: time.Now().Add(time.Duration(604800 * time.Second)),
+		ID:        primitive.NewObjectID(),
+		ExpiresAt: time.Now().Add(lifetime),
 		Token:     generateToken(64, true),
 		User:      user.ID,
 	}
@@ -15,13 +15,16 @@
 package model
 
 import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/mgo.v2/bson"
 )
 
+// RoleUser is the role everyone who registers is given
+const RoleUser = "ROLE_USER"
+
 // The User type encapsulates details about a user and their profile
 type User struct {
-	ID            bson.ObjectId          `bson:"_id,omitempty" json:"id"`
+	ID            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
 	DisplayName   string                 `bson:"display_name,omitempty" json:"display_name,omitempty"`
 	FirstName     string                 `bson:"first_name,omitempty" json:"first_name,omitempty"`
 	LastName      string                 `bson:"last_name,omitempty" json:"last_name,omitempty"`
@@ -30,8 +33,9 @@ type User struct {
 	Password      []byte                 `bson:"password,omitempty" json:"-"`
 	Roles         []string               `bson:"roles,omitempty" json:"roles,omitempty"`
 	Groups        []string               `bson:"groups,omitempty" json:"groups,omitempty"`
-	Settings      map[string]interface{} `bson:"settings" json:"settings"`
+	Settings      map[string]interface{} `bson:"settings,omitempty" json:"settings,omitempty"`
 	Profile       *Profile               `bson:"profile,omitempty" json:"profile,omitempty"`
+	GatewayID     string                 `bson:"gateway_id,omitempty" json:"-"`
 }
 
 // The Profile type provides a map for companion and communal devices allowing
@@ -66,6 +70,15 @@ func (u *User) ValidatePassword(pass string) bool {
 	return true
 }
 
+// GatewayUserID is the id the gateway driver knows this user by
+func (u *User) GatewayUserID() string {
+	if len(u.GatewayID) > 0 {
+		return u.GatewayID
+	}
+
+	return u.ID.Hex()
+}
+
 func (u *User) HasRole(role string) bool {
 	for _, r := range u.Roles {
 		if r == role {
@@ -0,0 +1,87 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const WebhookDeliveryPending = "pending"
+const WebhookDeliveryDelivered = "delivered"
+const WebhookDeliveryFailed = "failed"
+
+// The Webhook type describes an endpoint registered by an admin that should be
+// notified when lifecycle events happen within the service.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"-"`
+	Events    []string           `bson:"events,omitempty" json:"events"`
+	Active    bool               `bson:"active" json:"active"`
+	CreatedAt time.Time          `bson:"created" json:"created_at"`
+}
+
+// The WebhookDelivery type records a single attempt to send an event to a
+// webhook, it doubles as the persistent queue the dispatcher works from.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Webhook        primitive.ObjectID `bson:"webhook" json:"webhook"`
+	Event          string             `bson:"event" json:"event"`
+	Payload        []byte             `bson:"payload" json:"-"`
+	Status         string             `bson:"status" json:"status"`
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	NextAttempt    time.Time          `bson:"next_attempt" json:"next_attempt,omitempty"`
+	ResponseStatus int                `bson:"response_status,omitempty" json:"response_status,omitempty"`
+	LastError      string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt      time.Time          `bson:"created" json:"created_at"`
+	DeliveredAt    time.Time          `bson:"delivered,omitempty" json:"delivered_at,omitempty"`
+}
+
+// GenerateSecret makes the random secret deliveries are signed with
+func (w *Webhook) GenerateSecret() error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	w.Secret = hex.EncodeToString(b)
+
+	return nil
+}
+
+// Subscribes checks if the webhook wants to receive the event. An empty filter
+// or "*" matches everything and "user.*" matches every user event.
+func (w *Webhook) Subscribes(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == "*" || e == event {
+			return true
+		}
+
+		if strings.HasSuffix(e, ".*") && strings.HasPrefix(event, strings.TrimSuffix(e, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	em "github.com/labstack/echo/middleware"
+
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/consul"
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/server"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/tools"
+	"github.com/2-IMMERSE/auth-service/trace"
+)
+
+// reloader applies a fresh configuration to the running service, on SIGHUP
+// or from the admin endpoint. Settings only read at startup keep their value
+// and are reported as needing a restart.
+type reloader struct {
+	mu        sync.Mutex
+	config    *config.Holder
+	cors      *middleware.Reloadable
+	validator *tools.Validator
+	driver    *auth.Switch
+	store     storage.Store
+	last      server.ReloadResult
+
+	// kv holds the settings from consul, nil when there are none
+	kv *consul.KV
+	// args is the command line the configuration is loaded from again
+	args []string
+}
+
+func newReloader(h *config.Holder, cors *middleware.Reloadable, v *tools.Validator, d *auth.Switch, s storage.Store, kv *consul.KV, args []string) *reloader {
+	return &reloader{
+		config:    h,
+		cors:      cors,
+		validator: v,
+		driver:    d,
+		store:     s,
+		last:      server.ReloadResult{At: time.Now(), OK: true},
+		kv:        kv,
+		args:      args,
+	}
+}
+
+// Reload loads the configuration again and puts it in effect. Requests in
+// progress finish with the configuration they started with.
+func (r *reloader) Reload() server.ReloadResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := server.ReloadResult{At: time.Now()}
+
+	restart, err := r.reload()
+	result.Restart = restart
+	if err != nil {
+		logrus.Errorf("Reload: %v", err)
+		result.Error = err.Error()
+	} else {
+		logrus.Info("Reload: configuration reloaded")
+		result.OK = true
+	}
+
+	if len(restart) > 0 {
+		logrus.Warnf("Reload: %s changed and will only apply after a restart", strings.Join(restart, ", "))
+	}
+
+	r.last = result
+	return result
+}
+
+func (r *reloader) LastReload() server.ReloadResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+func (r *reloader) reload() ([]string, error) {
+	next, err := reloadConfig(r.args)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.kv != nil {
+		if err := config.ApplyKV(next, r.kv.Values()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	current := r.config.Get()
+	restart := keepStartupSettings(current, next)
+
+	// everything that can fail goes first so a failed reload leaves the
+	// service as it was, the fixtures aside: they are upserted, so changed
+	// keys replace the ones stored and loading them again changes nothing.
+	// That is all the rotating there is: the service signs nothing itself,
+	// its tokens being random keys checked by the gateway, and the stored
+	// keys are only handed out to the services that sign with them.
+	if err := loadFixtures(r.store, next.Fixtures); err != nil {
+		return restart, fmt.Errorf("fixtures: %v", err)
+	}
+
+	driver := r.driver.Driver()
+	if !reflect.DeepEqual(next.Gateway, current.Gateway) {
+		if driver, err = auth.NewDriver(next.Gateway, gatewayServices(next.Gateway)); err != nil {
+			return restart, fmt.Errorf("gateway: %v", err)
+		}
+	}
+
+	// the schemas in use are only replaced when every one of them loads
+	if err := r.validator.Reload(); err != nil {
+		// the driver isn't used, so it mustn't keep watching consul
+		if c, ok := driver.(io.Closer); ok && driver != r.driver.Driver() {
+			c.Close()
+		}
+
+		return restart, err
+	}
+
+	if driver != r.driver.Driver() {
+		r.driver.Set(driver)
+		logrus.Infof("Reload: gateway %s settings applied", next.Gateway.Driver)
+	}
+
+	r.cors.Set(corsMiddleware(next.CORS))
+	r.config.Set(next)
+
+	return restart, nil
+}
+
+// reloadConfig loads the configuration from the command line the service
+// was started with
+func reloadConfig(args []string) (*config.Config, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	// the flags that aren't part of the configuration
+	fs.Bool("version", false, "")
+	fs.Bool("expire-tokens", false, "")
+
+	return config.Load(fs, args)
+}
+
+// keepStartupSettings copies the settings that can't change while running
+// from current to next, returning the ones that were changed
+func keepStartupSettings(current, next *config.Config) []string {
+	restart := []string{}
+
+	if next.Listen != current.Listen {
+		restart = append(restart, "listen")
+		next.Listen = current.Listen
+	}
+
+	if next.Debug != current.Debug {
+		restart = append(restart, "debug")
+		next.Debug = current.Debug
+	}
+
+	if next.LogFormat != current.LogFormat {
+		restart = append(restart, "log_format")
+		next.LogFormat = current.LogFormat
+	}
+
+	if next.Procs != current.Procs {
+		restart = append(restart, "procs")
+		next.Procs = current.Procs
+	}
+
+	if next.Schema != current.Schema {
+		restart = append(restart, "schema")
+		next.Schema = current.Schema
+	}
+
+	if next.API != current.API {
+		restart = append(restart, "api")
+		next.API = current.API
+	}
+
+	if next.Storage != current.Storage {
+		restart = append(restart, "storage")
+		next.Storage = current.Storage
+	}
+
+	if !reflect.DeepEqual(next.Encryption, current.Encryption) {
+		restart = append(restart, "encryption")
+		next.Encryption = current.Encryption
+	}
+
+	if next.Gateway.Driver != current.Gateway.Driver {
+		restart = append(restart, "gateway.driver")
+		next.Gateway.Driver = current.Gateway.Driver
+	}
+
+	if next.Consul != current.Consul {
+		restart = append(restart, "consul")
+		next.Consul = current.Consul
+	}
+
+	if next.Gateway.ReconcileInterval != current.Gateway.ReconcileInterval {
+		restart = append(restart, "gateway.reconcile_interval")
+		next.Gateway.ReconcileInterval = current.Gateway.ReconcileInterval
+	}
+
+	if next.Tokens.CleanupInterval != current.Tokens.CleanupInterval {
+		restart = append(restart, "tokens.cleanup_interval")
+		next.Tokens.CleanupInterval = current.Tokens.CleanupInterval
+	}
+
+	if next.ShutdownTimeout != current.ShutdownTimeout {
+		restart = append(restart, "shutdown_timeout")
+		next.ShutdownTimeout = current.ShutdownTimeout
+	}
+
+	return restart
+}
+
+func corsMiddleware(c config.CORS) echo.MiddlewareFunc {
+	logrus.Debugf("Allowing origins: %s", strings.Join(c.AllowOrigins, ", "))
+
+	return em.CORSWithConfig(em.CORSConfig{
+		AllowOrigins:     c.AllowOrigins,
+		ExposeHeaders:    []string{"Content-Range", trace.HeaderRequestID},
+		AllowCredentials: c.AllowCredentials,
+		AllowMethods:     []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE, echo.OPTIONS},
+		MaxAge:           c.MaxAge,
+	})
+}
@@ -14,16 +14,216 @@
 
 package response
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// MIMEProblemJSON is the media type errors are sent as
+const MIMEProblemJSON = "application/problem+json"
+
+// Codes tell errors apart without parsing their message, they don't change
+// between releases
+const (
+	CodeBadRequest         = "bad_request"
+	CodeMalformedBody      = "malformed_body"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeEmailInUse         = "email_in_use"
+	CodeCodeExpired        = "code_expired"
+	CodeNoPolicy           = "no_policy"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInternal           = "internal_error"
+	CodeGateway            = "gateway_error"
+	CodeUnavailable        = "service_unavailable"
+)
+
+// OAuth error codes from RFC 6749 and RFC 6750, sent as error by the OAuth
+// endpoints
+const (
+	OAuthInvalidRequest = "invalid_request"
+	OAuthInvalidClient  = "invalid_client"
+	OAuthInvalidGrant   = "invalid_grant"
+	OAuthInvalidToken   = "invalid_token"
+	OAuthServerError    = "server_error"
+	OAuthUnavailable    = "temporarily_unavailable"
+)
+
+// Error is sent as an RFC 7807 problem. Only Code and Message reach the
+// client, Err is for the log.
 type Error struct {
-	Message    string            `json:"message"`
-	Fields     map[string]string `json:"fields,omitempty"`
-	StatusCode int               `json:"-"`
+	Code       string
+	Message    string
+	Fields     map[string]string
+	StatusCode int
+
+	// Instance is the path of the request that failed
+	Instance string
+
+	// CorrelationID ties an internal error to the log entry with its cause
+	CorrelationID string
+
+	// OAuth is the RFC 6749 error code, only set on the OAuth endpoints
+	OAuth string
+
+	// Err is what caused the error
+	Err error
+}
+
+// NewError is an error with status and code
+func NewError(status int, code, message string) Error {
+	return Error{
+		Code:       code,
+		Message:    message,
+		StatusCode: status,
+	}
+}
+
+// BadRequest is a 400 for requests that can't be handled as they are
+func BadRequest(code, message string) Error {
+	return NewError(http.StatusBadRequest, code, message)
+}
+
+// MalformedBody is a 400 for a body that couldn't be decoded
+func MalformedBody(err error) Error {
+	return Error{
+		Code:       CodeMalformedBody,
+		Message:    "Request body is malformed: " + Message(err),
+		StatusCode: http.StatusBadRequest,
+		Err:        err,
+	}
+}
+
+// Invalid is a 400 saying what is wrong with each field
+func Invalid(message string, fields map[string]string) Error {
+	return Error{
+		Code:       CodeValidationFailed,
+		Message:    message,
+		Fields:     fields,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// NotFound is a 404 for something that doesn't exist, such as "User"
+func NotFound(what string) Error {
+	return NewError(http.StatusNotFound, CodeNotFound, what+" not found")
+}
+
+// Internal is a 500 caused by err, which is logged rather than sent
+func Internal(err error) Error {
+	return Error{
+		Code:       CodeInternal,
+		Message:    "An internal error occurred",
+		StatusCode: http.StatusInternalServerError,
+		Err:        err,
+	}
+}
+
+// Gateway is a 502 for a gateway call that failed with err, which is logged
+// rather than sent
+func Gateway(err error) Error {
+	return Error{
+		Code:       CodeGateway,
+		Message:    "The gateway was unable to complete the request",
+		StatusCode: http.StatusBadGateway,
+		Err:        err,
+	}
+}
+
+// CodeForStatus is the code for errors that only have a status
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusBadGateway:
+		return CodeGateway
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+
+	if status >= 500 {
+		return CodeInternal
+	}
+
+	return CodeBadRequest
+}
+
+// Message is the message of err without echo's code= prefix
+func Message(err error) string {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return fmt.Sprint(he.Message)
+	}
+
+	return err.Error()
 }
 
 func (e Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
 	return e.Message
 }
 
 func (e Error) Status() int {
 	return e.StatusCode
 }
+
+// MarshalJSON writes the problem, with the OAuth error alongside when set
+func (e Error) MarshalJSON() ([]byte, error) {
+	problem := map[string]interface{}{
+		"type":   "urn:2-immerse:auth-service:error:" + e.Code,
+		"title":  http.StatusText(e.StatusCode),
+		"status": e.StatusCode,
+		"code":   e.Code,
+	}
+
+	if len(e.Message) > 0 {
+		problem["detail"] = e.Message
+	}
+
+	if len(e.Instance) > 0 {
+		problem["instance"] = e.Instance
+	}
+
+	if len(e.Fields) > 0 {
+		problem["fields"] = e.Fields
+	}
+
+	if len(e.CorrelationID) > 0 {
+		problem["correlation_id"] = e.CorrelationID
+	}
+
+	if len(e.OAuth) > 0 {
+		problem["error"] = e.OAuth
+		problem["error_description"] = e.Message
+	}
+
+	return json.Marshal(problem)
+}
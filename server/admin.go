@@ -0,0 +1,68 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/middleware"
+)
+
+// Reloader reloads the configuration of the running service
+type Reloader interface {
+	Reload() ReloadResult
+
+	// LastReload is the result of the latest reload, or of loading the
+	// configuration at startup
+	LastReload() ReloadResult
+}
+
+// ReloadResult describes how a reload went
+type ReloadResult struct {
+	At    time.Time `json:"at"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+
+	// Restart lists changed settings that only apply after a restart
+	Restart []string `json:"restart_required,omitempty"`
+}
+
+type AdminServer struct {
+	reloader Reloader
+}
+
+func MountAdminServer(prefix string, e *echo.Echo, r Reloader) *AdminServer {
+	s := &AdminServer{
+		reloader: r,
+	}
+
+	g := e.Group(prefix, middleware.Auth(), middleware.Admin())
+
+	g.POST("/reload", s.reload)
+
+	return s
+}
+
+func (s *AdminServer) reload(c echo.Context) error {
+	result := s.reloader.Reload()
+	if !result.OK {
+		return c.JSON(http.StatusInternalServerError, result)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -16,45 +16,28 @@ package server
 
 import (
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/metrics"
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 	"github.com/2-IMMERSE/auth-service/tools"
-	"github.com/2-IMMERSE/auth-service/tyk"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/2-IMMERSE/auth-service/webhook"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
 type AuthServer struct {
-	client *tyk.Client
+	driver auth.Driver
 }
 
-func MountAuthServer(prefix string, e *echo.Echo, v *tools.Validator) *AuthServer {
-	var s *AuthServer
-	tykOrg := os.Getenv("TYK_ORG")
-	tykKey := os.Getenv("TYK_KEY")
-	tykURL := os.Getenv("TYK_URL")
-
-	if len(tykURL) == 0 || len(tykOrg) == 0 || len(tykKey) == 0 {
-		logrus.Info("Missing Tyk configuration, disabling integration!")
-
-		s = &AuthServer{}
-	} else {
-		c := tyk.NewClient(tyk.Config{
-			Organisation: tykOrg,
-			BaseURL:      tykURL,
-			Key:          tykKey,
-		})
-
-		s = &AuthServer{
-			client: c,
-		}
+func MountAuthServer(prefix string, e *echo.Echo, v *tools.Validator, d auth.Driver) *AuthServer {
+	s := &AuthServer{
+		driver: d,
 	}
 
 	g := e.Group(prefix)
@@ -68,87 +51,126 @@ func MountAuthServer(prefix string, e *echo.Echo, v *tools.Validator) *AuthServe
 func (s *AuthServer) createToken(c echo.Context) error {
 	a := model.Auth{}
 	if err := c.Bind(&a); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusBadRequest,
-		}
+		return response.MalformedBody(err)
 	}
 
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
+	store := c.Get("storage").(storage.Store)
+	tokens := c.Get("config").(*config.Config).Tokens
+	ctx := c.Request().Context()
 
-	u := model.User{}
-	if err := collection.Find(bson.M{"email": a.Username}).One(&u); err != nil {
-		return response.Error{
-			Message:    "Username or password incorrect",
-			StatusCode: http.StatusBadRequest,
-		}
+	u, err := store.Users().GetByEmail(ctx, a.Username)
+	if err == storage.ErrNotFound || (err == nil && !u.ValidatePassword(a.Password)) {
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		return response.BadRequest(response.CodeInvalidCredentials, "Username or password incorrect")
+	} else if err != nil {
+		return response.Internal(err)
 	}
 
-	if !u.ValidatePassword(a.Password) {
-		return response.Error{
-			Message:    "Username or password incorrect",
-			StatusCode: http.StatusBadRequest,
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+
+	// users loaded from fixtures or created before the driver was enabled
+	// won't exist on the gateway yet
+	if len(u.GatewayID) == 0 {
+		gu, err := s.driver.CreateUser(ctx, &auth.User{
+			ID:       u.ID.Hex(),
+			Username: u.Email,
+		})
+		if err != nil {
+			middleware.Log(c).Errorf("Gateway: unable to create user: %v", err)
+		} else {
+			u.GatewayID = gu.ID
+			store.Users().SetGatewayID(ctx, u.ID, u.GatewayID)
 		}
 	}
 
 	// create token
-	t := model.NewToken(u)
-
-	// send to tyk before storing in our db
-	if s.client != nil {
-		if err := s.client.CreateKey(t.Token); err != nil {
-			return response.Error{
-				Message:    err.Error(),
-				StatusCode: http.StatusInternalServerError,
-			}
-		}
+	t := model.NewToken(u, tokens.Lifetime)
+	t.Scope = a.Scope
+
+	policies, err := store.Policies().All(ctx)
+	if err != nil {
+		return response.Internal(err)
 	}
 
-	tokenCollection := db.C("tokens")
-	if err := tokenCollection.Insert(t); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	gt := auth.NewToken(u, t)
+	gt.Policies = model.MatchPolicies(policies, u, t.Scope)
+
+	// send to the gateway before storing in our db, unless the gateway is
+	// down in which case the driver will issue it once it's back
+	if err := s.driver.IssueToken(ctx, gt); err == auth.ErrUnavailable {
+		middleware.Log(c).Warnf("Gateway: unavailable, deferring token for %s", u.ID.Hex())
+		t.GatewayPending = true
+	} else if err == auth.ErrNoPolicy {
+		return response.NewError(http.StatusForbidden, response.CodeNoPolicy, "No policy gives the token access")
+	} else if err != nil {
+		return response.Gateway(err)
 	}
 
+	if err := store.Tokens().Create(ctx, t); err != nil {
+		return response.Internal(err)
+	}
+	metrics.TokensIssued.Inc()
+
 	cookie := new(http.Cookie)
-	cookie.Name = "2immerse_token"
+	cookie.Name = tokens.Cookie.Name
 	cookie.Value = t.Token
-	cookie.Path = "/"
-	cookie.Expires = time.Now().Add(36 * time.Hour)
+	cookie.Path = tokens.Cookie.Path
+	cookie.Domain = tokens.Cookie.Domain
+	cookie.Secure = tokens.Cookie.Secure
+	cookie.HttpOnly = tokens.Cookie.HTTPOnly
+	cookie.SameSite, _ = tokens.Cookie.SameSiteMode()
+
+	// without a lifetime it's a session cookie
+	if tokens.Cookie.Lifetime > 0 {
+		cookie.Expires = time.Now().Add(tokens.Cookie.Lifetime)
+	}
 
 	c.SetCookie(cookie)
 
+	webhook.Publish(c, webhook.EventTokenCreated, echo.Map{
+		"user":       t.User,
+		"expires_at": t.ExpiresAt,
+	})
+
 	return c.JSON(http.StatusCreated, t)
 }
 
 func (s *AuthServer) revokeToken(c echo.Context) error {
 	a := model.Auth{}
 	if err := c.Bind(&a); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusBadRequest,
-		}
+		return response.MalformedBody(err)
 	}
 
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("tokens")
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
-	if err := collection.Remove(bson.M{"token": a.Token}); err != nil {
-		// we have to check the string value as mgo returns many possible errors
-		if err.Error() != "not found" {
-			return response.Error{
-				Message:    err.Error(),
-				StatusCode: http.StatusInternalServerError,
-			}
+	t, err := store.Tokens().Remove(ctx, a.Token)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			return response.Internal(err)
 		}
+
+		// we don't know about it but make sure the gateway doesn't either
+		user := c.Get("user").(model.User)
+		s.driver.RevokeToken(ctx, &auth.Token{
+			Token:  a.Token,
+			UserID: user.GatewayUserID(),
+		})
+
+		return c.NoContent(http.StatusNoContent)
 	}
 
-	if s.client != nil {
-		s.client.DeleteKey(a.Token)
+	metrics.TokensRevoked.Inc()
+
+	u, _ := store.Users().Get(ctx, t.User)
+	if err := s.driver.RevokeToken(ctx, auth.NewToken(u, t)); err != nil && err != auth.ErrNotFound {
+		middleware.Log(c).Errorf("Gateway: unable to revoke token: %v", err)
 	}
 
+	webhook.Publish(c, webhook.EventTokenRevoked, echo.Map{
+		"user":       t.User,
+		"expires_at": t.ExpiresAt,
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
@@ -0,0 +1,58 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+)
+
+func TestCreateToken(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+
+		status int
+		code   string
+	}{
+		{name: "issued", status: http.StatusCreated},
+		{name: "no policy", err: auth.ErrNoPolicy, status: http.StatusForbidden, code: "no_policy"},
+		{name: "gateway failing", err: errors.New("boom"), status: http.StatusBadGateway, code: "gateway_error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t, memory.New())
+			u := s.addUser(t, "user@example.com")
+			s.driver.Err = test.err
+
+			status, code := s.request("POST", "/auth/tokens", nil, `{"username": "user@example.com", "password": "secret"}`)
+			if status != test.status || code != test.code {
+				t.Errorf("got %d %q, expected %d %q", status, code, test.status, test.code)
+			}
+
+			// only a token the gateway has is kept
+			tokens, _ := s.store.Tokens().ListByUser(context.Background(), u.ID)
+			if kept := len(tokens) > 0; kept != (test.err == nil) {
+				t.Errorf("token stored is %t", kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/tools"
+
+	"github.com/labstack/echo"
+)
+
+type ClientServer struct {
+	driver auth.Driver
+}
+
+func MountClientServer(prefix string, e *echo.Echo, v *tools.Validator, d auth.Driver) *ClientServer {
+	s := &ClientServer{
+		driver: d,
+	}
+
+	g := e.Group(prefix, middleware.Auth(), middleware.Admin())
+
+	g.GET("", s.index)
+	g.POST("", s.create)
+	g.GET("/:id", s.show)
+	g.DELETE("/:id", s.delete)
+
+	return s
+}
+
+func (s *ClientServer) index(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	pagination := tools.NewPagination("clients", c)
+
+	clients, count, err := store.Clients().List(c.Request().Context(), pagination.Page)
+	if err != nil {
+		return response.Internal(err)
+	}
+
+	pagination.AddHeaders(c.Response().Header(), count)
+
+	// secrets are only shown when the client is created
+	for i := range clients {
+		clients[i].ClientSecret = ""
+	}
+
+	return c.JSON(http.StatusOK, clients)
+}
+
+func (s *ClientServer) create(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	cl := model.Client{}
+	if err := c.Bind(&cl); err != nil {
+		return response.MalformedBody(err)
+	}
+
+	if len(cl.Name) == 0 {
+		return response.Invalid("Invalid client", map[string]string{"name": "required"})
+	}
+
+	// clients belong to the admin creating them unless told otherwise
+	owner := c.Get("user").(model.User)
+	if !cl.Owner.IsZero() && cl.Owner != owner.ID {
+		var err error
+		if owner, err = store.Users().Get(ctx, cl.Owner); err == storage.ErrNotFound {
+			return response.Invalid("Owner not found", map[string]string{"owner": "no such user"})
+		} else if err != nil {
+			return response.Internal(err)
+		}
+	}
+
+	gc, err := s.driver.CreateClient(ctx, &auth.Client{
+		ConsumerID:  owner.GatewayUserID(),
+		Name:        cl.Name,
+		RedirectURI: cl.RedirectURI,
+	})
+	if err != nil {
+		return response.Gateway(err)
+	}
+
+	cl.ID = primitive.NewObjectID()
+	cl.Owner = owner.ID
+	cl.ClientID = gc.ClientID
+	cl.ClientSecret = gc.ClientSecret
+
+	if err := store.Clients().Create(ctx, cl); err != nil {
+		return response.Internal(err)
+	}
+
+	return c.JSON(http.StatusCreated, cl)
+}
+
+func (s *ClientServer) show(c echo.Context) error {
+	cl, err := findClient(c)
+	if err != nil {
+		return err
+	}
+
+	cl.ClientSecret = ""
+
+	return c.JSON(http.StatusOK, cl)
+}
+
+func (s *ClientServer) delete(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	cl, err := findClient(c)
+	if err != nil {
+		return err
+	}
+
+	owner, _ := store.Users().Get(ctx, cl.Owner)
+
+	err = s.driver.DeleteClient(ctx, &auth.Client{
+		ClientID:   cl.ClientID,
+		ConsumerID: owner.GatewayUserID(),
+	})
+	if err != nil && err != auth.ErrNotFound {
+		return response.Gateway(err)
+	}
+
+	if err := store.Clients().Delete(ctx, cl.ID); err != nil {
+		return storageError(err, "Client")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func findClient(c echo.Context) (model.Client, error) {
+	store := c.Get("storage").(storage.Store)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return model.Client{}, response.NotFound("Client")
+	}
+
+	cl, err := store.Clients().Get(c.Request().Context(), id)
+	if err != nil {
+		return cl, storageError(err, "Client")
+	}
+
+	return cl, nil
+}
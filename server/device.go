@@ -17,16 +17,16 @@ package server
 import (
 	"net/http"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 	"github.com/2-IMMERSE/auth-service/tools"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/2-IMMERSE/auth-service/webhook"
 
 	"github.com/labstack/echo"
-
-	"github.com/Sirupsen/logrus"
 )
 
 type DeviceServer struct {
@@ -50,37 +50,32 @@ func MountDeviceServer(prefix string, e *echo.Echo, v *tools.Validator) *DeviceS
 
 func (s *DeviceServer) index(c echo.Context) error {
 	user := c.Get("user").(model.User)
+	store := c.Get("storage").(storage.Store)
 	pagination := tools.NewPagination("devices", c)
 
-	if !user.HasRole("ROLE_ADMIN") {
-		pagination.AddFilter("owner", user.ID)
+	// admins see every device
+	owner := user.ID
+	if user.HasRole("ROLE_ADMIN") {
+		owner = primitive.NilObjectID
 	}
 
-	devices := []model.Device{}
-	if err := pagination.All(&devices); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	devices, count, err := store.Devices().List(c.Request().Context(), owner, pagination.Page)
+	if err != nil {
+		return response.Internal(err)
 	}
 
-	if err := pagination.AddHeaders(c.Response().Header()); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
-	}
+	pagination.AddHeaders(c.Response().Header(), count)
 
 	return c.JSON(http.StatusOK, devices)
 }
 
 func (s *DeviceServer) register(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("devices")
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
 	d := model.Device{}
 	if err := c.Bind(&d); err != nil {
-		return err
+		return response.MalformedBody(err)
 	}
 
 	if len(d.Type) == 0 {
@@ -88,10 +83,7 @@ func (s *DeviceServer) register(c echo.Context) error {
 	}
 
 	if d.Type != model.DeviceTypeCompanion && d.Type != model.DeviceTypeCommunal {
-		return response.Error{
-			Message:    "Invalid device type",
-			StatusCode: http.StatusBadRequest,
-		}
+		return response.Invalid("Invalid device type", map[string]string{"type": "must be communal or companion"})
 	}
 
 	// generate a code for this device
@@ -101,10 +93,10 @@ func (s *DeviceServer) register(c echo.Context) error {
 		} else {
 			// If there is already a record for d.Code, don't allocate a new deviceId,
 			// return existing deviceId instead
-			if err := collection.Find(bson.M{"code": d.Code}).One(&d); err != nil {
-				logrus.Debugf("No record found for d.Code=%s, creating new record", d.Code)
+			if existing, err := store.Devices().GetByCode(ctx, d.Code); err != nil {
+				middleware.Log(c).Debugf("No record found for d.Code=%s, creating new record", d.Code)
 			} else {
-				return c.JSON(http.StatusCreated, d)
+				return c.JSON(http.StatusCreated, existing)
 			}
 		}
 	}
@@ -113,33 +105,31 @@ func (s *DeviceServer) register(c echo.Context) error {
 	for {
 		d.GenerateId()
 
-		if err := collection.Insert(d); err != nil {
-			if !mgo.IsDup(err) {
-				return response.Error{
-					Message:    err.Error(),
-					StatusCode: http.StatusInternalServerError,
-				}
+		if err := store.Devices().Create(ctx, d); err != nil {
+			if err != storage.ErrDuplicate {
+				return response.Internal(err)
 			}
 		} else {
+			event := d
+			event.Code = ""
+			webhook.Publish(c, webhook.EventDeviceRegistered, event)
+
 			return c.JSON(http.StatusCreated, d)
 		}
 	}
 }
 
 func (s *DeviceServer) check(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("devices")
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
-	d := model.Device{}
-	if err := collection.FindId(c.Param("id")).One(&d); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	d, err := store.Devices().Get(ctx, c.Param("id"))
+	if err != nil {
+		return storageError(err, "Device")
 	}
 
 	// is the owner set yet
-	if !d.Owner.Valid() {
+	if d.Owner.IsZero() {
 		// what is a sensible status code to return here?
 		// it needs to be simple and clear so that requesting clients
 		//   know quickly that thye should try again later
@@ -149,13 +139,9 @@ func (s *DeviceServer) check(c echo.Context) error {
 	}
 
 	// lookup the token attached to the owner
-	tokenCollection := db.C("tokens")
-	t := model.Token{}
-	if err := tokenCollection.Find(bson.M{"user": d.Owner}).Sort("-expires").One(&t); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	t, err := store.Tokens().Latest(ctx, d.Owner)
+	if err != nil {
+		return storageError(err, "Token")
 	}
 	t.Aux = d.Aux
 
@@ -165,28 +151,24 @@ func (s *DeviceServer) check(c echo.Context) error {
 
 func (s *DeviceServer) delete(c echo.Context) error {
 	user := c.Get("user").(model.User)
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("devices")
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
-	d := model.Device{}
-//	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&d); err != nil {
-	if err := collection.FindId(c.Param("id")).One(&d); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	d, err := store.Devices().Get(ctx, c.Param("id"))
+	if err != nil {
+		return storageError(err, "Device")
 	}
 
 	if !user.HasRole("ROLE_ADMIN") && d.Owner != user.ID {
-		return echo.ErrForbidden
+		return response.NewError(http.StatusForbidden, response.CodeForbidden, "Only the owner of a device can delete it")
 	}
 
-	if err := collection.RemoveId(d.ID); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Devices().Delete(ctx, d.ID); err != nil {
+		return storageError(err, "Device")
 	}
 
+	d.Code = ""
+	webhook.Publish(c, webhook.EventDeviceUnlinked, d)
+
 	return c.NoContent(http.StatusNoContent)
 }
@@ -0,0 +1,36 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// storageError is the error for a failed lookup or write of what, such as
+// "User". A missing record is a 404, one clashing with another is a 409 and
+// anything else is logged as an internal error.
+func storageError(err error, what string) error {
+	switch err {
+	case storage.ErrNotFound:
+		return response.NotFound(what)
+	case storage.ErrDuplicate:
+		return response.NewError(http.StatusConflict, response.CodeConflict, what+" already exists")
+	}
+
+	return response.Internal(err)
+}
@@ -15,38 +15,48 @@
 package server
 
 import (
+	"expvar"
 	"net/http"
 
-	mgo "gopkg.in/mgo.v2"
-
 	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/health"
+	"github.com/2-IMMERSE/auth-service/storage"
 )
 
 type HealthcheckServer struct {
-	echo *echo.Echo
+	echo     *echo.Echo
+	reloader Reloader
+	checker  *health.Checker
 }
 
-func MountHealthcheckServer(prefix string, e *echo.Echo, debug bool) *HealthcheckServer {
+func MountHealthcheckServer(prefix string, e *echo.Echo, debug bool, r Reloader, h *health.Checker) *HealthcheckServer {
 	s := &HealthcheckServer{
-		echo: e,
+		echo:     e,
+		reloader: r,
+		checker:  h,
 	}
 
 	g := e.Group(prefix)
 
 	g.GET("", s.status)
+	g.GET("/live", s.live)
+	g.GET("/ready", s.ready)
+	g.GET("/reload", s.reload)
 
 	if debug {
 		g.GET("/routes", s.routes)
+		g.GET("/vars", echo.WrapHandler(expvar.Handler()))
 	}
 
 	return s
 }
 
 func (s *HealthcheckServer) status(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
+	store := c.Get("storage").(storage.Store)
 
-	if err := db.Session.Ping(); err != nil {
+	if err := store.Ping(c.Request().Context()); err != nil {
 		logrus.Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
@@ -54,6 +64,34 @@ func (s *HealthcheckServer) status(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// live reports the service is running, whatever state its dependencies
+// are in, so it is only restarted when it stops answering
+func (s *HealthcheckServer) live(c echo.Context) error {
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ready reports every dependency and startup step, failing until the
+// service can handle requests
+func (s *HealthcheckServer) ready(c echo.Context) error {
+	report := s.checker.Check(c.Request().Context())
+	if !report.Ready {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// reload reports the last reload, failing while its configuration is not
+// the one in effect
+func (s *HealthcheckServer) reload(c echo.Context) error {
+	result := s.reloader.LastReload()
+	if !result.OK {
+		return c.JSON(http.StatusInternalServerError, result)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
 func (s *HealthcheckServer) routes(c echo.Context) error {
 	return c.JSON(http.StatusOK, s.echo.Routes())
 }
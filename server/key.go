@@ -19,15 +19,14 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 	"github.com/2-IMMERSE/auth-service/tools"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
@@ -42,36 +41,22 @@ func MountKeyServer(prefix string, e *echo.Echo, validator *tools.Validator) *Ke
 	g := e.Group(prefix)
 
 	g.GET("", s.index, middleware.Auth()).Name = "keys_list"
-	g.POST("", s.create, middleware.Validator(validator.GetValidator("key"))).Name = "keys_create"
+	g.POST("", s.create).Name = "keys_create"
 	g.GET("/:id", s.show, middleware.Auth()).Name = "keys_show"
 
 	return s
 }
 
 func (s *KeyServer) index(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("keys")
+	store := c.Get("storage").(storage.Store)
 
-	query := collection.Find(bson.M{})
-
-	count, err := query.Count()
+	keys, err := store.Keys().List(c.Request().Context())
 	if err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
-	}
-
-	keys := []model.Key{}
-	if err := query.All(&keys); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+		return response.Internal(err)
 	}
 
 	c.Response().Header().Set("Accept-Ranges", "keys")
-	c.Response().Header().Set("Content-Range", fmt.Sprintf("keys 0-10/%d", count))
+	c.Response().Header().Set("Content-Range", fmt.Sprintf("keys 0-10/%d", len(keys)))
 
 	return c.JSON(http.StatusOK, keys)
 }
@@ -79,29 +64,20 @@ func (s *KeyServer) index(c echo.Context) error {
 func (s *KeyServer) create(c echo.Context) error {
 	d, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
-		logrus.Error(err)
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+		return response.Internal(err)
 	}
 
 	k := model.Key{
 		Data: d,
 	}
 
-	k.ID = bson.NewObjectId()
+	k.ID = primitive.NewObjectID()
 	k.GenerateSlug()
 
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("keys")
+	store := c.Get("storage").(storage.Store)
 
-	if err := collection.Insert(k); err != nil {
-		logrus.Error(err)
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Keys().Create(c.Request().Context(), k); err != nil {
+		return response.Internal(err)
 	}
 
 	return c.JSON(http.StatusCreated, response.Resource{
@@ -110,15 +86,16 @@ func (s *KeyServer) create(c echo.Context) error {
 }
 
 func (s *KeyServer) show(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("keys")
-
-	k := model.Key{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&k); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	store := c.Get("storage").(storage.Store)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return response.NotFound("Key")
+	}
+
+	k, err := store.Keys().Get(c.Request().Context(), id)
+	if err != nil {
+		return storageError(err, "Key")
 	}
 
 	return c.Blob(http.StatusOK, "application/octet-stream", k.Data)
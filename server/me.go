@@ -16,23 +16,28 @@ package server
 
 import (
 	"net/http"
+	"time"
 
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
-
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/metrics"
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 	"github.com/2-IMMERSE/auth-service/tools"
+	"github.com/2-IMMERSE/auth-service/webhook"
 
 	"github.com/labstack/echo"
 )
 
 type MeServer struct {
+	driver auth.Driver
 }
 
-func MountMeServer(prefix string, e *echo.Echo, v *tools.Validator) *MeServer {
-	s := &MeServer{}
+func MountMeServer(prefix string, e *echo.Echo, v *tools.Validator, d auth.Driver) *MeServer {
+	s := &MeServer{
+		driver: d,
+	}
 
 	g := e.Group(prefix, middleware.Auth())
 
@@ -53,13 +58,34 @@ func (s *MeServer) showUser(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
+// meUpdate is all users can change of themselves, roles and groups are
+// only changed by an admin
+type meUpdate struct {
+	Email       string                 `json:"email"`
+	Password    string                 `json:"password"`
+	DisplayName string                 `json:"display_name"`
+	FirstName   string                 `json:"first_name"`
+	LastName    string                 `json:"last_name"`
+	Settings    map[string]interface{} `json:"settings"`
+}
+
 func (s *MeServer) updateUser(c echo.Context) error {
 	user := c.Get("user").(model.User)
-	db := c.Get("mgo_db").(*mgo.Database)
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
-	u := model.User{}
-	if err := c.Bind(&u); err != nil {
-		return err
+	m := meUpdate{}
+	if err := c.Bind(&m); err != nil {
+		return response.MalformedBody(err)
+	}
+
+	u := model.User{
+		Email:         m.Email,
+		PlainPassword: m.Password,
+		DisplayName:   m.DisplayName,
+		FirstName:     m.FirstName,
+		LastName:      m.LastName,
+		Settings:      m.Settings,
 	}
 
 	clearTokens := false
@@ -68,18 +94,19 @@ func (s *MeServer) updateUser(c echo.Context) error {
 		clearTokens = true
 	}
 
-	if err := db.C("users").UpdateId(user.ID, bson.M{"$set": u}); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Users().Update(ctx, user.ID, u); err != nil {
+		return storageError(err, "User")
 	}
 
 	if clearTokens {
 		// delete all this users tokens
-		db.C("tokens").RemoveAll(bson.M{"user": user.ID})
+		auth.RevokeUserTokens(ctx, store, s.driver, user)
 	}
 
+	webhook.Publish(c, webhook.EventUserUpdated, response.Resource{
+		ID: user.ID.Hex(),
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -95,17 +122,14 @@ func (s *MeServer) showProfile(c echo.Context) error {
 
 func (s *MeServer) updateProfile(c echo.Context) error {
 	user := c.Get("user").(model.User)
-	db := c.Get("mgo_db").(*mgo.Database)
+	store := c.Get("storage").(storage.Store)
 
 	if err := c.Bind(&user.Profile); err != nil {
-		return err
+		return response.MalformedBody(err)
 	}
 
-	if err := db.C("users").UpdateId(user.ID, bson.M{"$set": bson.M{"profile": user.Profile}}); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Users().SetProfile(c.Request().Context(), user.ID, user.Profile); err != nil {
+		return storageError(err, "User")
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -133,33 +157,35 @@ func (s *MeServer) showGroups(c echo.Context) error {
 
 func (s *MeServer) linkDevice(c echo.Context) error {
 	// lookup device
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("devices")
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
 
-	d := model.Device{}
-	if err := c.Bind(&d); err != nil {
-		return err
+	link := model.Device{}
+	if err := c.Bind(&link); err != nil {
+		return response.MalformedBody(err)
 	}
 
-	aux := d.Aux
+	d, err := store.Devices().GetByCode(ctx, link.Code)
+	if err != nil {
+		return storageError(err, "Device")
+	}
 
-	// d := model.Device{}
-	if err := collection.Find(bson.M{"code": d.Code}).One(&d); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	if d.CodeExpired() {
+		return response.NewError(http.StatusNotFound, response.CodeCodeExpired, "Code expired")
 	}
 
 	d.Owner = c.Get("user").(model.User).ID
-	d.Aux = aux
+	d.Aux = link.Aux
+	d.CodeExpires = time.Time{}
 
-	if err := collection.UpdateId(d.ID, d); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Devices().Update(ctx, d); err != nil {
+		return storageError(err, "Device")
 	}
+	metrics.DeviceLinks.Inc()
+
+	// the pairing code is a secret so don't pass it on
+	d.Code = ""
+	webhook.Publish(c, webhook.EventDeviceLinked, d)
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -0,0 +1,56 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo"
+
+	"github.com/2-IMMERSE/auth-service/api"
+)
+
+type OpenAPIServer struct {
+	echo    *echo.Echo
+	spec    *api.Spec
+	version string
+
+	once     sync.Once
+	document map[string]interface{}
+}
+
+// MountOpenAPIServer serves an OpenAPI description of every route mounted
+// on e at path. It is built on the first request, once everything is
+// mounted. The spec can be nil, leaving only the routes described.
+func MountOpenAPIServer(path string, e *echo.Echo, spec *api.Spec, version string) *OpenAPIServer {
+	s := &OpenAPIServer{
+		echo:    e,
+		spec:    spec,
+		version: version,
+	}
+
+	e.GET(path, s.show)
+
+	return s
+}
+
+func (s *OpenAPIServer) show(c echo.Context) error {
+	s.once.Do(func() {
+		s.document = s.spec.OpenAPI(api.Mounted(s.echo), s.version)
+	})
+
+	return c.JSON(http.StatusOK, s.document)
+}
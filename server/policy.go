@@ -0,0 +1,177 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/tools"
+
+	"github.com/labstack/echo"
+)
+
+type PolicyServer struct {
+}
+
+func MountPolicyServer(prefix string, e *echo.Echo, v *tools.Validator) *PolicyServer {
+	s := &PolicyServer{}
+
+	g := e.Group(prefix, middleware.Auth(), middleware.Admin())
+
+	g.GET("", s.index)
+	g.POST("", s.create)
+	g.GET("/:id", s.show)
+	g.PUT("/:id", s.update)
+	g.DELETE("/:id", s.delete)
+
+	return s
+}
+
+func (s *PolicyServer) index(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	pagination := tools.NewPagination("policies", c)
+
+	policies, count, err := store.Policies().List(c.Request().Context(), pagination.Page)
+	if err != nil {
+		return response.Internal(err)
+	}
+
+	pagination.AddHeaders(c.Response().Header(), count)
+
+	return c.JSON(http.StatusOK, policies)
+}
+
+func (s *PolicyServer) create(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+
+	p := model.Policy{}
+	if err := c.Bind(&p); err != nil {
+		return response.MalformedBody(err)
+	}
+
+	if err := validatePolicy(p); err != nil {
+		return err
+	}
+
+	p.ID = primitive.NewObjectID()
+
+	if err := store.Policies().Create(c.Request().Context(), p); err != nil {
+		return response.Internal(err)
+	}
+
+	return c.JSON(http.StatusCreated, response.Resource{
+		ID: p.ID.Hex(),
+	})
+}
+
+func (s *PolicyServer) show(c echo.Context) error {
+	p, err := findPolicy(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, p)
+}
+
+func (s *PolicyServer) update(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+
+	existing, err := findPolicy(c)
+	if err != nil {
+		return err
+	}
+
+	// policies are replaced as a whole so stale limits don't linger
+	p := model.Policy{}
+	if err := c.Bind(&p); err != nil {
+		return response.MalformedBody(err)
+	}
+	p.ID = existing.ID
+
+	if err := validatePolicy(p); err != nil {
+		return err
+	}
+
+	if err := store.Policies().Replace(c.Request().Context(), p); err != nil {
+		return storageError(err, "Policy")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *PolicyServer) delete(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+
+	p, err := findPolicy(c)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Policies().Delete(c.Request().Context(), p.ID); err != nil {
+		return storageError(err, "Policy")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func findPolicy(c echo.Context) (model.Policy, error) {
+	store := c.Get("storage").(storage.Store)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return model.Policy{}, response.NotFound("Policy")
+	}
+
+	p, err := store.Policies().Get(c.Request().Context(), id)
+	if err != nil {
+		return p, storageError(err, "Policy")
+	}
+
+	return p, nil
+}
+
+func validatePolicy(p model.Policy) error {
+	fields := map[string]string{}
+
+	if len(p.Name) == 0 {
+		fields["name"] = "required"
+	}
+
+	if p.Rate < 0 || p.Per < 0 || (p.Rate > 0 && p.Per == 0) {
+		fields["per"] = "rate and per must both be positive"
+	}
+
+	if p.QuotaMax > 0 && p.QuotaRenewalRate <= 0 {
+		fields["quota_renewal_rate"] = "must be positive when quota_max is set"
+	}
+
+	for _, a := range p.AccessRights {
+		if len(a.APIID) == 0 {
+			fields["access_rights"] = "api_id is required"
+		}
+	}
+
+	if len(fields) > 0 {
+		return response.Invalid("Invalid policy", fields)
+	}
+
+	return nil
+}
@@ -17,27 +17,43 @@ package server
 import (
 	"net/http"
 
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/2-IMMERSE/auth-service/auth"
 	"github.com/2-IMMERSE/auth-service/middleware"
 	"github.com/2-IMMERSE/auth-service/model"
 	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
 	"github.com/2-IMMERSE/auth-service/tools"
+	"github.com/2-IMMERSE/auth-service/webhook"
 
 	"github.com/labstack/echo"
 )
 
 type UserServer struct {
+	driver auth.Driver
 }
 
-func MountUserServer(prefix string, e *echo.Echo, v *tools.Validator) *UserServer {
-	s := &UserServer{}
+// registration is all anyone can set when registering, roles and groups are
+// only given by an admin
+type registration struct {
+	Email       string         `json:"email"`
+	Password    string         `json:"password"`
+	DisplayName string         `json:"display_name"`
+	FirstName   string         `json:"first_name"`
+	LastName    string         `json:"last_name"`
+	Profile     *model.Profile `json:"profile"`
+}
+
+func MountUserServer(prefix string, e *echo.Echo, v *tools.Validator, d auth.Driver) *UserServer {
+	s := &UserServer{
+		driver: d,
+	}
 	g := e.Group(prefix)
 
 	// account
 	g.GET("", s.index, middleware.Auth(), middleware.Admin())
-	g.POST("", s.create, middleware.Validator(v.GetValidator("user")))
+	g.POST("", s.create)
 	g.GET("/:id", s.show, middleware.Auth(), middleware.Admin())
 	g.PATCH("/:id", s.update, middleware.Auth(), middleware.Admin())
 	g.DELETE("/:id", s.delete, middleware.Auth(), middleware.Admin())
@@ -56,101 +72,110 @@ func MountUserServer(prefix string, e *echo.Echo, v *tools.Validator) *UserServe
 }
 
 func (s *UserServer) index(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
 	pagination := tools.NewPagination("users", c)
 
-	users := []model.User{}
-	if err := pagination.All(&users); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	users, count, err := store.Users().List(c.Request().Context(), pagination.Page)
+	if err != nil {
+		return response.Internal(err)
 	}
 
-	if err := pagination.AddHeaders(c.Response().Header()); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
-	}
+	pagination.AddHeaders(c.Response().Header(), count)
 
 	return c.JSON(http.StatusOK, users)
 }
 
 func (s *UserServer) create(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := c.Bind(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusBadRequest,
-		}
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	r := registration{}
+	if err := c.Bind(&r); err != nil {
+		return response.MalformedBody(err)
 	}
 
-	if count, _ := collection.Find(bson.M{"email": u.Email}).Count(); count > 0 {
-		return response.Error{
-			Message:    "Email address already in use",
-			StatusCode: http.StatusBadRequest,
-		}
+	u := model.User{
+		Email:         r.Email,
+		PlainPassword: r.Password,
+		DisplayName:   r.DisplayName,
+		FirstName:     r.FirstName,
+		LastName:      r.LastName,
+		Roles:         []string{model.RoleUser},
+	}
+
+	if _, err := store.Users().GetByEmail(ctx, u.Email); err == nil {
+		return response.NewError(http.StatusConflict, response.CodeEmailInUse, "Email address already in use")
+	} else if err != storage.ErrNotFound {
+		return response.Internal(err)
 	}
 
-	u.ID = bson.NewObjectId()
+	u.ID = primitive.NewObjectID()
 	u.Profile = &model.Profile{
 		Communal:  make(map[string]interface{}),
 		Companion: make(map[string]interface{}),
 	}
+	if r.Profile != nil && r.Profile.Communal != nil {
+		u.Profile.Communal = r.Profile.Communal
+	}
+	if r.Profile != nil && r.Profile.Companion != nil {
+		u.Profile.Companion = r.Profile.Companion
+	}
 
 	// update the users password
 	if err := u.HashPassword(); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+		return response.Internal(err)
+	}
+
+	gu, err := s.driver.CreateUser(ctx, &auth.User{
+		ID:       u.ID.Hex(),
+		Username: u.Email,
+	})
+	if err != nil {
+		return response.Gateway(err)
 	}
+	u.GatewayID = gu.ID
+
+	if err := store.Users().Create(ctx, u); err != nil {
+		// the email is taken on the gateway until its user goes too
+		if gerr := s.driver.DeleteUser(ctx, u.GatewayUserID()); gerr != nil && gerr != auth.ErrNotFound && gerr != auth.ErrNotSupported {
+			middleware.Log(c).Errorf("Gateway: unable to delete user: %v", gerr)
+		}
 
-	if err := collection.Insert(u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
+		if err == storage.ErrDuplicate {
+			return response.NewError(http.StatusConflict, response.CodeEmailInUse, "Email address already in use")
 		}
+
+		return response.Internal(err)
 	}
 
+	webhook.Publish(c, webhook.EventUserCreated, u)
+
 	return c.JSON(http.StatusCreated, response.Resource{
 		ID: u.ID.Hex(),
 	})
 }
 
 func (s *UserServer) show(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	u, err := findUser(c)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, u)
 }
 
 func (s *UserServer) update(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	u, err := findUser(c)
+	if err != nil {
+		return err
 	}
 
 	update := model.User{}
 	if err := c.Bind(&update); err != nil {
-		return err
+		return response.MalformedBody(err)
 	}
 
 	clearTokens := false
@@ -159,80 +184,73 @@ func (s *UserServer) update(c echo.Context) error {
 		clearTokens = true
 	}
 
-	if err := collection.UpdateId(u.ID, bson.M{"$set": update}); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Users().Update(ctx, u.ID, update); err != nil {
+		return storageError(err, "User")
 	}
 
 	if clearTokens {
 		// delete all this users tokens
-		db.C("tokens").RemoveAll(bson.M{"user": u.ID})
+		auth.RevokeUserTokens(ctx, store, s.driver, u)
 	}
 
+	webhook.Publish(c, webhook.EventUserUpdated, response.Resource{
+		ID: u.ID.Hex(),
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
 
 func (s *UserServer) delete(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	u, err := findUser(c)
+	if err != nil {
+		return err
 	}
 
-	if err := collection.RemoveId(u.ID); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Users().Delete(ctx, u.ID); err != nil {
+		return storageError(err, "User")
+	}
+
+	auth.RevokeUserTokens(ctx, store, s.driver, u)
+
+	if err := s.driver.DeleteUser(ctx, u.GatewayUserID()); err != nil && err != auth.ErrNotFound && err != auth.ErrNotSupported {
+		middleware.Log(c).Errorf("Gateway: unable to delete user: %v", err)
 	}
 
+	webhook.Publish(c, webhook.EventUserDeleted, response.Resource{
+		ID: u.ID.Hex(),
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
 
 // profile
 func (s *UserServer) showProfile(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	u, err := findUser(c)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, u.Profile)
 }
 
 func (s *UserServer) updateProfile(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	u, err := findUser(c)
+	if err != nil {
+		return err
 	}
 
 	if err := c.Bind(&u.Profile); err != nil {
-		return err
+		return response.MalformedBody(err)
 	}
 
-	if err := collection.UpdateId(u.ID, bson.M{"$set": bson.M{"profile": u.Profile}}); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := store.Users().SetProfile(ctx, u.ID, u.Profile); err != nil {
+		return storageError(err, "User")
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -240,15 +258,13 @@ func (s *UserServer) updateProfile(c echo.Context) error {
 
 // roles
 func (s *UserServer) showRoles(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	u, err := findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if u.Roles == nil {
+		u.Roles = []string{}
 	}
 
 	return c.JSON(http.StatusOK, u.Roles)
@@ -256,16 +272,30 @@ func (s *UserServer) showRoles(c echo.Context) error {
 
 // groups
 func (s *UserServer) showGroups(c echo.Context) error {
-	db := c.Get("mgo_db").(*mgo.Database)
-	collection := db.C("users")
-
-	u := model.User{}
-	if err := collection.FindId(bson.ObjectIdHex(c.Param("id"))).One(&u); err != nil {
-		return response.Error{
-			Message:    err.Error(),
-			StatusCode: http.StatusNotFound,
-		}
+	u, err := findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if u.Groups == nil {
+		u.Groups = []string{}
 	}
 
 	return c.JSON(http.StatusOK, u.Groups)
 }
+
+func findUser(c echo.Context) (model.User, error) {
+	store := c.Get("storage").(storage.Store)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return model.User{}, response.NotFound("User")
+	}
+
+	u, err := store.Users().Get(c.Request().Context(), id)
+	if err != nil {
+		return u, storageError(err, "User")
+	}
+
+	return u, nil
+}
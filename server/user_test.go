@@ -0,0 +1,225 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/auth"
+	"github.com/2-IMMERSE/auth-service/config"
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+)
+
+// testServer mounts the user handlers on memory storage and a fake gateway.
+// Requests are made as the user whose id is in the X-Test-User header.
+type testServer struct {
+	e      *echo.Echo
+	store  storage.Store
+	driver *auth.Fake
+}
+
+func newTestServer(t *testing.T, store storage.Store) *testServer {
+	s := &testServer{
+		e:      echo.New(),
+		store:  store,
+		driver: auth.NewFake(),
+	}
+
+	s.e.HTTPErrorHandler = middleware.ErrorHandler()
+	s.e.Use(middleware.Storage(store))
+	s.e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("config", config.Default())
+			return next(c)
+		}
+	})
+	s.e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if id, err := primitive.ObjectIDFromHex(c.Request().Header.Get("X-Test-User")); err == nil {
+				u, err := store.Users().Get(c.Request().Context(), id)
+				if err != nil {
+					t.Fatalf("no user %s to make the request as", id.Hex())
+				}
+				c.Set("user", u)
+			}
+
+			return next(c)
+		}
+	})
+
+	MountUserServer("/users", s.e, nil, s.driver)
+	MountMeServer("/me", s.e, nil, s.driver)
+	MountAuthServer("/auth", s.e, nil, s.driver)
+
+	return s
+}
+
+// addUser stores a user with the given roles and the password secret
+func (s *testServer) addUser(t *testing.T, email string, roles ...string) model.User {
+	u := model.User{ID: primitive.NewObjectID(), Email: email, PlainPassword: "secret", Roles: roles}
+	u.HashPassword()
+	if err := s.store.Users().Create(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+// request makes a request as user, when given, returning the response status
+// and problem code
+func (s *testServer) request(method, path string, as *model.User, body string) (int, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if as != nil {
+		req.Header.Set("X-Test-User", as.ID.Hex())
+	}
+
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+
+	problem := struct {
+		Code string `json:"code"`
+	}{}
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+
+	return rec.Code, problem.Code
+}
+
+func TestUserCreate(t *testing.T) {
+	s := newTestServer(t, memory.New())
+
+	status, _ := s.request("POST", "/users", nil, `{"email": "user@example.com", "password": "secret"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got %d registering, expected %d", status, http.StatusCreated)
+	}
+
+	u, err := s.store.Users().GetByEmail(context.Background(), "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.HasRole(model.RoleUser) || len(u.Roles) != 1 {
+		t.Errorf("got roles %v, expected only %s", u.Roles, model.RoleUser)
+	}
+	if _, err := s.driver.GetUser(context.Background(), u.GatewayUserID()); err != nil {
+		t.Errorf("no gateway user created: %v", err)
+	}
+
+	if status, code := s.request("POST", "/users", nil, `{"email": "user@example.com", "password": "other"}`); status != http.StatusConflict || code != "email_in_use" {
+		t.Errorf("got %d %s registering a taken email, expected %d email_in_use", status, code, http.StatusConflict)
+	}
+}
+
+// racingUsers never finds a user by email, as if another instance registered
+// it between the lookup and the insert
+type racingUsers struct {
+	storage.UserRepository
+}
+
+func (r racingUsers) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	return model.User{}, storage.ErrNotFound
+}
+
+type racingStore struct {
+	*memory.Store
+}
+
+func (s racingStore) Users() storage.UserRepository {
+	return racingUsers{s.Store.Users()}
+}
+
+func TestUserCreateRace(t *testing.T) {
+	store := racingStore{memory.New()}
+	s := newTestServer(t, store)
+	s.addUser(t, "user@example.com")
+
+	status, code := s.request("POST", "/users", nil, `{"email": "user@example.com", "password": "secret"}`)
+	if status != http.StatusConflict || code != "email_in_use" {
+		t.Errorf("got %d %s, expected %d email_in_use", status, code, http.StatusConflict)
+	}
+
+	if users := s.driver.Users(); len(users) != 0 {
+		t.Errorf("left %d users on the gateway", len(users))
+	}
+}
+
+func TestUserUpdate(t *testing.T) {
+	s := newTestServer(t, memory.New())
+	admin := s.addUser(t, "admin@example.com", "ROLE_ADMIN")
+	user := s.addUser(t, "user@example.com", model.RoleUser)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		as     *model.User
+		body   string
+
+		status int
+		code   string
+	}{
+		{name: "by an admin", method: "PATCH", path: "/users/" + user.ID.Hex(), as: &admin, body: `{"first_name": "First"}`, status: http.StatusNoContent},
+		{name: "by a user", method: "PATCH", path: "/users/" + user.ID.Hex(), as: &user, body: `{"first_name": "First"}`, status: http.StatusForbidden, code: "forbidden"},
+		{name: "anonymously", method: "PATCH", path: "/users/" + user.ID.Hex(), body: `{"first_name": "First"}`, status: http.StatusUnauthorized, code: "unauthorized"},
+		{name: "missing user", method: "PATCH", path: "/users/" + primitive.NewObjectID().Hex(), as: &admin, body: `{}`, status: http.StatusNotFound, code: "not_found"},
+		{name: "taken email", method: "PATCH", path: "/users/" + user.ID.Hex(), as: &admin, body: `{"email": "admin@example.com"}`, status: http.StatusConflict, code: "conflict"},
+		{name: "own taken email", method: "PATCH", path: "/me", as: &user, body: `{"email": "admin@example.com"}`, status: http.StatusConflict, code: "conflict"},
+		{name: "own email", method: "PATCH", path: "/me", as: &user, body: `{"email": "new@example.com"}`, status: http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, code := s.request(test.method, test.path, test.as, test.body)
+			if status != test.status || code != test.code {
+				t.Errorf("got %d %q, expected %d %q", status, code, test.status, test.code)
+			}
+		})
+	}
+
+	u, _ := s.store.Users().Get(context.Background(), user.ID)
+	if u.FirstName != "First" || u.Email != "new@example.com" {
+		t.Errorf("got %s %s, expected the admin's and user's updates", u.FirstName, u.Email)
+	}
+}
+
+func TestMeUpdateRoles(t *testing.T) {
+	// without a schema to turn them away, fields users can't change of
+	// themselves must still be left alone
+	s := newTestServer(t, memory.New())
+	user := s.addUser(t, "user@example.com", model.RoleUser)
+
+	status, _ := s.request("PATCH", "/me", &user, `{"first_name": "First", "roles": ["ROLE_ADMIN"], "groups": ["admins"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("got %d, expected %d", status, http.StatusNoContent)
+	}
+
+	u, _ := s.store.Users().Get(context.Background(), user.ID)
+	if u.FirstName != "First" {
+		t.Errorf("got first name %q, expected First", u.FirstName)
+	}
+	if u.HasRole("ROLE_ADMIN") || len(u.Groups) != 0 {
+		t.Errorf("got roles %v and groups %v, expected them unchanged", u.Roles, u.Groups)
+	}
+}
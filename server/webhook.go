@@ -0,0 +1,249 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/middleware"
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/response"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/tools"
+	"github.com/2-IMMERSE/auth-service/webhook"
+
+	"github.com/labstack/echo"
+)
+
+type WebhookServer struct {
+}
+
+// newWebhook is a webhook with its secret, which can only be chosen when it
+// is created and is never shown after
+type newWebhook struct {
+	model.Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+func MountWebhookServer(prefix string, e *echo.Echo, v *tools.Validator) *WebhookServer {
+	s := &WebhookServer{}
+
+	g := e.Group(prefix, middleware.Auth(), middleware.Admin())
+
+	g.GET("", s.index)
+	g.POST("", s.create)
+	g.GET("/:id", s.show)
+	g.PATCH("/:id", s.update)
+	g.DELETE("/:id", s.delete)
+
+	// delivery history
+	g.GET("/:id/deliveries", s.deliveries)
+	g.POST("/:id/deliveries/:delivery/redeliver", s.redeliver)
+
+	return s
+}
+
+func (s *WebhookServer) index(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	pagination := tools.NewPagination("webhooks", c)
+
+	hooks, count, err := store.Webhooks().List(c.Request().Context(), pagination.Page)
+	if err != nil {
+		return response.Internal(err)
+	}
+
+	pagination.AddHeaders(c.Response().Header(), count)
+
+	return c.JSON(http.StatusOK, hooks)
+}
+
+func (s *WebhookServer) create(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+
+	n := newWebhook{
+		Webhook: model.Webhook{
+			Active: true,
+		},
+	}
+	if err := c.Bind(&n); err != nil {
+		return response.MalformedBody(err)
+	}
+
+	h := n.Webhook
+	if err := validateWebhook(h); err != nil {
+		return err
+	}
+
+	h.ID = primitive.NewObjectID()
+	h.CreatedAt = time.Now()
+	h.Secret = n.Secret
+	if len(h.Secret) == 0 {
+		if err := h.GenerateSecret(); err != nil {
+			return response.Internal(err)
+		}
+	}
+
+	if err := store.Webhooks().Create(c.Request().Context(), h); err != nil {
+		return response.Internal(err)
+	}
+
+	return c.JSON(http.StatusCreated, newWebhook{
+		Webhook: h,
+		Secret:  h.Secret,
+	})
+}
+
+func (s *WebhookServer) show(c echo.Context) error {
+	h, err := findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, h)
+}
+
+func (s *WebhookServer) update(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+
+	h, err := findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	id, created := h.ID, h.CreatedAt
+	if err := c.Bind(&h); err != nil {
+		return response.MalformedBody(err)
+	}
+	h.ID, h.CreatedAt = id, created
+
+	if err := validateWebhook(h); err != nil {
+		return err
+	}
+
+	if err := store.Webhooks().Replace(c.Request().Context(), h); err != nil {
+		return storageError(err, "Webhook")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *WebhookServer) delete(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	h, err := findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Webhooks().Delete(ctx, h.ID); err != nil {
+		return storageError(err, "Webhook")
+	}
+
+	// pending deliveries have nowhere to go now
+	if err := store.Deliveries().DeletePending(ctx, h.ID); err != nil {
+		middleware.Log(c).Errorf("Webhook: unable to delete pending deliveries of %s: %v", h.ID.Hex(), err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *WebhookServer) deliveries(c echo.Context) error {
+	h, err := findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	store := c.Get("storage").(storage.Store)
+	pagination := tools.NewPagination("webhook_deliveries", c)
+
+	deliveries, count, err := store.Deliveries().List(c.Request().Context(), h.ID, pagination.Page)
+	if err != nil {
+		return response.Internal(err)
+	}
+
+	pagination.AddHeaders(c.Response().Header(), count)
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+func (s *WebhookServer) redeliver(c echo.Context) error {
+	store := c.Get("storage").(storage.Store)
+	ctx := c.Request().Context()
+
+	h, err := findWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("delivery"))
+	if err != nil {
+		return response.NotFound("Delivery")
+	}
+
+	delivery, err := store.Deliveries().Get(ctx, h.ID, deliveryID)
+	if err != nil {
+		return storageError(err, "Delivery")
+	}
+
+	d, err := webhook.Redeliver(ctx, store, delivery)
+	if err != nil {
+		return response.Internal(err)
+	}
+
+	return c.JSON(http.StatusAccepted, response.Resource{
+		ID: d.ID.Hex(),
+	})
+}
+
+func findWebhook(c echo.Context) (model.Webhook, error) {
+	store := c.Get("storage").(storage.Store)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return model.Webhook{}, response.NotFound("Webhook")
+	}
+
+	h, err := store.Webhooks().Get(c.Request().Context(), id)
+	if err != nil {
+		return h, storageError(err, "Webhook")
+	}
+
+	return h, nil
+}
+
+func validateWebhook(h model.Webhook) error {
+	fields := map[string]string{}
+
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		fields["url"] = "must be an absolute http or https url"
+	}
+
+	for _, e := range h.Events {
+		if !webhook.IsEvent(e) {
+			fields["events"] = "unknown event " + e
+		}
+	}
+
+	if len(fields) > 0 {
+		return response.Invalid("Invalid webhook", fields)
+	}
+
+	return nil
+}
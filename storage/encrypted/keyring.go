@@ -0,0 +1,224 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encrypted stores sensitive fields with envelope encryption. Each
+// value is sealed with its own data key, which is itself sealed with a
+// master key from the configuration, so rotating the master key only means
+// sealing the data keys again.
+package encrypted
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// prefix marks sealed values, anything else was stored before encryption
+// was enabled and is read as it is
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned for values sealed with a master key that isn't
+// configured
+var ErrUnknownKey = errors.New("sealed with an unknown master key")
+
+// Keyring holds the master key values are sealed with and the previous ones
+// they can still be opened with
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring takes base64 encoded 32 byte master keys
+func NewKeyring(current string, previous []string) (*Keyring, error) {
+	k := &Keyring{
+		keys: map[string]cipher.AEAD{},
+	}
+
+	for i, key := range append([]string{current}, previous...) {
+		id, aead, err := masterKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			k.current = id
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// masterKey decodes key, returning a short id for it along with its cipher
+func masterKey(key string) (string, cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("master key: %v", err)
+	}
+
+	if len(raw) != 32 {
+		return "", nil, fmt.Errorf("master key: must be 32 bytes, not %d", len(raw))
+	}
+
+	aead, err := newGCM(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:4]), aead, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain with aead, prefixing the random nonce
+func seal(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open reverses seal
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+// Sealed reports whether value was sealed by a keyring
+func Sealed(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(prefix))
+}
+
+// Seal encrypts plain with a new data key sealed with the current master key
+func (k *Keyring) Seal(plain []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedData, err := seal(data, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.envelope(k.current, dataKey, sealedData)
+}
+
+// envelope seals dataKey with the master key id and formats the result as
+// enc:v1:<master key id>:<sealed data key>:<sealed data>
+func (k *Keyring) envelope(id string, dataKey, sealedData []byte) ([]byte, error) {
+	sealedKey, err := seal(k.keys[id], dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(prefix + id + ":" +
+		base64.StdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.StdEncoding.EncodeToString(sealedData)), nil
+}
+
+// unwrap parses a sealed value, returning its data key and sealed data
+func (k *Keyring) unwrap(value []byte) (string, []byte, []byte, error) {
+	parts := bytes.Split(bytes.TrimPrefix(value, []byte(prefix)), []byte(":"))
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed sealed value")
+	}
+
+	id := string(parts[0])
+	master, ok := k.keys[id]
+	if !ok {
+		return "", nil, nil, ErrUnknownKey
+	}
+
+	sealedKey, err := base64.StdEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	sealedData, err := base64.StdEncoding.DecodeString(string(parts[2]))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	dataKey, err := open(master, sealedKey)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return id, dataKey, sealedData, nil
+}
+
+// Open decrypts a value sealed with any of the keyring's master keys. Values
+// that were never sealed are returned unchanged.
+func (k *Keyring) Open(value []byte) ([]byte, error) {
+	if !Sealed(value) {
+		return value, nil
+	}
+
+	_, dataKey, sealedData, err := k.unwrap(value)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(data, sealedData)
+}
+
+// Rotate returns value sealed under the current master key, sealing it for
+// the first time if needed. Only the data key is sealed again for values
+// already sealed. The bool is false when value was already current.
+func (k *Keyring) Rotate(value []byte) ([]byte, bool, error) {
+	if !Sealed(value) {
+		sealed, err := k.Seal(value)
+		return sealed, err == nil, err
+	}
+
+	id, dataKey, sealedData, err := k.unwrap(value)
+	if err != nil || id == k.current {
+		return value, false, err
+	}
+
+	sealed, err := k.envelope(k.current, dataKey, sealedData)
+	return sealed, err == nil, err
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package encrypted
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newKeyring(t *testing.T, current string, previous ...string) *Keyring {
+	k, err := NewKeyring(current, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "not base64", key: "not a key!"},
+		{name: "too short", key: base64.StdEncoding.EncodeToString(make([]byte, 16))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewKeyring(test.key, nil); err == nil {
+				t.Error("accepted the key")
+			}
+			if _, err := NewKeyring(newKey(t), []string{test.key}); err == nil {
+				t.Error("accepted the key as a previous one")
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k := newKeyring(t, newKey(t))
+	plain := []byte("secret")
+
+	sealed, err := k.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Sealed(sealed) || bytes.Contains(sealed, plain) {
+		t.Errorf("got %s, expected it sealed", sealed)
+	}
+
+	// every value has a data key and nonce of its own
+	again, _ := k.Seal(plain)
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing the same value twice gave the same result")
+	}
+
+	opened, err := k.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Errorf("got %q, expected %q", opened, plain)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	old, current := newKey(t), newKey(t)
+
+	sealedWithOld, err := newKeyring(t, old).Seal([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newKeyring(t, current, old)
+	tampered := append([]byte(nil), sealedWithOld...)
+	tampered[len(tampered)-2] ^= 1
+
+	tests := []struct {
+		name  string
+		k     *Keyring
+		value []byte
+
+		expected string
+		err      error
+	}{
+		{name: "previous master key", k: k, value: sealedWithOld, expected: "old"},
+		{name: "plaintext stored before encryption", k: k, value: []byte("plain"), expected: "plain"},
+		{name: "unknown master key", k: newKeyring(t, current), value: sealedWithOld, err: ErrUnknownKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := test.k.Open(test.value)
+			if err != test.err || string(opened) != test.expected {
+				t.Errorf("got %q, %v, expected %q, %v", opened, err, test.expected, test.err)
+			}
+		})
+	}
+
+	for name, value := range map[string][]byte{"tampered": tampered, "malformed": []byte(prefix + "value")} {
+		if _, err := k.Open(value); err == nil {
+			t.Errorf("opened a %s value", name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old, current := newKey(t), newKey(t)
+
+	sealed, err := newKeyring(t, old).Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newKeyring(t, current, old)
+	rotated, changed, err := k.Rotate(sealed)
+	if err != nil || !changed {
+		t.Fatalf("got %t, %v rotating, expected it changed", changed, err)
+	}
+
+	// only the data key is sealed again, the data stays as it was
+	oldParts, newParts := strings.Split(string(sealed), ":"), strings.Split(string(rotated), ":")
+	if oldParts[2] == newParts[2] || oldParts[3] == newParts[3] || oldParts[4] != newParts[4] {
+		t.Errorf("got %s from %s, expected only the master key id and data key to change", rotated, sealed)
+	}
+
+	// readable without the old master key
+	if opened, err := newKeyring(t, current).Open(rotated); err != nil || string(opened) != "secret" {
+		t.Errorf("got %q, %v opening with the current key only", opened, err)
+	}
+
+	if again, changed, err := k.Rotate(rotated); err != nil || changed || !bytes.Equal(again, rotated) {
+		t.Errorf("got %t, %v rotating a current value, expected it unchanged", changed, err)
+	}
+
+	// plaintext is sealed for the first time
+	first, changed, err := k.Rotate([]byte("plain"))
+	if err != nil || !changed || !Sealed(first) {
+		t.Errorf("got %s, %t, %v rotating plaintext, expected it sealed", first, changed, err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypted
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Store seals key data and client secrets on their way into the wrapped
+// store and opens them on the way out. Other repositories pass straight
+// through.
+type Store struct {
+	storage.Store
+	keyring *Keyring
+}
+
+// New wraps s so sensitive fields are sealed with k
+func New(s storage.Store, k *Keyring) *Store {
+	return &Store{s, k}
+}
+
+func (s *Store) Keys() storage.KeyRepository {
+	return &keys{s.Store.Keys(), s.keyring}
+}
+
+func (s *Store) Clients() storage.ClientRepository {
+	return &clients{s.Store.Clients(), s.keyring}
+}
+
+// Migrate migrates the wrapped store, if it has migrations
+func (s *Store) Migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	if m, ok := s.Store.(storage.Migrator); ok {
+		return m.Migrate(ctx, dryRun)
+	}
+
+	return nil, nil
+}
+
+type keys struct {
+	storage.KeyRepository
+	keyring *Keyring
+}
+
+func (r *keys) open(k *model.Key) (err error) {
+	k.Data, err = r.keyring.Open(k.Data)
+	return err
+}
+
+func (r *keys) seal(k *model.Key) (err error) {
+	k.Data, err = r.keyring.Seal(k.Data)
+	return err
+}
+
+func (r *keys) List(ctx context.Context) ([]model.Key, error) {
+	result, err := r.KeyRepository.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		if err := r.open(&result[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (r *keys) Get(ctx context.Context, id primitive.ObjectID) (model.Key, error) {
+	k, err := r.KeyRepository.Get(ctx, id)
+	if err != nil {
+		return k, err
+	}
+
+	return k, r.open(&k)
+}
+
+func (r *keys) Create(ctx context.Context, k model.Key) error {
+	if err := r.seal(&k); err != nil {
+		return err
+	}
+
+	return r.KeyRepository.Create(ctx, k)
+}
+
+func (r *keys) Upsert(ctx context.Context, k model.Key) error {
+	if err := r.seal(&k); err != nil {
+		return err
+	}
+
+	return r.KeyRepository.Upsert(ctx, k)
+}
+
+type clients struct {
+	storage.ClientRepository
+	keyring *Keyring
+}
+
+func (r *clients) open(c *model.Client) error {
+	secret, err := r.keyring.Open([]byte(c.ClientSecret))
+	c.ClientSecret = string(secret)
+	return err
+}
+
+func (r *clients) seal(c *model.Client) error {
+	if len(c.ClientSecret) == 0 {
+		return nil
+	}
+
+	secret, err := r.keyring.Seal([]byte(c.ClientSecret))
+	c.ClientSecret = string(secret)
+	return err
+}
+
+func (r *clients) List(ctx context.Context, page storage.Page) ([]model.Client, int, error) {
+	result, count, err := r.ClientRepository.List(ctx, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range result {
+		if err := r.open(&result[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return result, count, nil
+}
+
+func (r *clients) Get(ctx context.Context, id primitive.ObjectID) (model.Client, error) {
+	c, err := r.ClientRepository.Get(ctx, id)
+	if err != nil {
+		return c, err
+	}
+
+	return c, r.open(&c)
+}
+
+func (r *clients) Create(ctx context.Context, c model.Client) error {
+	if err := r.seal(&c); err != nil {
+		return err
+	}
+
+	return r.ClientRepository.Create(ctx, c)
+}
+
+func (r *clients) Replace(ctx context.Context, c model.Client) error {
+	if err := r.seal(&c); err != nil {
+		return err
+	}
+
+	return r.ClientRepository.Replace(ctx, c)
+}
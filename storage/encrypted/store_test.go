@@ -0,0 +1,108 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package encrypted
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+)
+
+func TestStoreKeys(t *testing.T) {
+	underlying := memory.New()
+	s := New(underlying, newKeyring(t, newKey(t)))
+	ctx := context.Background()
+
+	created := model.Key{ID: primitive.NewObjectID(), Title: "created", Data: []byte("created data")}
+	upserted := model.Key{ID: primitive.NewObjectID(), Title: "upserted", Data: []byte("upserted data")}
+	plain := model.Key{ID: primitive.NewObjectID(), Title: "plain", Data: []byte("plain data")}
+
+	if err := s.Keys().Create(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Keys().Upsert(ctx, upserted); err != nil {
+		t.Fatal(err)
+	}
+	// stored before encryption was enabled
+	if err := underlying.Keys().Create(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []model.Key{created, upserted} {
+		stored, _ := underlying.Keys().Get(ctx, k.ID)
+		if !Sealed(stored.Data) {
+			t.Errorf("key %s stored as %q, expected it sealed", k.Title, stored.Data)
+		}
+	}
+
+	expected := map[primitive.ObjectID]string{}
+	for _, k := range []model.Key{created, upserted, plain} {
+		expected[k.ID] = string(k.Data)
+
+		got, err := s.Keys().Get(ctx, k.ID)
+		if err != nil || string(got.Data) != string(k.Data) {
+			t.Errorf("got %q, %v for key %s, expected %q", got.Data, err, k.Title, k.Data)
+		}
+	}
+
+	listed, err := s.Keys().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != len(expected) {
+		t.Fatalf("listed %d keys, expected %d", len(listed), len(expected))
+	}
+	for _, k := range listed {
+		if string(k.Data) != expected[k.ID] {
+			t.Errorf("listed %q for key %s, expected %q", k.Data, k.Title, expected[k.ID])
+		}
+	}
+}
+
+func TestStoreClients(t *testing.T) {
+	underlying := memory.New()
+	s := New(underlying, newKeyring(t, newKey(t)))
+	ctx := context.Background()
+
+	c := model.Client{ID: primitive.NewObjectID(), Name: "client", ClientSecret: "secret"}
+	if err := s.Clients().Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, _ := underlying.Clients().Get(ctx, c.ID); !Sealed([]byte(stored.ClientSecret)) {
+		t.Errorf("secret stored as %q, expected it sealed", stored.ClientSecret)
+	}
+
+	if got, err := s.Clients().Get(ctx, c.ID); err != nil || got.ClientSecret != "secret" {
+		t.Errorf("got %q, %v, expected the secret opened", got.ClientSecret, err)
+	}
+
+	c.ClientSecret = "replaced"
+	if err := s.Clients().Replace(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	listed, _, err := s.Clients().List(ctx, storage.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ClientSecret != "replaced" {
+		t.Errorf("listed %+v, expected the replaced secret opened", listed)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type devices struct {
+	s *Store
+}
+
+func (r *devices) List(ctx context.Context, owner primitive.ObjectID, page storage.Page) ([]model.Device, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []string{}
+	for id, d := range r.s.devices {
+		if owner.IsZero() || d.Owner == owner {
+			keys = append(keys, id)
+		}
+	}
+	keys = names(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.Device, end-start)
+	for i, id := range keys[start:end] {
+		copyDoc(r.s.devices[id], &result[i])
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *devices) Get(ctx context.Context, id string) (model.Device, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	d, ok := r.s.devices[id]
+	if !ok {
+		return d, storage.ErrNotFound
+	}
+
+	return d, nil
+}
+
+func (r *devices) GetByCode(ctx context.Context, code string) (model.Device, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, d := range r.s.devices {
+		if d.Code == code {
+			return d, nil
+		}
+	}
+
+	return model.Device{}, storage.ErrNotFound
+}
+
+func (r *devices) CountUnlinked(ctx context.Context) (int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	count := 0
+	for _, d := range r.s.devices {
+		if d.Owner.IsZero() {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *devices) Create(ctx context.Context, d model.Device) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.devices[d.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.s.devices[d.ID] = d
+
+	return nil
+}
+
+func (r *devices) Update(ctx context.Context, d model.Device) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.devices[d.ID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	r.s.devices[d.ID] = d
+
+	return nil
+}
+
+func (r *devices) Delete(ctx context.Context, id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.devices[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.devices, id)
+
+	return nil
+}
+
+func (r *devices) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	count := 0
+	for id, d := range r.s.devices {
+		if d.Owner.IsZero() && !d.CodeExpires.IsZero() && !d.CodeExpires.After(now) {
+			delete(r.s.devices, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
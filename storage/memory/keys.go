@@ -0,0 +1,275 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type keys struct {
+	s *Store
+}
+
+func (r *keys) List(ctx context.Context) ([]model.Key, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	ordered := []primitive.ObjectID{}
+	for id := range r.s.keys {
+		ordered = append(ordered, id)
+	}
+
+	result := []model.Key{}
+	for _, id := range ids(ordered, "") {
+		k := model.Key{}
+		copyDoc(r.s.keys[id], &k)
+		result = append(result, k)
+	}
+
+	return result, nil
+}
+
+func (r *keys) Get(ctx context.Context, id primitive.ObjectID) (model.Key, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	k := model.Key{}
+	existing, ok := r.s.keys[id]
+	if !ok {
+		return k, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &k)
+
+	return k, nil
+}
+
+func (r *keys) Create(ctx context.Context, k model.Key) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if k.ID.IsZero() {
+		k.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.keys[k.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.Key{}
+	copyDoc(k, &stored)
+	r.s.keys[k.ID] = stored
+
+	return nil
+}
+
+func (r *keys) Upsert(ctx context.Context, k model.Key) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored := model.Key{}
+	copyDoc(k, &stored)
+	r.s.keys[k.ID] = stored
+
+	return nil
+}
+
+type clients struct {
+	s *Store
+}
+
+func (r *clients) List(ctx context.Context, page storage.Page) ([]model.Client, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []primitive.ObjectID{}
+	for id := range r.s.clients {
+		keys = append(keys, id)
+	}
+	keys = ids(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.Client, end-start)
+	for i, id := range keys[start:end] {
+		result[i] = r.s.clients[id]
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *clients) Get(ctx context.Context, id primitive.ObjectID) (model.Client, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	c, ok := r.s.clients[id]
+	if !ok {
+		return c, storage.ErrNotFound
+	}
+
+	return c, nil
+}
+
+func (r *clients) Create(ctx context.Context, c model.Client) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.clients[c.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.s.clients[c.ID] = c
+
+	return nil
+}
+
+func (r *clients) Replace(ctx context.Context, c model.Client) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.clients[c.ID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	r.s.clients[c.ID] = c
+
+	return nil
+}
+
+func (r *clients) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.clients[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.clients, id)
+
+	return nil
+}
+
+type policies struct {
+	s *Store
+}
+
+func (r *policies) List(ctx context.Context, page storage.Page) ([]model.Policy, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []primitive.ObjectID{}
+	for id := range r.s.policies {
+		keys = append(keys, id)
+	}
+	keys = ids(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.Policy, end-start)
+	for i, id := range keys[start:end] {
+		copyDoc(r.s.policies[id], &result[i])
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *policies) All(ctx context.Context) ([]model.Policy, error) {
+	result, _, err := r.List(ctx, storage.Page{})
+	return result, err
+}
+
+func (r *policies) Get(ctx context.Context, id primitive.ObjectID) (model.Policy, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	p := model.Policy{}
+	existing, ok := r.s.policies[id]
+	if !ok {
+		return p, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &p)
+
+	return p, nil
+}
+
+func (r *policies) Create(ctx context.Context, p model.Policy) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if p.ID.IsZero() {
+		p.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.policies[p.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.Policy{}
+	copyDoc(p, &stored)
+	r.s.policies[p.ID] = stored
+
+	return nil
+}
+
+func (r *policies) Replace(ctx context.Context, p model.Policy) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.policies[p.ID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	stored := model.Policy{}
+	copyDoc(p, &stored)
+	r.s.policies[p.ID] = stored
+
+	return nil
+}
+
+func (r *policies) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.policies[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.policies, id)
+
+	return nil
+}
+
+type audit struct {
+	s *Store
+}
+
+func (r *audit) Record(ctx context.Context, e model.AuditEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored := model.AuditEvent{}
+	copyDoc(e, &stored)
+	r.s.audit = append(r.s.audit, stored)
+
+	return nil
+}
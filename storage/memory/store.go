@@ -0,0 +1,148 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory keeps everything in process memory. Nothing survives a
+// restart so it is only suited to local development, demos and tests.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Store implements storage.Store with maps guarded by a single lock.
+// Documents are copied in and out through bson so callers never share
+// state with the store and fields behave as they would in MongoDB.
+type Store struct {
+	mu sync.RWMutex
+
+	users      map[primitive.ObjectID]model.User
+	tokens     map[primitive.ObjectID]model.Token
+	devices    map[string]model.Device
+	keys       map[primitive.ObjectID]model.Key
+	clients    map[primitive.ObjectID]model.Client
+	policies   map[primitive.ObjectID]model.Policy
+	webhooks   map[primitive.ObjectID]model.Webhook
+	deliveries map[primitive.ObjectID]model.WebhookDelivery
+	audit      []model.AuditEvent
+}
+
+// New creates an empty store
+func New() *Store {
+	return &Store{
+		users:      make(map[primitive.ObjectID]model.User),
+		tokens:     make(map[primitive.ObjectID]model.Token),
+		devices:    make(map[string]model.Device),
+		keys:       make(map[primitive.ObjectID]model.Key),
+		clients:    make(map[primitive.ObjectID]model.Client),
+		policies:   make(map[primitive.ObjectID]model.Policy),
+		webhooks:   make(map[primitive.ObjectID]model.Webhook),
+		deliveries: make(map[primitive.ObjectID]model.WebhookDelivery),
+	}
+}
+
+func (s *Store) Users() storage.UserRepository          { return &users{s} }
+func (s *Store) Tokens() storage.TokenRepository        { return &tokens{s} }
+func (s *Store) Devices() storage.DeviceRepository      { return &devices{s} }
+func (s *Store) Keys() storage.KeyRepository            { return &keys{s} }
+func (s *Store) Clients() storage.ClientRepository      { return &clients{s} }
+func (s *Store) Policies() storage.PolicyRepository     { return &policies{s} }
+func (s *Store) Webhooks() storage.WebhookRepository    { return &webhooks{s} }
+func (s *Store) Deliveries() storage.DeliveryRepository { return &deliveries{s} }
+func (s *Store) Audit() storage.AuditRepository         { return &audit{s} }
+
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) Close() error {
+	return nil
+}
+
+// AuditEvents returns everything recorded in the audit trail
+func (s *Store) AuditEvents() []model.AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]model.AuditEvent, len(s.audit))
+	for i, e := range s.audit {
+		copyDoc(e, &events[i])
+	}
+
+	return events
+}
+
+// copyDoc deep copies in to out by way of bson
+func copyDoc(in, out interface{}) {
+	data, err := bson.Marshal(in)
+	if err != nil {
+		panic(err)
+	}
+
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	dec.SetRegistry(storage.Registry)
+	if err := dec.Decode(out); err != nil {
+		panic(err)
+	}
+}
+
+// ids sorts the ids of a listing. Only the id can be sorted on, any other
+// field falls back to it.
+func ids(keys []primitive.ObjectID, order string) []primitive.ObjectID {
+	desc := strings.HasPrefix(order, "-")
+	sort.Slice(keys, func(i, j int) bool {
+		return (bytes.Compare(keys[i][:], keys[j][:]) < 0) != desc
+	})
+
+	return keys
+}
+
+// names sorts string ids the same way as ids
+func names(keys []string, order string) []string {
+	desc := strings.HasPrefix(order, "-")
+	sort.Slice(keys, func(i, j int) bool {
+		return (keys[i] < keys[j]) != desc
+	})
+
+	return keys
+}
+
+// window returns the bounds of the page within n results
+func window(n int, page storage.Page) (int, int) {
+	start := page.Offset
+	if start > n {
+		start = n
+	}
+
+	end := n
+	if page.Limit > 0 && start+page.Limit < n {
+		end = start + page.Limit
+	}
+
+	return start, end
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type tokens struct {
+	s *Store
+}
+
+// filter copies out every token matching fn
+func (r *tokens) filter(fn func(model.Token) bool) []model.Token {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := []model.Token{}
+	for _, t := range r.s.tokens {
+		if fn(t) {
+			out := model.Token{}
+			copyDoc(t, &out)
+			result = append(result, out)
+		}
+	}
+
+	return result
+}
+
+func (r *tokens) find(token string) (model.Token, bool) {
+	for _, t := range r.s.tokens {
+		if t.Token == token {
+			return t, true
+		}
+	}
+
+	return model.Token{}, false
+}
+
+func (r *tokens) All(ctx context.Context) ([]model.Token, error) {
+	return r.filter(func(model.Token) bool { return true }), nil
+}
+
+func (r *tokens) Get(ctx context.Context, token string) (model.Token, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	t := model.Token{}
+	existing, ok := r.find(token)
+	if !ok {
+		return t, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &t)
+
+	return t, nil
+}
+
+func (r *tokens) Latest(ctx context.Context, user primitive.ObjectID) (model.Token, error) {
+	result := r.filter(func(t model.Token) bool { return t.User == user })
+	if len(result) == 0 {
+		return model.Token{}, storage.ErrNotFound
+	}
+
+	latest := result[0]
+	for _, t := range result[1:] {
+		if t.ExpiresAt.After(latest.ExpiresAt) {
+			latest = t
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *tokens) ListByUser(ctx context.Context, user primitive.ObjectID) ([]model.Token, error) {
+	return r.filter(func(t model.Token) bool { return t.User == user }), nil
+}
+
+func (r *tokens) Pending(ctx context.Context) ([]model.Token, error) {
+	return r.filter(func(t model.Token) bool { return t.GatewayPending }), nil
+}
+
+func (r *tokens) CountActive(ctx context.Context, now time.Time) (int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	count := 0
+	for _, t := range r.s.tokens {
+		if t.ExpiresAt.After(now) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *tokens) Create(ctx context.Context, t model.Token) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.tokens[t.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.Token{}
+	copyDoc(t, &stored)
+	r.s.tokens[t.ID] = stored
+
+	return nil
+}
+
+func (r *tokens) ClearPending(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.s.tokens[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	t.GatewayPending = false
+	r.s.tokens[id] = t
+
+	return nil
+}
+
+func (r *tokens) SetQuotaExceeded(ctx context.Context, token string, at time.Time) (model.Token, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.find(token)
+	if !ok {
+		return t, storage.ErrNotFound
+	}
+
+	t.QuotaExceededAt = at
+	r.s.tokens[t.ID] = t
+
+	return t, nil
+}
+
+func (r *tokens) Remove(ctx context.Context, token string) (model.Token, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.find(token)
+	if !ok {
+		return t, storage.ErrNotFound
+	}
+
+	delete(r.s.tokens, t.ID)
+
+	return t, nil
+}
+
+func (r *tokens) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.tokens[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.tokens, id)
+
+	return nil
+}
+
+func (r *tokens) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	count := 0
+	for id, t := range r.s.tokens {
+		if !t.ExpiresAt.IsZero() && !t.ExpiresAt.After(now) {
+			delete(r.s.tokens, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type users struct {
+	s *Store
+}
+
+func (r *users) List(ctx context.Context, page storage.Page) ([]model.User, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []primitive.ObjectID{}
+	for id := range r.s.users {
+		keys = append(keys, id)
+	}
+	keys = ids(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.User, end-start)
+	for i, id := range keys[start:end] {
+		copyDoc(r.s.users[id], &result[i])
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *users) Get(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	u := model.User{}
+	existing, ok := r.s.users[id]
+	if !ok {
+		return u, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &u)
+
+	return u, nil
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	u := model.User{}
+	existing, ok := r.findByEmail(email)
+	if !ok {
+		return u, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &u)
+
+	return u, nil
+}
+
+func (r *users) findByEmail(email string) (model.User, bool) {
+	for _, u := range r.s.users {
+		if u.Email == email {
+			return u, true
+		}
+	}
+
+	return model.User{}, false
+}
+
+func (r *users) Create(ctx context.Context, u model.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.users[u.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	// emails are unique, as they are with the other backends
+	if _, ok := r.findByEmail(u.Email); ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.User{}
+	copyDoc(u, &stored)
+	r.s.users[u.ID] = stored
+
+	return nil
+}
+
+func (r *users) Update(ctx context.Context, id primitive.ObjectID, update model.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	existing, ok := r.s.users[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	if other, ok := r.findByEmail(update.Email); ok && len(update.Email) > 0 && other.ID != id {
+		return storage.ErrDuplicate
+	}
+
+	// the same as $set with the update, fields omitted when empty are kept
+	doc, fields := bson.M{}, bson.M{}
+	copyDoc(existing, &doc)
+	copyDoc(update, &fields)
+	for k, v := range fields {
+		doc[k] = v
+	}
+
+	u := model.User{}
+	copyDoc(doc, &u)
+	r.s.users[id] = u
+
+	return nil
+}
+
+func (r *users) SetProfile(ctx context.Context, id primitive.ObjectID, profile *model.Profile) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.s.users[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	u.Profile = nil
+	if profile != nil {
+		u.Profile = &model.Profile{}
+		copyDoc(profile, u.Profile)
+	}
+	r.s.users[id] = u
+
+	return nil
+}
+
+func (r *users) SetGatewayID(ctx context.Context, id primitive.ObjectID, gatewayID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.s.users[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	u.GatewayID = gatewayID
+	r.s.users[id] = u
+
+	return nil
+}
+
+func (r *users) UpsertByEmail(ctx context.Context, u model.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if existing, ok := r.findByEmail(u.Email); ok {
+		delete(r.s.users, existing.ID)
+		if u.ID.IsZero() {
+			u.ID = existing.ID
+		}
+	}
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+
+	stored := model.User{}
+	copyDoc(u, &stored)
+	r.s.users[u.ID] = stored
+
+	return nil
+}
+
+func (r *users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.users, id)
+
+	return nil
+}
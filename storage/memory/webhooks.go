@@ -0,0 +1,254 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type webhooks struct {
+	s *Store
+}
+
+func (r *webhooks) List(ctx context.Context, page storage.Page) ([]model.Webhook, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []primitive.ObjectID{}
+	for id := range r.s.webhooks {
+		keys = append(keys, id)
+	}
+	keys = ids(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.Webhook, end-start)
+	for i, id := range keys[start:end] {
+		copyDoc(r.s.webhooks[id], &result[i])
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *webhooks) Active(ctx context.Context) ([]model.Webhook, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := []model.Webhook{}
+	for _, h := range r.s.webhooks {
+		if h.Active {
+			out := model.Webhook{}
+			copyDoc(h, &out)
+			result = append(result, out)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *webhooks) Get(ctx context.Context, id primitive.ObjectID) (model.Webhook, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	h := model.Webhook{}
+	existing, ok := r.s.webhooks[id]
+	if !ok {
+		return h, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &h)
+
+	return h, nil
+}
+
+func (r *webhooks) Create(ctx context.Context, h model.Webhook) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if h.ID.IsZero() {
+		h.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.webhooks[h.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.Webhook{}
+	copyDoc(h, &stored)
+	r.s.webhooks[h.ID] = stored
+
+	return nil
+}
+
+func (r *webhooks) Replace(ctx context.Context, h model.Webhook) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.webhooks[h.ID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	stored := model.Webhook{}
+	copyDoc(h, &stored)
+	r.s.webhooks[h.ID] = stored
+
+	return nil
+}
+
+func (r *webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.webhooks[id]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(r.s.webhooks, id)
+
+	return nil
+}
+
+type deliveries struct {
+	s *Store
+}
+
+func (r *deliveries) List(ctx context.Context, webhook primitive.ObjectID, page storage.Page) ([]model.WebhookDelivery, int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := []primitive.ObjectID{}
+	for id, d := range r.s.deliveries {
+		if d.Webhook == webhook {
+			keys = append(keys, id)
+		}
+	}
+	keys = ids(keys, page.Sort)
+
+	start, end := window(len(keys), page)
+	result := make([]model.WebhookDelivery, end-start)
+	for i, id := range keys[start:end] {
+		copyDoc(r.s.deliveries[id], &result[i])
+	}
+
+	return result, len(keys), nil
+}
+
+func (r *deliveries) Get(ctx context.Context, webhook, id primitive.ObjectID) (model.WebhookDelivery, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	d := model.WebhookDelivery{}
+	existing, ok := r.s.deliveries[id]
+	if !ok || existing.Webhook != webhook {
+		return d, storage.ErrNotFound
+	}
+
+	copyDoc(existing, &d)
+
+	return d, nil
+}
+
+func (r *deliveries) Due(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	result := []model.WebhookDelivery{}
+	for _, d := range r.s.deliveries {
+		if d.Status == model.WebhookDeliveryPending && !d.NextAttempt.After(now) {
+			out := model.WebhookDelivery{}
+			copyDoc(d, &out)
+			result = append(result, out)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NextAttempt.Before(result[j].NextAttempt)
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (r *deliveries) Claim(ctx context.Context, d model.WebhookDelivery, until time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	existing, ok := r.s.deliveries[d.ID]
+	if !ok || existing.Status != model.WebhookDeliveryPending || !existing.NextAttempt.Equal(d.NextAttempt) {
+		return storage.ErrNotFound
+	}
+
+	// times are kept to the millisecond like every other stored time
+	existing.NextAttempt = until.Truncate(time.Millisecond)
+	r.s.deliveries[d.ID] = existing
+
+	return nil
+}
+
+func (r *deliveries) Create(ctx context.Context, d model.WebhookDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if d.ID.IsZero() {
+		d.ID = primitive.NewObjectID()
+	}
+
+	if _, ok := r.s.deliveries[d.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	stored := model.WebhookDelivery{}
+	copyDoc(d, &stored)
+	r.s.deliveries[d.ID] = stored
+
+	return nil
+}
+
+func (r *deliveries) Save(ctx context.Context, d model.WebhookDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.deliveries[d.ID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	stored := model.WebhookDelivery{}
+	copyDoc(d, &stored)
+	r.s.deliveries[d.ID] = stored
+
+	return nil
+}
+
+func (r *deliveries) DeletePending(ctx context.Context, webhook primitive.ObjectID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, d := range r.s.deliveries {
+		if d.Webhook == webhook && d.Status == model.WebhookDeliveryPending {
+			delete(r.s.deliveries, id)
+		}
+	}
+
+	return nil
+}
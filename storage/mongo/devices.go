@@ -0,0 +1,114 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type devices struct {
+	s *Store
+}
+
+func (r *devices) List(ctx context.Context, owner primitive.ObjectID, page storage.Page) ([]model.Device, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	filter := bson.M{}
+	if !owner.IsZero() {
+		filter["owner"] = owner
+	}
+
+	result := []model.Device{}
+	count, err := list(ctx, collection, filter, page, &result)
+
+	return result, count, err
+}
+
+func (r *devices) Get(ctx context.Context, id string) (model.Device, error) {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	d := model.Device{}
+	err := one(ctx, collection, bson.M{"_id": id}, &d)
+
+	return d, err
+}
+
+func (r *devices) GetByCode(ctx context.Context, code string) (model.Device, error) {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	d := model.Device{}
+	err := one(ctx, collection, bson.M{"code": code}, &d)
+
+	return d, err
+}
+
+func (r *devices) CountUnlinked(ctx context.Context) (int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	// linked devices are the only ones with an owner
+	count, err := collection.CountDocuments(ctx, bson.M{"owner": bson.M{"$exists": false}})
+
+	return int(count), storeError(err)
+}
+
+func (r *devices) Create(ctx context.Context, d model.Device) error {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, d)
+
+	return storeError(err)
+}
+
+func (r *devices) Update(ctx context.Context, d model.Device) error {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	return matched(collection.ReplaceOne(ctx, bson.M{"_id": d.ID}, d))
+}
+
+func (r *devices) Delete(ctx context.Context, id string) error {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
+
+// DeleteExpired doesn't wait for the TTL index, which only runs every minute
+func (r *devices) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "devices")
+	defer cancel()
+
+	res, err := collection.DeleteMany(ctx, bson.M{
+		"owner":        bson.M{"$exists": false},
+		"code_expires": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return 0, storeError(err)
+	}
+
+	return int(res.DeletedCount), nil
+}
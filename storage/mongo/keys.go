@@ -0,0 +1,185 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type keys struct {
+	s *Store
+}
+
+func (r *keys) List(ctx context.Context) ([]model.Key, error) {
+	collection, ctx, cancel := r.s.c(ctx, "keys")
+	defer cancel()
+
+	result := []model.Key{}
+	err := all(ctx, collection, bson.M{}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *keys) Get(ctx context.Context, id primitive.ObjectID) (model.Key, error) {
+	collection, ctx, cancel := r.s.c(ctx, "keys")
+	defer cancel()
+
+	k := model.Key{}
+	err := one(ctx, collection, bson.M{"_id": id}, &k)
+
+	return k, err
+}
+
+func (r *keys) Create(ctx context.Context, k model.Key) error {
+	collection, ctx, cancel := r.s.c(ctx, "keys")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, k)
+
+	return storeError(err)
+}
+
+func (r *keys) Upsert(ctx context.Context, k model.Key) error {
+	collection, ctx, cancel := r.s.c(ctx, "keys")
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": k.ID}, k, options.Replace().SetUpsert(true))
+
+	return storeError(err)
+}
+
+type clients struct {
+	s *Store
+}
+
+func (r *clients) List(ctx context.Context, page storage.Page) ([]model.Client, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "clients")
+	defer cancel()
+
+	result := []model.Client{}
+	count, err := list(ctx, collection, bson.M{}, page, &result)
+
+	return result, count, err
+}
+
+func (r *clients) Get(ctx context.Context, id primitive.ObjectID) (model.Client, error) {
+	collection, ctx, cancel := r.s.c(ctx, "clients")
+	defer cancel()
+
+	c := model.Client{}
+	err := one(ctx, collection, bson.M{"_id": id}, &c)
+
+	return c, err
+}
+
+func (r *clients) Create(ctx context.Context, c model.Client) error {
+	collection, ctx, cancel := r.s.c(ctx, "clients")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, c)
+
+	return storeError(err)
+}
+
+func (r *clients) Replace(ctx context.Context, c model.Client) error {
+	collection, ctx, cancel := r.s.c(ctx, "clients")
+	defer cancel()
+
+	return matched(collection.ReplaceOne(ctx, bson.M{"_id": c.ID}, c))
+}
+
+func (r *clients) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "clients")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
+
+type policies struct {
+	s *Store
+}
+
+func (r *policies) List(ctx context.Context, page storage.Page) ([]model.Policy, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	result := []model.Policy{}
+	count, err := list(ctx, collection, bson.M{}, page, &result)
+
+	return result, count, err
+}
+
+func (r *policies) All(ctx context.Context) ([]model.Policy, error) {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	result := []model.Policy{}
+	err := all(ctx, collection, bson.M{}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *policies) Get(ctx context.Context, id primitive.ObjectID) (model.Policy, error) {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	p := model.Policy{}
+	err := one(ctx, collection, bson.M{"_id": id}, &p)
+
+	return p, err
+}
+
+func (r *policies) Create(ctx context.Context, p model.Policy) error {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, p)
+
+	return storeError(err)
+}
+
+func (r *policies) Replace(ctx context.Context, p model.Policy) error {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	return matched(collection.ReplaceOne(ctx, bson.M{"_id": p.ID}, p))
+}
+
+func (r *policies) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "policies")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
+
+type audit struct {
+	s *Store
+}
+
+func (r *audit) Record(ctx context.Context, e model.AuditEvent) error {
+	collection, ctx, cancel := r.s.c(ctx, "audit")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, e)
+
+	return storeError(err)
+}
@@ -0,0 +1,297 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// An index the repositories rely on
+type index struct {
+	collection string
+	name       string
+	keys       bson.D
+	unique     bool
+
+	// ttl makes MongoDB remove documents expireAfter past the date in the
+	// first key
+	ttl         bool
+	expireAfter time.Duration
+}
+
+func (i index) String() string {
+	fields := []string{}
+	for _, k := range i.keys {
+		fields = append(fields, fmt.Sprintf("%s: %v", k.Key, k.Value))
+	}
+
+	s := fmt.Sprintf("%s.%s {%s}", i.collection, i.name, strings.Join(fields, ", "))
+	if i.unique {
+		s += " unique"
+	}
+	if i.ttl {
+		s += fmt.Sprintf(" expiring after %s", i.expireAfter)
+	}
+
+	return s
+}
+
+func (i index) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.name)
+	if i.unique {
+		opts.SetUnique(true)
+	}
+	if i.ttl {
+		opts.SetExpireAfterSeconds(int32(i.expireAfter.Seconds()))
+	}
+
+	return mongo.IndexModel{Keys: i.keys, Options: opts}
+}
+
+// indexSpec is an index as reported by listIndexes
+type indexSpec struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+// matches checks an existing index has the same definition
+func (i index) matches(spec *indexSpec) bool {
+	if len(spec.Key) != len(i.keys) {
+		return false
+	}
+	for n, k := range spec.Key {
+		// numbers may come back as any of int32, int64 or double
+		if k.Key != i.keys[n].Key || fmt.Sprint(k.Value) != fmt.Sprint(i.keys[n].Value) {
+			return false
+		}
+	}
+
+	if spec.Unique != i.unique || (spec.ExpireAfterSeconds != nil) != i.ttl {
+		return false
+	}
+
+	return !i.ttl || *spec.ExpireAfterSeconds == int64(i.expireAfter.Seconds())
+}
+
+// A migration moves the database from the previous version to its own
+type migration struct {
+	version     int
+	description string
+	drop        []index
+	create      []index
+}
+
+// migrations are applied in order and must never change once released, add
+// a new one instead
+var migrations = []migration{
+	{
+		version:     1,
+		description: "drop the legacy token and device indexes",
+		drop: []index{
+			// the TTL was on the token string of a collection nothing uses
+			{collection: "token", name: "token_1"},
+			// a text index only matched whole words of the device id
+			{collection: "devices", name: "id_text"},
+		},
+	},
+	{
+		version:     2,
+		description: "index users, tokens and devices",
+		create: []index{
+			{collection: "users", name: "email_1", keys: bson.D{{Key: "email", Value: 1}}, unique: true},
+			{collection: "tokens", name: "token_1", keys: bson.D{{Key: "token", Value: 1}}, unique: true},
+			{collection: "tokens", name: "user_1_expires_-1", keys: bson.D{{Key: "user", Value: 1}, {Key: "expires", Value: -1}}},
+			{collection: "tokens", name: "expires_ttl", keys: bson.D{{Key: "expires", Value: 1}}, ttl: true},
+			{collection: "devices", name: "owner_1", keys: bson.D{{Key: "owner", Value: 1}}},
+			{collection: "devices", name: "code_1", keys: bson.D{{Key: "code", Value: 1}}},
+			{collection: "devices", name: "code_expires_ttl", keys: bson.D{{Key: "code_expires", Value: 1}}, ttl: true},
+		},
+	},
+	{
+		version:     3,
+		description: "index webhook deliveries and audit",
+		create: []index{
+			{collection: "webhook_deliveries", name: "status_1_next_attempt_1", keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}}},
+			{collection: "webhook_deliveries", name: "webhook_1", keys: bson.D{{Key: "webhook", Value: 1}}},
+			{collection: "audit", name: "user_1_created_-1", keys: bson.D{{Key: "user", Value: 1}, {Key: "created", Value: -1}}},
+		},
+	},
+}
+
+// Migrate applies the migrations that haven't been yet, recording each in
+// the migrations collection, and then checks every index is still as the
+// migrations left it, recreating any that went missing or were changed by
+// hand. It returns a description of each change, on a dry run nothing is
+// changed and the description is of what would be.
+func (s *Store) Migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	changes := []string{}
+
+	applied := map[int]bool{}
+	cursor, err := s.db.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	records := []struct {
+		Version int `bson:"_id"`
+	}{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		applied[r.Version] = true
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("apply migration %d, %s", m.version, m.description))
+		for _, i := range m.drop {
+			changes = append(changes, "drop index "+i.collection+"."+i.name)
+		}
+		for _, i := range m.create {
+			changes = append(changes, "create index "+i.String())
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if err := s.apply(ctx, m); err != nil {
+			return changes, fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+
+	// indexes that should exist by now
+	expected := []index{}
+	for _, m := range migrations {
+		if dryRun && !applied[m.version] {
+			continue
+		}
+		expected = append(expected, m.create...)
+	}
+
+	for _, i := range expected {
+		spec, err := s.findIndex(ctx, i.collection, i.name)
+		if err != nil {
+			return changes, err
+		}
+
+		if spec != nil && i.matches(spec) {
+			continue
+		}
+
+		if spec == nil {
+			changes = append(changes, "recreate missing index "+i.String())
+		} else {
+			changes = append(changes, "recreate changed index "+i.String())
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if spec != nil {
+			if _, err := s.db.Collection(i.collection).Indexes().DropOne(ctx, i.name); err != nil {
+				return changes, err
+			}
+		}
+		if _, err := s.db.Collection(i.collection).Indexes().CreateOne(ctx, i.model()); err != nil {
+			return changes, fmt.Errorf("index %s.%s: %v", i.collection, i.name, err)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *Store) apply(ctx context.Context, m migration) error {
+	for _, i := range m.drop {
+		spec, err := s.findIndex(ctx, i.collection, i.name)
+		if err != nil {
+			return err
+		}
+
+		if spec == nil {
+			continue
+		}
+
+		if _, err := s.db.Collection(i.collection).Indexes().DropOne(ctx, i.name); err != nil {
+			// another instance applying the migration dropped it first
+			var e mongo.CommandError
+			if errors.As(err, &e) && e.Name == "IndexNotFound" {
+				continue
+			}
+			return err
+		}
+	}
+
+	for _, i := range m.create {
+		if _, err := s.db.Collection(i.collection).Indexes().CreateOne(ctx, i.model()); err != nil {
+			return fmt.Errorf("index %s.%s: %v", i.collection, i.name, err)
+		}
+	}
+
+	_, err := s.db.Collection("migrations").InsertOne(ctx, bson.M{
+		"_id":         m.version,
+		"description": m.description,
+		"applied":     time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// another instance applied the same migration at the same time, the
+		// index changes are idempotent so it has been applied either way
+		return nil
+	}
+
+	return err
+}
+
+// findIndex returns the definition of the named index, or nil if the
+// collection has no such index
+func (s *Store) findIndex(ctx context.Context, collection, name string) (*indexSpec, error) {
+	cursor, err := s.db.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		// listing the indexes of a collection that doesn't exist yet fails
+		// on older servers
+		var e mongo.CommandError
+		if errors.As(err, &e) && e.Name == "NamespaceNotFound" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	specs := []indexSpec{}
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+
+	for n := range specs {
+		if specs[n].Name == name {
+			return &specs[n], nil
+		}
+	}
+
+	return nil, nil
+}
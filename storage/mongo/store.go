@@ -0,0 +1,267 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongo stores everything in MongoDB, one collection per repository
+package mongo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Options configure the connection to MongoDB
+type Options struct {
+	// URI is a mongodb:// connection string or a plain host:port
+	URI      string
+	Database string
+
+	// Timeout bounds every operation on top of the caller's own deadline
+	Timeout time.Duration
+
+	// ConnectTimeout is how long to wait for the server when connecting
+	ConnectTimeout time.Duration
+
+	MaxPoolSize uint64
+	MinPoolSize uint64
+
+	// ReadConcern is a level such as local or majority, WriteConcern is
+	// majority or a number of nodes and ReadPreference a mode such as
+	// primary or secondaryPreferred. The server defaults apply when empty.
+	ReadConcern    string
+	WriteConcern   string
+	ReadPreference string
+}
+
+// Store implements storage.Store with the official MongoDB driver
+type Store struct {
+	client  *mongo.Client
+	db      *mongo.Database
+	timeout time.Duration
+}
+
+// Dial connects to MongoDB, failing if the server can't be reached
+func Dial(o Options) (*Store, error) {
+	uri := o.URI
+	if !strings.Contains(uri, "://") {
+		uri = "mongodb://" + uri
+	}
+
+	opts := options.Client().
+		ApplyURI(uri).
+		SetRegistry(storage.Registry)
+
+	if o.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(o.ConnectTimeout)
+		opts.SetServerSelectionTimeout(o.ConnectTimeout)
+	}
+	if o.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		opts.SetMinPoolSize(o.MinPoolSize)
+	}
+	if len(o.ReadConcern) > 0 {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: o.ReadConcern})
+	}
+	if len(o.WriteConcern) > 0 {
+		wc := &writeconcern.WriteConcern{W: o.WriteConcern}
+		if n, err := strconv.Atoi(o.WriteConcern); err == nil {
+			wc.W = n
+		}
+		opts.SetWriteConcern(wc)
+	}
+	if len(o.ReadPreference) > 0 {
+		mode, err := readpref.ModeFromString(o.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.ConnectTimeout+time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return New(client, o.Database, o.Timeout), nil
+}
+
+// New creates a store using an existing client
+func New(client *mongo.Client, database string, timeout time.Duration) *Store {
+	return &Store{
+		client:  client,
+		db:      client.Database(database),
+		timeout: timeout,
+	}
+}
+
+func (s *Store) Users() storage.UserRepository          { return &users{s} }
+func (s *Store) Tokens() storage.TokenRepository        { return &tokens{s} }
+func (s *Store) Devices() storage.DeviceRepository      { return &devices{s} }
+func (s *Store) Keys() storage.KeyRepository            { return &keys{s} }
+func (s *Store) Clients() storage.ClientRepository      { return &clients{s} }
+func (s *Store) Policies() storage.PolicyRepository     { return &policies{s} }
+func (s *Store) Webhooks() storage.WebhookRepository    { return &webhooks{s} }
+func (s *Store) Deliveries() storage.DeliveryRepository { return &deliveries{s} }
+func (s *Store) Audit() storage.AuditRepository         { return &audit{s} }
+
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+func (s *Store) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.client.Disconnect(ctx)
+}
+
+// deadline bounds ctx by the store's operation timeout
+func (s *Store) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// c returns the named collection along with a context bounded by the
+// operation timeout, the returned func must be called once finished with it
+func (s *Store) c(ctx context.Context, name string) (*mongo.Collection, context.Context, context.CancelFunc) {
+	ctx, cancel := s.deadline(ctx)
+	return s.db.Collection(name), ctx, cancel
+}
+
+// list fetches a page of the documents matching filter into v and returns
+// how many match in total
+func list(ctx context.Context, collection *mongo.Collection, filter bson.M, page storage.Page, v interface{}) (int, error) {
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := options.Find().
+		SetSort(sortBy(page.Sort)).
+		SetSkip(int64(page.Offset))
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cursor.All(ctx, v); err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// sortBy turns a field name, optionally prefixed with -, into a sort document
+func sortBy(field string) bson.D {
+	order := 1
+	if strings.HasPrefix(field, "-") {
+		order = -1
+		field = field[1:]
+	}
+
+	if len(field) == 0 || field == "id" {
+		field = "_id"
+	}
+
+	return bson.D{{Key: field, Value: order}}
+}
+
+// all decodes every document matching filter into v
+func all(ctx context.Context, collection *mongo.Collection, filter interface{}, v interface{}, opts ...*options.FindOptions) error {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+
+	return cursor.All(ctx, v)
+}
+
+// one decodes the first document matching filter into v
+func one(ctx context.Context, collection *mongo.Collection, filter interface{}, v interface{}, opts ...*options.FindOneOptions) error {
+	return storeError(collection.FindOne(ctx, filter, opts...).Decode(v))
+}
+
+// matched checks an update found the document it was meant to change
+func matched(res *mongo.UpdateResult, err error) error {
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// deleted checks a delete removed the document it was meant to
+func deleted(res *mongo.DeleteResult, err error) error {
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// storeError maps driver errors to their storage equivalents
+func storeError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return storage.ErrNotFound
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return storage.ErrDuplicate
+	}
+
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/2-IMMERSE/auth-service/model"
+)
+
+type tokens struct {
+	s *Store
+}
+
+func (r *tokens) All(ctx context.Context) ([]model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	result := []model.Token{}
+	err := all(ctx, collection, bson.M{}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *tokens) Get(ctx context.Context, token string) (model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	t := model.Token{}
+	err := one(ctx, collection, bson.M{"token": token}, &t)
+
+	return t, err
+}
+
+func (r *tokens) Latest(ctx context.Context, user primitive.ObjectID) (model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	t := model.Token{}
+	err := one(ctx, collection, bson.M{"user": user}, &t, options.FindOne().SetSort(bson.D{{Key: "expires", Value: -1}}))
+
+	return t, err
+}
+
+func (r *tokens) ListByUser(ctx context.Context, user primitive.ObjectID) ([]model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	result := []model.Token{}
+	err := all(ctx, collection, bson.M{"user": user}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *tokens) Pending(ctx context.Context) ([]model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	result := []model.Token{}
+	err := all(ctx, collection, bson.M{"gateway_pending": true}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *tokens) CountActive(ctx context.Context, now time.Time) (int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"expires": bson.M{"$gt": now}})
+
+	return int(count), storeError(err)
+}
+
+func (r *tokens) Create(ctx context.Context, t model.Token) error {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, t)
+
+	return storeError(err)
+}
+
+func (r *tokens) ClearPending(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	return matched(collection.UpdateByID(ctx, id, bson.M{"$unset": bson.M{"gateway_pending": ""}}))
+}
+
+func (r *tokens) SetQuotaExceeded(ctx context.Context, token string, at time.Time) (model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	t := model.Token{}
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"token": token},
+		bson.M{"$set": bson.M{"quota_exceeded": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&t)
+
+	return t, storeError(err)
+}
+
+func (r *tokens) Remove(ctx context.Context, token string) (model.Token, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	t := model.Token{}
+	err := collection.FindOneAndDelete(ctx, bson.M{"token": token}).Decode(&t)
+
+	return t, storeError(err)
+}
+
+func (r *tokens) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
+
+// DeleteExpired doesn't wait for the TTL index, which only runs every minute
+func (r *tokens) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "tokens")
+	defer cancel()
+
+	res, err := collection.DeleteMany(ctx, bson.M{"expires": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, storeError(err)
+	}
+
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type users struct {
+	s *Store
+}
+
+func (r *users) List(ctx context.Context, page storage.Page) ([]model.User, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	result := []model.User{}
+	count, err := list(ctx, collection, bson.M{}, page, &result)
+
+	return result, count, err
+}
+
+func (r *users) Get(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	u := model.User{}
+	err := one(ctx, collection, bson.M{"_id": id}, &u)
+
+	return u, err
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	u := model.User{}
+	err := one(ctx, collection, bson.M{"email": email}, &u)
+
+	return u, err
+}
+
+func (r *users) Create(ctx context.Context, u model.User) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, u)
+
+	return storeError(err)
+}
+
+func (r *users) Update(ctx context.Context, id primitive.ObjectID, update model.User) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	fields, err := bson.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	// an empty $set is refused, with nothing to set the user only has to exist
+	if elements, err := bson.Raw(fields).Elements(); err != nil {
+		return err
+	} else if len(elements) == 0 {
+		_, err := r.Get(ctx, id)
+		return err
+	}
+
+	return matched(collection.UpdateByID(ctx, id, bson.M{"$set": bson.Raw(fields)}))
+}
+
+func (r *users) SetProfile(ctx context.Context, id primitive.ObjectID, profile *model.Profile) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	return matched(collection.UpdateByID(ctx, id, bson.M{"$set": bson.M{"profile": profile}}))
+}
+
+func (r *users) SetGatewayID(ctx context.Context, id primitive.ObjectID, gatewayID string) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	return matched(collection.UpdateByID(ctx, id, bson.M{"$set": bson.M{"gateway_id": gatewayID}}))
+}
+
+func (r *users) UpsertByEmail(ctx context.Context, u model.User) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"email": u.Email}, u, options.Replace().SetUpsert(true))
+
+	return storeError(err)
+}
+
+func (r *users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "users")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+type webhooks struct {
+	s *Store
+}
+
+func (r *webhooks) List(ctx context.Context, page storage.Page) ([]model.Webhook, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	result := []model.Webhook{}
+	count, err := list(ctx, collection, bson.M{}, page, &result)
+
+	return result, count, err
+}
+
+func (r *webhooks) Active(ctx context.Context) ([]model.Webhook, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	result := []model.Webhook{}
+	err := all(ctx, collection, bson.M{"active": true}, &result)
+
+	return result, storeError(err)
+}
+
+func (r *webhooks) Get(ctx context.Context, id primitive.ObjectID) (model.Webhook, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	h := model.Webhook{}
+	err := one(ctx, collection, bson.M{"_id": id}, &h)
+
+	return h, err
+}
+
+func (r *webhooks) Create(ctx context.Context, h model.Webhook) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, h)
+
+	return storeError(err)
+}
+
+func (r *webhooks) Replace(ctx context.Context, h model.Webhook) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	return matched(collection.ReplaceOne(ctx, bson.M{"_id": h.ID}, h))
+}
+
+func (r *webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhooks")
+	defer cancel()
+
+	return deleted(collection.DeleteOne(ctx, bson.M{"_id": id}))
+}
+
+type deliveries struct {
+	s *Store
+}
+
+func (r *deliveries) List(ctx context.Context, webhook primitive.ObjectID, page storage.Page) ([]model.WebhookDelivery, int, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	result := []model.WebhookDelivery{}
+	count, err := list(ctx, collection, bson.M{"webhook": webhook}, page, &result)
+
+	return result, count, err
+}
+
+func (r *deliveries) Get(ctx context.Context, webhook, id primitive.ObjectID) (model.WebhookDelivery, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	d := model.WebhookDelivery{}
+	err := one(ctx, collection, bson.M{"_id": id, "webhook": webhook}, &d)
+
+	return d, err
+}
+
+func (r *deliveries) Due(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	result := []model.WebhookDelivery{}
+	err := all(ctx, collection, bson.M{
+		"status":       model.WebhookDeliveryPending,
+		"next_attempt": bson.M{"$lte": now},
+	}, &result, options.Find().
+		SetSort(bson.D{{Key: "next_attempt", Value: 1}}).
+		SetLimit(int64(limit)))
+
+	return result, storeError(err)
+}
+
+func (r *deliveries) Claim(ctx context.Context, d model.WebhookDelivery, until time.Time) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	return matched(collection.UpdateOne(ctx, bson.M{
+		"_id":          d.ID,
+		"status":       model.WebhookDeliveryPending,
+		"next_attempt": d.NextAttempt,
+	}, bson.M{"$set": bson.M{"next_attempt": until}}))
+}
+
+func (r *deliveries) Create(ctx context.Context, d model.WebhookDelivery) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, d)
+
+	return storeError(err)
+}
+
+func (r *deliveries) Save(ctx context.Context, d model.WebhookDelivery) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	return matched(collection.ReplaceOne(ctx, bson.M{"_id": d.ID}, d))
+}
+
+func (r *deliveries) DeletePending(ctx context.Context, webhook primitive.ObjectID) error {
+	collection, ctx, cancel := r.s.c(ctx, "webhook_deliveries")
+	defer cancel()
+
+	_, err := collection.DeleteMany(ctx, bson.M{"webhook": webhook, "status": model.WebhookDeliveryPending})
+
+	return storeError(err)
+}
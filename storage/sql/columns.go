@@ -0,0 +1,129 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// objectID stores an ObjectID as its hex string, NULL when it isn't set
+type objectID struct {
+	id *primitive.ObjectID
+}
+
+func (o objectID) Value() (driver.Value, error) {
+	if o.id.IsZero() {
+		return nil, nil
+	}
+
+	return o.id.Hex(), nil
+}
+
+func (o objectID) Scan(src interface{}) error {
+	*o.id = primitive.NilObjectID
+
+	var hex string
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		hex = v
+	case []byte:
+		hex = string(v)
+	default:
+		return fmt.Errorf("can't scan %T into an ObjectID", src)
+	}
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return err
+	}
+
+	*o.id = id
+	return nil
+}
+
+// timestamp stores times in UTC to the microsecond, NULL when they're zero
+type timestamp struct {
+	t *time.Time
+}
+
+func (t timestamp) Value() (driver.Value, error) {
+	if t.t.IsZero() {
+		return nil, nil
+	}
+
+	return t.t.UTC().Truncate(time.Microsecond), nil
+}
+
+func (t timestamp) Scan(src interface{}) error {
+	*t.t = time.Time{}
+
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		*t.t = v.UTC()
+		return nil
+	}
+
+	return fmt.Errorf("can't scan %T into a time", src)
+}
+
+// jsonColumn stores a value, such as user settings, as JSON. Nil maps,
+// slices and pointers are NULL.
+type jsonColumn struct {
+	v interface{}
+}
+
+func (j jsonColumn) Value() (driver.Value, error) {
+	v := reflect.ValueOf(j.v).Elem()
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+
+	// a string rather than bytes, which postgres would take for bytea
+	return string(data), nil
+}
+
+func (j jsonColumn) Scan(src interface{}) error {
+	v := reflect.ValueOf(j.v).Elem()
+	v.Set(reflect.Zero(v.Type()))
+
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), j.v)
+	case []byte:
+		return json.Unmarshal(data, j.v)
+	}
+
+	return fmt.Errorf("can't scan %T as JSON", src)
+}
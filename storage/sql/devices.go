@@ -0,0 +1,104 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+const deviceColumns = "id, type, code, owner, aux, code_expires"
+
+var deviceSorts = map[string]string{
+	"id":    "id",
+	"type":  "type",
+	"owner": "owner",
+}
+
+type devices struct {
+	s *Store
+}
+
+func scanDevice(d *model.Device) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(&d.ID, &d.Type, &d.Code, objectID{&d.Owner}, &d.Aux, timestamp{&d.CodeExpires})
+	}
+}
+
+func (r *devices) List(ctx context.Context, owner primitive.ObjectID, page storage.Page) ([]model.Device, int, error) {
+	where, args := "", []interface{}{}
+	if !owner.IsZero() {
+		where, args = " WHERE owner = ?", append(args, objectID{&owner})
+	}
+
+	result := []model.Device{}
+	count, err := r.s.list(ctx, func(row scanner) error {
+		d := model.Device{}
+		if err := scanDevice(&d)(row); err != nil {
+			return err
+		}
+
+		result = append(result, d)
+		return nil
+	}, "devices", deviceColumns, deviceSorts, page, where, args...)
+
+	return result, count, err
+}
+
+func (r *devices) Get(ctx context.Context, id string) (model.Device, error) {
+	d := model.Device{}
+	err := r.s.one(ctx, scanDevice(&d), "SELECT "+deviceColumns+" FROM devices WHERE id = ?", id)
+
+	return d, err
+}
+
+func (r *devices) GetByCode(ctx context.Context, code string) (model.Device, error) {
+	d := model.Device{}
+	err := r.s.one(ctx, scanDevice(&d), "SELECT "+deviceColumns+" FROM devices WHERE code = ?", code)
+
+	return d, err
+}
+
+func (r *devices) CountUnlinked(ctx context.Context) (int, error) {
+	count := 0
+	err := r.s.one(ctx, func(row scanner) error {
+		return row.Scan(&count)
+	}, "SELECT COUNT(*) FROM devices WHERE owner IS NULL")
+
+	return count, err
+}
+
+func (r *devices) Create(ctx context.Context, d model.Device) error {
+	return r.s.exec(ctx, "INSERT INTO devices ("+deviceColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		d.ID, d.Type, d.Code, objectID{&d.Owner}, d.Aux, timestamp{&d.CodeExpires})
+}
+
+func (r *devices) Update(ctx context.Context, d model.Device) error {
+	return r.s.exec(ctx, "UPDATE devices SET type = ?, code = ?, owner = ?, aux = ?, code_expires = ? WHERE id = ?",
+		d.Type, d.Code, objectID{&d.Owner}, d.Aux, timestamp{&d.CodeExpires}, d.ID)
+}
+
+func (r *devices) Delete(ctx context.Context, id string) error {
+	return r.s.exec(ctx, "DELETE FROM devices WHERE id = ?", id)
+}
+
+func (r *devices) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.s.execAll(ctx, "DELETE FROM devices WHERE owner IS NULL AND code_expires <= ?", timestamp{&now})
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+const (
+	keyColumns    = "id, title, slug, data"
+	clientColumns = "id, client_id, client_secret, name, redirect_uri, owner"
+	policyColumns = "id, name, roles, user_groups, scopes, priority, gateway_policies, access_rights, rate, per, quota_max, quota_renewal_rate"
+)
+
+var clientSorts = map[string]string{
+	"id":        "id",
+	"client_id": "client_id",
+	"name":      "name",
+	"owner":     "owner",
+}
+
+var policySorts = map[string]string{
+	"id":       "id",
+	"name":     "name",
+	"priority": "priority",
+}
+
+type keys struct {
+	s *Store
+}
+
+func scanKey(k *model.Key) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(objectID{&k.ID}, &k.Title, &k.Slug, &k.Data)
+	}
+}
+
+func (r *keys) List(ctx context.Context) ([]model.Key, error) {
+	result := []model.Key{}
+	err := r.s.all(ctx, func(row scanner) error {
+		k := model.Key{}
+		if err := scanKey(&k)(row); err != nil {
+			return err
+		}
+
+		result = append(result, k)
+		return nil
+	}, "SELECT "+keyColumns+" FROM keys")
+
+	return result, err
+}
+
+func (r *keys) Get(ctx context.Context, id primitive.ObjectID) (model.Key, error) {
+	k := model.Key{}
+	err := r.s.one(ctx, scanKey(&k), "SELECT "+keyColumns+" FROM keys WHERE id = ?", objectID{&id})
+
+	return k, err
+}
+
+func (r *keys) Create(ctx context.Context, k model.Key) error {
+	if k.ID.IsZero() {
+		k.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO keys ("+keyColumns+") VALUES (?, ?, ?, ?)",
+		objectID{&k.ID}, k.Title, k.Slug, k.Data)
+}
+
+func (r *keys) Upsert(ctx context.Context, k model.Key) error {
+	return r.s.exec(ctx, "INSERT INTO keys ("+keyColumns+") VALUES (?, ?, ?, ?)"+
+		" ON CONFLICT (id) DO UPDATE SET title = excluded.title, slug = excluded.slug, data = excluded.data",
+		objectID{&k.ID}, k.Title, k.Slug, k.Data)
+}
+
+type clients struct {
+	s *Store
+}
+
+func scanClient(c *model.Client) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(objectID{&c.ID}, &c.ClientID, &c.ClientSecret, &c.Name, &c.RedirectURI, objectID{&c.Owner})
+	}
+}
+
+func (r *clients) List(ctx context.Context, page storage.Page) ([]model.Client, int, error) {
+	result := []model.Client{}
+	count, err := r.s.list(ctx, func(row scanner) error {
+		c := model.Client{}
+		if err := scanClient(&c)(row); err != nil {
+			return err
+		}
+
+		result = append(result, c)
+		return nil
+	}, "clients", clientColumns, clientSorts, page, "")
+
+	return result, count, err
+}
+
+func (r *clients) Get(ctx context.Context, id primitive.ObjectID) (model.Client, error) {
+	c := model.Client{}
+	err := r.s.one(ctx, scanClient(&c), "SELECT "+clientColumns+" FROM clients WHERE id = ?", objectID{&id})
+
+	return c, err
+}
+
+func (r *clients) Create(ctx context.Context, c model.Client) error {
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO clients ("+clientColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		objectID{&c.ID}, c.ClientID, c.ClientSecret, c.Name, c.RedirectURI, objectID{&c.Owner})
+}
+
+func (r *clients) Replace(ctx context.Context, c model.Client) error {
+	return r.s.exec(ctx, "UPDATE clients SET client_id = ?, client_secret = ?, name = ?, redirect_uri = ?, owner = ? WHERE id = ?",
+		c.ClientID, c.ClientSecret, c.Name, c.RedirectURI, objectID{&c.Owner}, objectID{&c.ID})
+}
+
+func (r *clients) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "DELETE FROM clients WHERE id = ?", objectID{&id})
+}
+
+type policies struct {
+	s *Store
+}
+
+func scanPolicy(p *model.Policy) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(
+			objectID{&p.ID},
+			&p.Name,
+			jsonColumn{&p.Roles},
+			jsonColumn{&p.Groups},
+			jsonColumn{&p.Scopes},
+			&p.Priority,
+			jsonColumn{&p.GatewayPolicies},
+			jsonColumn{&p.AccessRights},
+			&p.Rate,
+			&p.Per,
+			&p.QuotaMax,
+			&p.QuotaRenewalRate,
+		)
+	}
+}
+
+// policyValues are the columns after id
+func policyValues(p *model.Policy) []interface{} {
+	return []interface{}{
+		p.Name,
+		jsonColumn{&p.Roles},
+		jsonColumn{&p.Groups},
+		jsonColumn{&p.Scopes},
+		p.Priority,
+		jsonColumn{&p.GatewayPolicies},
+		jsonColumn{&p.AccessRights},
+		p.Rate,
+		p.Per,
+		p.QuotaMax,
+		p.QuotaRenewalRate,
+	}
+}
+
+// policyList collects every row into result
+func policyList(result *[]model.Policy) func(scanner) error {
+	return func(row scanner) error {
+		p := model.Policy{}
+		if err := scanPolicy(&p)(row); err != nil {
+			return err
+		}
+
+		*result = append(*result, p)
+		return nil
+	}
+}
+
+func (r *policies) List(ctx context.Context, page storage.Page) ([]model.Policy, int, error) {
+	result := []model.Policy{}
+	count, err := r.s.list(ctx, policyList(&result), "policies", policyColumns, policySorts, page, "")
+
+	return result, count, err
+}
+
+func (r *policies) All(ctx context.Context) ([]model.Policy, error) {
+	result := []model.Policy{}
+	err := r.s.all(ctx, policyList(&result), "SELECT "+policyColumns+" FROM policies")
+
+	return result, err
+}
+
+func (r *policies) Get(ctx context.Context, id primitive.ObjectID) (model.Policy, error) {
+	p := model.Policy{}
+	err := r.s.one(ctx, scanPolicy(&p), "SELECT "+policyColumns+" FROM policies WHERE id = ?", objectID{&id})
+
+	return p, err
+}
+
+func (r *policies) Create(ctx context.Context, p model.Policy) error {
+	if p.ID.IsZero() {
+		p.ID = primitive.NewObjectID()
+	}
+
+	args := append([]interface{}{objectID{&p.ID}}, policyValues(&p)...)
+
+	return r.s.exec(ctx, "INSERT INTO policies ("+policyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", args...)
+}
+
+func (r *policies) Replace(ctx context.Context, p model.Policy) error {
+	args := append(policyValues(&p), objectID{&p.ID})
+
+	return r.s.exec(ctx, "UPDATE policies SET name = ?, roles = ?, user_groups = ?, scopes = ?, priority = ?,"+
+		" gateway_policies = ?, access_rights = ?, rate = ?, per = ?, quota_max = ?, quota_renewal_rate = ?"+
+		" WHERE id = ?", args...)
+}
+
+func (r *policies) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "DELETE FROM policies WHERE id = ?", objectID{&id})
+}
+
+type audit struct {
+	s *Store
+}
+
+func (r *audit) Record(ctx context.Context, e model.AuditEvent) error {
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO audit (id, type, source, user_id, token, message, data, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		objectID{&e.ID}, e.Type, e.Source, objectID{&e.User}, e.Token, e.Message, jsonColumn{&e.Data}, timestamp{&e.CreatedAt})
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// migrationLock is the postgres advisory lock held while migrating
+const migrationLock = 0x32696d6d
+
+// A migration moves the schema from the previous version to its own
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are applied in order and must never change once released, add
+// a new one instead. Column types are written for SQLite and translated by
+// the dialect.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "users, tokens, devices, keys and clients",
+		statements: []string{
+			`CREATE TABLE users (
+				id CHAR(24) PRIMARY KEY,
+				display_name TEXT NOT NULL DEFAULT '',
+				first_name TEXT NOT NULL DEFAULT '',
+				last_name TEXT NOT NULL DEFAULT '',
+				email TEXT NOT NULL UNIQUE,
+				password BLOB,
+				roles JSON,
+				user_groups JSON,
+				settings JSON,
+				profile JSON,
+				gateway_id TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE tokens (
+				id CHAR(24) PRIMARY KEY,
+				token TEXT NOT NULL UNIQUE,
+				user_id CHAR(24) NOT NULL,
+				client_id CHAR(24),
+				expires TIMESTAMP NOT NULL,
+				aux TEXT NOT NULL DEFAULT '',
+				scope TEXT NOT NULL DEFAULT '',
+				quota_exceeded TIMESTAMP,
+				gateway_pending BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE INDEX tokens_user ON tokens (user_id, expires)`,
+			`CREATE TABLE devices (
+				id TEXT PRIMARY KEY,
+				type TEXT NOT NULL,
+				code TEXT NOT NULL DEFAULT '',
+				owner CHAR(24),
+				aux TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX devices_code ON devices (code)`,
+			`CREATE INDEX devices_owner ON devices (owner)`,
+			`CREATE TABLE keys (
+				id CHAR(24) PRIMARY KEY,
+				title TEXT NOT NULL DEFAULT '',
+				slug TEXT NOT NULL DEFAULT '',
+				data BLOB
+			)`,
+			`CREATE TABLE clients (
+				id CHAR(24) PRIMARY KEY,
+				client_id TEXT NOT NULL DEFAULT '',
+				client_secret TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL DEFAULT '',
+				redirect_uri TEXT NOT NULL DEFAULT '',
+				owner CHAR(24)
+			)`,
+		},
+	},
+	{
+		version:     2,
+		description: "policies, webhooks and audit",
+		statements: []string{
+			`CREATE TABLE policies (
+				id CHAR(24) PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				roles JSON,
+				user_groups JSON,
+				scopes JSON,
+				priority INTEGER NOT NULL DEFAULT 0,
+				gateway_policies JSON,
+				access_rights JSON,
+				rate INTEGER NOT NULL DEFAULT 0,
+				per INTEGER NOT NULL DEFAULT 0,
+				quota_max INTEGER NOT NULL DEFAULT 0,
+				quota_renewal_rate INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE webhooks (
+				id CHAR(24) PRIMARY KEY,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL DEFAULT '',
+				events JSON,
+				active BOOLEAN NOT NULL DEFAULT FALSE,
+				created TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE webhook_deliveries (
+				id CHAR(24) PRIMARY KEY,
+				webhook CHAR(24) NOT NULL,
+				event TEXT NOT NULL,
+				payload BLOB,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt TIMESTAMP,
+				response_status INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				created TIMESTAMP NOT NULL,
+				delivered TIMESTAMP
+			)`,
+			`CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt)`,
+			`CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook)`,
+			`CREATE TABLE audit (
+				id CHAR(24) PRIMARY KEY,
+				type TEXT NOT NULL,
+				source TEXT NOT NULL DEFAULT '',
+				user_id CHAR(24),
+				token TEXT NOT NULL DEFAULT '',
+				message TEXT NOT NULL DEFAULT '',
+				data JSON,
+				created TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX audit_user ON audit (user_id, created)`,
+		},
+	},
+	{
+		version:     3,
+		description: "expire device codes",
+		statements: []string{
+			`ALTER TABLE devices ADD COLUMN code_expires TIMESTAMP`,
+		},
+	},
+}
+
+// Migrate brings the schema up to date, applying each missing migration in
+// a transaction of its own and recording it in schema_migrations. Instances
+// migrating at once take turns, each migration only being applied by the
+// first. It returns
+// a description of each migration, on a dry run nothing is changed and the
+// description is of what would be.
+func (s *Store) Migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	if !dryRun {
+		_, err := s.db.ExecContext(ctx, s.dialect.types.Replace(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied TIMESTAMP NOT NULL
+		)`))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	applied := map[int]bool{}
+	err := s.all(ctx, func(row scanner) error {
+		version := 0
+		if err := row.Scan(&version); err != nil {
+			return err
+		}
+
+		applied[version] = true
+		return nil
+	}, "SELECT version FROM schema_migrations")
+
+	// before the first migration there's nothing to read on a dry run
+	if err != nil && !(dryRun && s.dialect.missingTable(err)) {
+		return nil, err
+	}
+
+	changes := []string{}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("apply migration %d, %s", m.version, m.description))
+		if dryRun {
+			continue
+		}
+
+		if err := s.apply(ctx, m); err != nil {
+			return changes, fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *Store) apply(ctx context.Context, m migration) error {
+	// database/sql can't begin a locked transaction, so it is begun by hand
+	// on a connection of its own
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, s.dialect.beginLocked); err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	// another instance may have applied it while we waited for the lock
+	applied := 0
+	err = conn.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.version).Scan(&applied)
+	if err != nil || applied > 0 {
+		return err
+	}
+
+	for _, statement := range m.statements {
+		if _, err := conn.ExecContext(ctx, s.dialect.types.Replace(statement)); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx,
+		s.rebind("INSERT INTO schema_migrations (version, description, applied) VALUES (?, ?, ?)"),
+		m.version, m.description, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
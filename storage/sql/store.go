@@ -0,0 +1,360 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sql stores everything in a relational database, PostgreSQL in
+// production or SQLite for a single node and tests
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Options configure the connection to the database
+type Options struct {
+	// Dialect is postgres or sqlite
+	Dialect string
+
+	// DSN is a connection string for postgres or a file name for sqlite
+	DSN string
+
+	// Timeout bounds every operation on top of the caller's own deadline
+	Timeout time.Duration
+
+	MaxOpenConns int
+	MaxIdleConns int
+}
+
+// dialect covers the differences between the supported databases
+type dialect struct {
+	driver string
+
+	// types maps the column types used by migrations onto the database's own
+	types *strings.Replacer
+
+	// numbered is set when placeholders are $1, $2... rather than ?
+	numbered bool
+
+	// noLimit is the LIMIT that returns every row
+	noLimit string
+
+	// beginLocked begins a transaction no other instance can be in at the
+	// same time, for migrations
+	beginLocked string
+
+	duplicate func(err error) bool
+
+	// missingTable reports whether err is from querying a table that
+	// doesn't exist
+	missingTable func(err error) bool
+}
+
+var dialects = map[string]*dialect{
+	"postgres": {
+		driver:   "postgres",
+		types:    strings.NewReplacer(" BLOB", " BYTEA", " TIMESTAMP", " TIMESTAMPTZ", " JSON", " JSONB"),
+		numbered: true,
+		noLimit:  "ALL",
+		// the lock is released when the transaction ends
+		beginLocked: fmt.Sprintf("BEGIN; SELECT pg_advisory_xact_lock(%d)", migrationLock),
+		duplicate: func(err error) bool {
+			var e *pq.Error
+			return errors.As(err, &e) && e.Code == "23505"
+		},
+		missingTable: func(err error) bool {
+			var e *pq.Error
+			return errors.As(err, &e) && e.Code == "42P01"
+		},
+	},
+	"sqlite": {
+		driver:  "sqlite3",
+		types:   strings.NewReplacer(" JSON", " TEXT"),
+		noLimit: "-1",
+		// takes the database's write lock straight away
+		beginLocked: "BEGIN IMMEDIATE",
+		duplicate: func(err error) bool {
+			var e sqlite3.Error
+			return errors.As(err, &e) &&
+				(e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+		},
+		missingTable: func(err error) bool {
+			var e sqlite3.Error
+			return errors.As(err, &e) && strings.HasPrefix(e.Error(), "no such table")
+		},
+	},
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Store implements storage.Store on top of database/sql
+type Store struct {
+	db      *sql.DB
+	dialect *dialect
+	timeout time.Duration
+
+	// q runs queries, the database or the transaction of a store made by
+	// inTx
+	q querier
+}
+
+// Open connects to the database, failing if it can't be reached. Migrate
+// must be run before the store is used.
+func Open(o Options) (*Store, error) {
+	d, ok := dialects[o.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown sql dialect %s", o.Dialect)
+	}
+
+	db, err := sql.Open(d.driver, o.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(o.MaxIdleConns)
+	}
+
+	// SQLite allows a single writer and every connection to :memory: gets a
+	// database of its own, so share one connection
+	if d.driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &Store{
+		db:      db,
+		dialect: d,
+		timeout: o.Timeout,
+		q:       db,
+	}
+
+	if err := s.Ping(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Store) Users() storage.UserRepository          { return &users{s} }
+func (s *Store) Tokens() storage.TokenRepository        { return &tokens{s} }
+func (s *Store) Devices() storage.DeviceRepository      { return &devices{s} }
+func (s *Store) Keys() storage.KeyRepository            { return &keys{s} }
+func (s *Store) Clients() storage.ClientRepository      { return &clients{s} }
+func (s *Store) Policies() storage.PolicyRepository     { return &policies{s} }
+func (s *Store) Webhooks() storage.WebhookRepository    { return &webhooks{s} }
+func (s *Store) Deliveries() storage.DeliveryRepository { return &deliveries{s} }
+func (s *Store) Audit() storage.AuditRepository         { return &audit{s} }
+
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	return s.db.PingContext(ctx)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// deadline bounds ctx by the store's operation timeout
+func (s *Store) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// inTx calls fn with a copy of the store whose queries run in a
+// transaction, committed if fn succeeds
+func (s *Store) inTx(ctx context.Context, fn func(tx *Store) error) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	in := *s
+	in.q = tx
+	if err := fn(&in); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rebind rewrites ? placeholders into the dialect's own
+func (s *Store) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// exec runs a statement that should change exactly one row
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	res, err := s.q.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return s.storeError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// execAll runs a statement that may change any number of rows, returning
+// how many it did
+func (s *Store) execAll(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	res, err := s.q.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return 0, s.storeError(err)
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+// one runs a query returning a single row and hands it to scan
+func (s *Store) one(ctx context.Context, scan func(scanner) error, query string, args ...interface{}) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	return s.storeError(scan(s.q.QueryRowContext(ctx, s.rebind(query), args...)))
+}
+
+// all runs a query and hands every row to scan
+func (s *Store) all(ctx context.Context, scan func(scanner) error, query string, args ...interface{}) error {
+	ctx, cancel := s.deadline(ctx)
+	defer cancel()
+
+	rows, err := s.q.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return s.storeError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// list fetches a page of the rows of table matching where, an optional
+// WHERE clause, and returns how many match in total. Only columns listed in
+// sorts, which maps field names onto columns, can be sorted on.
+func (s *Store) list(ctx context.Context, scan func(scanner) error, table, columns string, sorts map[string]string, page storage.Page, where string, args ...interface{}) (int, error) {
+	count := 0
+	err := s.one(ctx, func(row scanner) error {
+		return row.Scan(&count)
+	}, "SELECT COUNT(*) FROM "+table+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := s.dialect.noLimit
+	if page.Limit > 0 {
+		limit = strconv.Itoa(page.Limit)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT %s OFFSET %d",
+		columns, table, where, orderBy(page.Sort, sorts), limit, page.Offset)
+
+	return count, s.all(ctx, scan, query, args...)
+}
+
+// orderBy turns a field name, optionally prefixed with -, into an ORDER BY
+// clause. Unknown fields sort by id.
+func orderBy(field string, sorts map[string]string) string {
+	order := "ASC"
+	if strings.HasPrefix(field, "-") {
+		order = "DESC"
+		field = field[1:]
+	}
+
+	column, ok := sorts[field]
+	if !ok {
+		column = "id"
+	}
+
+	return column + " " + order
+}
+
+// storeError maps driver errors to their storage equivalents
+func (s *Store) storeError(err error) error {
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+
+	if err != nil && s.dialect.duplicate(err) {
+		return storage.ErrDuplicate
+	}
+
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// newTestStore is an in-memory SQLite store with every migration applied
+func newTestStore(t *testing.T) *Store {
+	s, err := Open(Options{Dialect: "sqlite", DSN: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if _, err := s.Migrate(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(Options{Dialect: "sqlite", DSN: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// nothing has been applied before there is a schema_migrations table
+	if pending, err := s.Migrate(ctx, true); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("got %d, %v on a dry run, expected %d migrations", len(pending), err, len(migrations))
+	}
+
+	if applied, err := s.Migrate(ctx, false); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("got %d, %v migrating, expected %d migrations", len(applied), err, len(migrations))
+	}
+	if pending, err := s.Migrate(ctx, true); err != nil || len(pending) != 0 {
+		t.Errorf("got %d, %v on a dry run once migrated, expected none", len(pending), err)
+	}
+
+	// only a missing table means nothing has been applied
+	s.Close()
+	if _, err := s.Migrate(ctx, true); err == nil {
+		t.Error("a dry run that couldn't read the applied migrations succeeded")
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "auth.db")
+
+	// every instance is connected before any starts migrating
+	const instances = 4
+	stores := []*Store{}
+	for i := 0; i < instances; i++ {
+		s, err := Open(Options{Dialect: "sqlite", DSN: dsn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		stores = append(stores, s)
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, instances)
+	for _, s := range stores {
+		go func(s *Store) {
+			<-start
+			_, err := s.Migrate(context.Background(), false)
+			errs <- err
+		}(s)
+	}
+	close(start)
+
+	for i := 0; i < instances; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	applied := 0
+	if err := stores[0].one(context.Background(), func(row scanner) error {
+		return row.Scan(&applied)
+	}, "SELECT COUNT(*) FROM schema_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("got %d migrations recorded, expected %d", applied, len(migrations))
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+)
+
+const tokenColumns = "id, token, user_id, client_id, expires, aux, scope, quota_exceeded, gateway_pending"
+
+type tokens struct {
+	s *Store
+}
+
+func scanToken(t *model.Token) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(
+			objectID{&t.ID},
+			&t.Token,
+			objectID{&t.User},
+			objectID{&t.Client},
+			timestamp{&t.ExpiresAt},
+			&t.Aux,
+			&t.Scope,
+			timestamp{&t.QuotaExceededAt},
+			&t.GatewayPending,
+		)
+	}
+}
+
+// tokenList collects every row into result
+func tokenList(result *[]model.Token) func(scanner) error {
+	return func(row scanner) error {
+		t := model.Token{}
+		if err := scanToken(&t)(row); err != nil {
+			return err
+		}
+
+		*result = append(*result, t)
+		return nil
+	}
+}
+
+func (r *tokens) All(ctx context.Context) ([]model.Token, error) {
+	result := []model.Token{}
+	err := r.s.all(ctx, tokenList(&result), "SELECT "+tokenColumns+" FROM tokens")
+
+	return result, err
+}
+
+func (r *tokens) Get(ctx context.Context, token string) (model.Token, error) {
+	t := model.Token{}
+	err := r.s.one(ctx, scanToken(&t), "SELECT "+tokenColumns+" FROM tokens WHERE token = ?", token)
+
+	return t, err
+}
+
+func (r *tokens) Latest(ctx context.Context, user primitive.ObjectID) (model.Token, error) {
+	t := model.Token{}
+	err := r.s.one(ctx, scanToken(&t),
+		"SELECT "+tokenColumns+" FROM tokens WHERE user_id = ? ORDER BY expires DESC LIMIT 1", objectID{&user})
+
+	return t, err
+}
+
+func (r *tokens) ListByUser(ctx context.Context, user primitive.ObjectID) ([]model.Token, error) {
+	result := []model.Token{}
+	err := r.s.all(ctx, tokenList(&result), "SELECT "+tokenColumns+" FROM tokens WHERE user_id = ?", objectID{&user})
+
+	return result, err
+}
+
+func (r *tokens) Pending(ctx context.Context) ([]model.Token, error) {
+	result := []model.Token{}
+	err := r.s.all(ctx, tokenList(&result), "SELECT "+tokenColumns+" FROM tokens WHERE gateway_pending = ?", true)
+
+	return result, err
+}
+
+func (r *tokens) CountActive(ctx context.Context, now time.Time) (int, error) {
+	count := 0
+	err := r.s.one(ctx, func(row scanner) error {
+		return row.Scan(&count)
+	}, "SELECT COUNT(*) FROM tokens WHERE expires > ?", timestamp{&now})
+
+	return count, err
+}
+
+func (r *tokens) Create(ctx context.Context, t model.Token) error {
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO tokens ("+tokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		objectID{&t.ID},
+		t.Token,
+		objectID{&t.User},
+		objectID{&t.Client},
+		timestamp{&t.ExpiresAt},
+		t.Aux,
+		t.Scope,
+		timestamp{&t.QuotaExceededAt},
+		t.GatewayPending,
+	)
+}
+
+func (r *tokens) ClearPending(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "UPDATE tokens SET gateway_pending = ? WHERE id = ?", false, objectID{&id})
+}
+
+func (r *tokens) SetQuotaExceeded(ctx context.Context, token string, at time.Time) (model.Token, error) {
+	// RETURNING needs SQLite 3.35, so the token is read back in the same
+	// transaction instead
+	t := model.Token{}
+	err := r.s.inTx(ctx, func(tx *Store) error {
+		if err := tx.exec(ctx, "UPDATE tokens SET quota_exceeded = ? WHERE token = ?", timestamp{&at}, token); err != nil {
+			return err
+		}
+
+		return tx.one(ctx, scanToken(&t), "SELECT "+tokenColumns+" FROM tokens WHERE token = ?", token)
+	})
+
+	return t, err
+}
+
+func (r *tokens) Remove(ctx context.Context, token string) (model.Token, error) {
+	// read before it is deleted, as with SetQuotaExceeded
+	t := model.Token{}
+	err := r.s.inTx(ctx, func(tx *Store) error {
+		if err := tx.one(ctx, scanToken(&t), "SELECT "+tokenColumns+" FROM tokens WHERE token = ?", token); err != nil {
+			return err
+		}
+
+		// the token removed by someone else in the meantime isn't found
+		return tx.exec(ctx, "DELETE FROM tokens WHERE token = ?", token)
+	})
+
+	return t, err
+}
+
+func (r *tokens) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "DELETE FROM tokens WHERE id = ?", objectID{&id})
+}
+
+func (r *tokens) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.s.execAll(ctx, "DELETE FROM tokens WHERE expires <= ?", timestamp{&now})
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+func TestTokens(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	user, other := primitive.NewObjectID(), primitive.NewObjectID()
+	tokens := []model.Token{
+		{Token: "old", User: user, ExpiresAt: now.Add(time.Hour)},
+		{Token: "new", User: user, ExpiresAt: now.Add(2 * time.Hour), GatewayPending: true},
+		{Token: "expired", User: user, ExpiresAt: now.Add(-time.Hour)},
+		{Token: "other", User: other, ExpiresAt: now.Add(3 * time.Hour)},
+	}
+	for _, token := range tokens {
+		if err := s.Tokens().Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := s.Tokens().Latest(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Token != "new" {
+		t.Errorf("got %s as the latest token, expected new", latest.Token)
+	}
+
+	if mine, _ := s.Tokens().ListByUser(ctx, user); len(mine) != 3 {
+		t.Errorf("got %d tokens for the user, expected 3", len(mine))
+	}
+
+	if n, _ := s.Tokens().CountActive(ctx, now); n != 3 {
+		t.Errorf("got %d active tokens, expected 3", n)
+	}
+
+	pending, _ := s.Tokens().Pending(ctx)
+	if len(pending) != 1 || pending[0].Token != "new" {
+		t.Fatalf("got pending tokens %+v, expected new", pending)
+	}
+	if err := s.Tokens().ClearPending(ctx, pending[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := s.Tokens().Pending(ctx); len(pending) != 0 {
+		t.Errorf("got %d pending tokens once cleared, expected none", len(pending))
+	}
+
+	if _, err := s.Tokens().SetQuotaExceeded(ctx, "old", now); err != nil {
+		t.Fatal(err)
+	}
+	// times are kept to the microsecond
+	if stored, _ := s.Tokens().Get(ctx, "old"); !stored.QuotaExceededAt.Equal(now.Truncate(time.Microsecond)) {
+		t.Errorf("got quota exceeded at %v, expected %v", stored.QuotaExceededAt, now)
+	}
+
+	if n, err := s.Tokens().DeleteExpired(ctx, now); err != nil || n != 1 {
+		t.Errorf("got %d, %v deleting expired tokens, expected 1", n, err)
+	}
+	if _, err := s.Tokens().Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("got %v for an expired token, expected %v", err, storage.ErrNotFound)
+	}
+
+	removed, err := s.Tokens().Remove(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.User != other {
+		t.Errorf("removed a token of %s, expected %s", removed.User.Hex(), other.Hex())
+	}
+	if _, err := s.Tokens().Remove(ctx, "other"); err != storage.ErrNotFound {
+		t.Errorf("got %v removing a removed token, expected %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.Tokens().Latest(ctx, other); err != storage.ErrNotFound {
+		t.Errorf("got %v for the latest token of a user without any, expected %v", err, storage.ErrNotFound)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+const userColumns = "id, display_name, first_name, last_name, email, password, roles, user_groups, settings, profile, gateway_id"
+
+var userSorts = map[string]string{
+	"id":           "id",
+	"display_name": "display_name",
+	"first_name":   "first_name",
+	"last_name":    "last_name",
+	"email":        "email",
+}
+
+type users struct {
+	s *Store
+}
+
+func scanUser(u *model.User) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(
+			objectID{&u.ID},
+			&u.DisplayName,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.Password,
+			jsonColumn{&u.Roles},
+			jsonColumn{&u.Groups},
+			jsonColumn{&u.Settings},
+			jsonColumn{&u.Profile},
+			&u.GatewayID,
+		)
+	}
+}
+
+func userValues(u *model.User) []interface{} {
+	return []interface{}{
+		objectID{&u.ID},
+		u.DisplayName,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		jsonColumn{&u.Roles},
+		jsonColumn{&u.Groups},
+		jsonColumn{&u.Settings},
+		jsonColumn{&u.Profile},
+		u.GatewayID,
+	}
+}
+
+func (r *users) List(ctx context.Context, page storage.Page) ([]model.User, int, error) {
+	result := []model.User{}
+	count, err := r.s.list(ctx, func(row scanner) error {
+		u := model.User{}
+		if err := scanUser(&u)(row); err != nil {
+			return err
+		}
+
+		result = append(result, u)
+		return nil
+	}, "users", userColumns, userSorts, page, "")
+
+	return result, count, err
+}
+
+func (r *users) Get(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	u := model.User{}
+	err := r.s.one(ctx, scanUser(&u), "SELECT "+userColumns+" FROM users WHERE id = ?", objectID{&id})
+
+	return u, err
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	u := model.User{}
+	err := r.s.one(ctx, scanUser(&u), "SELECT "+userColumns+" FROM users WHERE email = ?", email)
+
+	return u, err
+}
+
+func (r *users) Create(ctx context.Context, u model.User) error {
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", userValues(&u)...)
+}
+
+func (r *users) Update(ctx context.Context, id primitive.ObjectID, update model.User) error {
+	// the same fields a $set of the document would change in MongoDB
+	columns, args := []string{}, []interface{}{}
+	set := func(column string, value interface{}) {
+		columns = append(columns, column+" = ?")
+		args = append(args, value)
+	}
+
+	if len(update.DisplayName) > 0 {
+		set("display_name", update.DisplayName)
+	}
+	if len(update.FirstName) > 0 {
+		set("first_name", update.FirstName)
+	}
+	if len(update.LastName) > 0 {
+		set("last_name", update.LastName)
+	}
+	if len(update.Email) > 0 {
+		set("email", update.Email)
+	}
+	if len(update.Password) > 0 {
+		set("password", update.Password)
+	}
+	if len(update.Roles) > 0 {
+		set("roles", jsonColumn{&update.Roles})
+	}
+	if len(update.Groups) > 0 {
+		set("user_groups", jsonColumn{&update.Groups})
+	}
+	if len(update.Settings) > 0 {
+		set("settings", jsonColumn{&update.Settings})
+	}
+	if update.Profile != nil {
+		set("profile", jsonColumn{&update.Profile})
+	}
+	if len(update.GatewayID) > 0 {
+		set("gateway_id", update.GatewayID)
+	}
+
+	if len(columns) == 0 {
+		// nothing to set, the user only has to exist
+		_, err := r.Get(ctx, id)
+		return err
+	}
+
+	args = append(args, objectID{&id})
+
+	return r.s.exec(ctx, "UPDATE users SET "+strings.Join(columns, ", ")+" WHERE id = ?", args...)
+}
+
+func (r *users) SetProfile(ctx context.Context, id primitive.ObjectID, profile *model.Profile) error {
+	return r.s.exec(ctx, "UPDATE users SET profile = ? WHERE id = ?", jsonColumn{&profile}, objectID{&id})
+}
+
+func (r *users) SetGatewayID(ctx context.Context, id primitive.ObjectID, gatewayID string) error {
+	return r.s.exec(ctx, "UPDATE users SET gateway_id = ? WHERE id = ?", gatewayID, objectID{&id})
+}
+
+func (r *users) UpsertByEmail(ctx context.Context, u model.User) error {
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+
+	// an existing user keeps their id
+	update := []string{}
+	for _, column := range strings.Split(userColumns, ", ")[1:] {
+		update = append(update, column+" = excluded."+column)
+	}
+
+	return r.s.exec(ctx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"+
+		" ON CONFLICT (email) DO UPDATE SET "+strings.Join(update, ", "), userValues(&u)...)
+}
+
+func (r *users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "DELETE FROM users WHERE id = ?", objectID{&id})
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+func TestUsers(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u := model.User{
+		ID:       primitive.NewObjectID(),
+		Email:    "user@example.com",
+		Roles:    []string{model.RoleUser},
+		Settings: map[string]interface{}{"theme": "dark"},
+		Profile:  &model.Profile{Communal: map[string]interface{}{"volume": 3}},
+	}
+	if err := s.Users().Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Users().Create(ctx, u); err != storage.ErrDuplicate {
+		t.Errorf("got %v creating the same user twice, expected %v", err, storage.ErrDuplicate)
+	}
+	if err := s.Users().Create(ctx, model.User{ID: primitive.NewObjectID(), Email: u.Email}); err != storage.ErrDuplicate {
+		t.Errorf("got %v creating a user with a taken email, expected %v", err, storage.ErrDuplicate)
+	}
+
+	got, err := s.Users().GetByEmail(ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID {
+		t.Errorf("got user %s by email, expected %s", got.ID.Hex(), u.ID.Hex())
+	}
+
+	// stored as JSON, so numbers come back as float64
+	if !got.HasRole(model.RoleUser) || got.Profile.Communal["volume"] != 3.0 {
+		t.Errorf("got roles %v and profile %+v, expected those created", got.Roles, got.Profile)
+	}
+
+	// an update only sets the fields it has
+	if err := s.Users().Update(ctx, u.ID, model.User{FirstName: "First"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Users().Get(ctx, u.ID)
+	if got.FirstName != "First" || got.Email != u.Email || !got.HasRole(model.RoleUser) || got.Settings["theme"] != "dark" {
+		t.Errorf("got %+v after updating the first name", got)
+	}
+
+	if err := s.Users().Update(ctx, u.ID, model.User{}); err != nil {
+		t.Errorf("got %v for an update setting nothing", err)
+	}
+	if err := s.Users().Update(ctx, u.ID, model.User{Settings: map[string]interface{}{"theme": "light"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Users().Get(ctx, u.ID)
+	if got.Settings["theme"] != "light" || got.FirstName != "First" {
+		t.Errorf("got %+v after updating the settings", got)
+	}
+
+	other := model.User{ID: primitive.NewObjectID(), Email: "other@example.com"}
+	if err := s.Users().Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users().Update(ctx, u.ID, model.User{Email: other.Email}); err != storage.ErrDuplicate {
+		t.Errorf("got %v taking another user's email, expected %v", err, storage.ErrDuplicate)
+	}
+	if err := s.Users().Update(ctx, u.ID, model.User{Email: u.Email}); err != nil {
+		t.Errorf("got %v keeping the same email", err)
+	}
+
+	if err := s.Users().Update(ctx, primitive.NewObjectID(), model.User{}); err != storage.ErrNotFound {
+		t.Errorf("got %v updating a missing user, expected %v", err, storage.ErrNotFound)
+	}
+
+	if err := s.Users().Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users().Get(ctx, u.ID); err != storage.ErrNotFound {
+		t.Errorf("got %v for a deleted user, expected %v", err, storage.ErrNotFound)
+	}
+	if err := s.Users().Delete(ctx, u.ID); err != storage.ErrNotFound {
+		t.Errorf("got %v deleting a deleted user, expected %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestUsersList(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	created := []primitive.ObjectID{}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		u := model.User{ID: primitive.NewObjectID(), Email: email}
+		if err := s.Users().Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, u.ID)
+	}
+
+	tests := []struct {
+		name     string
+		page     storage.Page
+		expected []primitive.ObjectID
+	}{
+		{name: "everything", expected: created},
+		{name: "first page", page: storage.Page{Limit: 2}, expected: created[:2]},
+		{name: "second page", page: storage.Page{Offset: 2, Limit: 2}, expected: created[2:]},
+		{name: "past the end", page: storage.Page{Offset: 5}, expected: created[:0]},
+		{name: "newest first", page: storage.Page{Sort: "-_id", Limit: 1}, expected: created[2:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users, count, err := s.Users().List(ctx, test.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != len(created) {
+				t.Errorf("got a count of %d, expected %d", count, len(created))
+			}
+
+			if len(users) != len(test.expected) {
+				t.Fatalf("got %d users, expected %d", len(users), len(test.expected))
+			}
+			for i, u := range users {
+				if u.ID != test.expected[i] {
+					t.Errorf("got %s at %d, expected %s", u.ID.Hex(), i, test.expected[i].Hex())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+const (
+	webhookColumns  = "id, url, secret, events, active, created"
+	deliveryColumns = "id, webhook, event, payload, status, attempts, next_attempt, response_status, last_error, created, delivered"
+)
+
+var webhookSorts = map[string]string{
+	"id":      "id",
+	"url":     "url",
+	"active":  "active",
+	"created": "created",
+}
+
+var deliverySorts = map[string]string{
+	"id":           "id",
+	"event":        "event",
+	"status":       "status",
+	"attempts":     "attempts",
+	"next_attempt": "next_attempt",
+	"created":      "created",
+	"delivered":    "delivered",
+}
+
+type webhooks struct {
+	s *Store
+}
+
+func scanWebhook(h *model.Webhook) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(objectID{&h.ID}, &h.URL, &h.Secret, jsonColumn{&h.Events}, &h.Active, timestamp{&h.CreatedAt})
+	}
+}
+
+// webhookList collects every row into result
+func webhookList(result *[]model.Webhook) func(scanner) error {
+	return func(row scanner) error {
+		h := model.Webhook{}
+		if err := scanWebhook(&h)(row); err != nil {
+			return err
+		}
+
+		*result = append(*result, h)
+		return nil
+	}
+}
+
+func (r *webhooks) List(ctx context.Context, page storage.Page) ([]model.Webhook, int, error) {
+	result := []model.Webhook{}
+	count, err := r.s.list(ctx, webhookList(&result), "webhooks", webhookColumns, webhookSorts, page, "")
+
+	return result, count, err
+}
+
+func (r *webhooks) Active(ctx context.Context) ([]model.Webhook, error) {
+	result := []model.Webhook{}
+	err := r.s.all(ctx, webhookList(&result), "SELECT "+webhookColumns+" FROM webhooks WHERE active = ?", true)
+
+	return result, err
+}
+
+func (r *webhooks) Get(ctx context.Context, id primitive.ObjectID) (model.Webhook, error) {
+	h := model.Webhook{}
+	err := r.s.one(ctx, scanWebhook(&h), "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", objectID{&id})
+
+	return h, err
+}
+
+func (r *webhooks) Create(ctx context.Context, h model.Webhook) error {
+	if h.ID.IsZero() {
+		h.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO webhooks ("+webhookColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		objectID{&h.ID}, h.URL, h.Secret, jsonColumn{&h.Events}, h.Active, timestamp{&h.CreatedAt})
+}
+
+func (r *webhooks) Replace(ctx context.Context, h model.Webhook) error {
+	return r.s.exec(ctx, "UPDATE webhooks SET url = ?, secret = ?, events = ?, active = ?, created = ? WHERE id = ?",
+		h.URL, h.Secret, jsonColumn{&h.Events}, h.Active, timestamp{&h.CreatedAt}, objectID{&h.ID})
+}
+
+func (r *webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return r.s.exec(ctx, "DELETE FROM webhooks WHERE id = ?", objectID{&id})
+}
+
+type deliveries struct {
+	s *Store
+}
+
+func scanDelivery(d *model.WebhookDelivery) func(scanner) error {
+	return func(row scanner) error {
+		return row.Scan(
+			objectID{&d.ID},
+			objectID{&d.Webhook},
+			&d.Event,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			timestamp{&d.NextAttempt},
+			&d.ResponseStatus,
+			&d.LastError,
+			timestamp{&d.CreatedAt},
+			timestamp{&d.DeliveredAt},
+		)
+	}
+}
+
+// deliveryList collects every row into result
+func deliveryList(result *[]model.WebhookDelivery) func(scanner) error {
+	return func(row scanner) error {
+		d := model.WebhookDelivery{}
+		if err := scanDelivery(&d)(row); err != nil {
+			return err
+		}
+
+		*result = append(*result, d)
+		return nil
+	}
+}
+
+func (r *deliveries) List(ctx context.Context, webhook primitive.ObjectID, page storage.Page) ([]model.WebhookDelivery, int, error) {
+	result := []model.WebhookDelivery{}
+	count, err := r.s.list(ctx, deliveryList(&result), "webhook_deliveries", deliveryColumns, deliverySorts, page,
+		" WHERE webhook = ?", objectID{&webhook})
+
+	return result, count, err
+}
+
+func (r *deliveries) Get(ctx context.Context, webhook, id primitive.ObjectID) (model.WebhookDelivery, error) {
+	d := model.WebhookDelivery{}
+	err := r.s.one(ctx, scanDelivery(&d),
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ? AND webhook = ?", objectID{&id}, objectID{&webhook})
+
+	return d, err
+}
+
+func (r *deliveries) Due(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	result := []model.WebhookDelivery{}
+	err := r.s.all(ctx, deliveryList(&result),
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt LIMIT ?",
+		model.WebhookDeliveryPending, timestamp{&now}, limit)
+
+	return result, err
+}
+
+func (r *deliveries) Claim(ctx context.Context, d model.WebhookDelivery, until time.Time) error {
+	return r.s.exec(ctx, "UPDATE webhook_deliveries SET next_attempt = ? WHERE id = ? AND status = ? AND next_attempt = ?",
+		timestamp{&until}, objectID{&d.ID}, model.WebhookDeliveryPending, timestamp{&d.NextAttempt})
+}
+
+func (r *deliveries) Create(ctx context.Context, d model.WebhookDelivery) error {
+	if d.ID.IsZero() {
+		d.ID = primitive.NewObjectID()
+	}
+
+	return r.s.exec(ctx, "INSERT INTO webhook_deliveries ("+deliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		objectID{&d.ID},
+		objectID{&d.Webhook},
+		d.Event,
+		d.Payload,
+		d.Status,
+		d.Attempts,
+		timestamp{&d.NextAttempt},
+		d.ResponseStatus,
+		d.LastError,
+		timestamp{&d.CreatedAt},
+		timestamp{&d.DeliveredAt},
+	)
+}
+
+func (r *deliveries) Save(ctx context.Context, d model.WebhookDelivery) error {
+	return r.s.exec(ctx, "UPDATE webhook_deliveries SET webhook = ?, event = ?, payload = ?, status = ?, attempts = ?,"+
+		" next_attempt = ?, response_status = ?, last_error = ?, created = ?, delivered = ? WHERE id = ?",
+		objectID{&d.Webhook},
+		d.Event,
+		d.Payload,
+		d.Status,
+		d.Attempts,
+		timestamp{&d.NextAttempt},
+		d.ResponseStatus,
+		d.LastError,
+		timestamp{&d.CreatedAt},
+		timestamp{&d.DeliveredAt},
+		objectID{&d.ID},
+	)
+}
+
+func (r *deliveries) DeletePending(ctx context.Context, webhook primitive.ObjectID) error {
+	ctx, cancel := r.s.deadline(ctx)
+	defer cancel()
+
+	_, err := r.s.q.ExecContext(ctx, r.s.rebind("DELETE FROM webhook_deliveries WHERE webhook = ? AND status = ?"),
+		objectID{&webhook}, model.WebhookDeliveryPending)
+
+	return r.s.storeError(err)
+}
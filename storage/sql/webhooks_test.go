@@ -0,0 +1,84 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+func TestDeliveries(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	hook := primitive.NewObjectID()
+	deliveries := []model.WebhookDelivery{
+		{ID: primitive.NewObjectID(), Webhook: hook, Status: model.WebhookDeliveryPending, NextAttempt: now.Add(-time.Minute), CreatedAt: now},
+		{ID: primitive.NewObjectID(), Webhook: hook, Status: model.WebhookDeliveryPending, NextAttempt: now.Add(-time.Hour), CreatedAt: now},
+		{ID: primitive.NewObjectID(), Webhook: hook, Status: model.WebhookDeliveryPending, NextAttempt: now.Add(time.Hour), CreatedAt: now},
+		{ID: primitive.NewObjectID(), Webhook: hook, Status: model.WebhookDeliveryDelivered, NextAttempt: now.Add(-time.Hour), CreatedAt: now},
+	}
+	for _, d := range deliveries {
+		if err := s.Deliveries().Create(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only pending deliveries whose time has come, oldest first
+	due, err := s.Deliveries().Due(ctx, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != deliveries[1].ID || due[1].ID != deliveries[0].ID {
+		t.Fatalf("got %d due deliveries, expected the first two oldest first", len(due))
+	}
+
+	if limited, _ := s.Deliveries().Due(ctx, now, 1); len(limited) != 1 {
+		t.Errorf("got %d due deliveries with a limit of 1", len(limited))
+	}
+
+	// the first instance to claim a delivery gets it
+	if err := s.Deliveries().Claim(ctx, due[0], now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deliveries().Claim(ctx, due[0], now.Add(time.Minute)); err != storage.ErrNotFound {
+		t.Errorf("got %v claiming a claimed delivery, expected %v", err, storage.ErrNotFound)
+	}
+	if again, _ := s.Deliveries().Due(ctx, now, 10); len(again) != 1 {
+		t.Errorf("got %d due deliveries after claiming one, expected 1", len(again))
+	}
+
+	if _, err := s.Deliveries().Get(ctx, primitive.NewObjectID(), deliveries[0].ID); err != storage.ErrNotFound {
+		t.Errorf("got %v for a delivery of another webhook, expected %v", err, storage.ErrNotFound)
+	}
+
+	if err := s.Deliveries().DeletePending(ctx, hook); err != nil {
+		t.Fatal(err)
+	}
+	listed, count, err := s.Deliveries().List(ctx, hook, storage.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || listed[0].Status != model.WebhookDeliveryDelivered {
+		t.Errorf("got %d deliveries after deleting those pending, expected the delivered one", count)
+	}
+}
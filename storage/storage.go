@@ -0,0 +1,177 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage describes how the service persists its data. Handlers work
+// against these repositories so the backend can be chosen at startup.
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+)
+
+// ErrNotFound is returned when a lookup matches nothing
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a create would clash with an existing record
+var ErrDuplicate = errors.New("duplicate")
+
+// Page selects part of a listing. Sort is a field name, optionally prefixed
+// with - to reverse it.
+type Page struct {
+	Offset int
+	Limit  int
+	Sort   string
+}
+
+// Store gives access to every repository of a backend
+type Store interface {
+	Users() UserRepository
+	Tokens() TokenRepository
+	Devices() DeviceRepository
+	Keys() KeyRepository
+	Clients() ClientRepository
+	Policies() PolicyRepository
+	Webhooks() WebhookRepository
+	Deliveries() DeliveryRepository
+	Audit() AuditRepository
+
+	// Ping checks the backend is reachable
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// Migrator is implemented by backends with a schema or indexes to maintain
+type Migrator interface {
+	// Migrate applies pending migrations and returns a description of each
+	// change, on a dry run it only describes them
+	Migrate(ctx context.Context, dryRun bool) ([]string, error)
+}
+
+type UserRepository interface {
+	List(ctx context.Context, page Page) ([]model.User, int, error)
+	Get(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	GetByEmail(ctx context.Context, email string) (model.User, error)
+	Create(ctx context.Context, u model.User) error
+
+	// Update sets the fields of update that aren't empty
+	Update(ctx context.Context, id primitive.ObjectID, update model.User) error
+	SetProfile(ctx context.Context, id primitive.ObjectID, profile *model.Profile) error
+	SetGatewayID(ctx context.Context, id primitive.ObjectID, gatewayID string) error
+
+	// UpsertByEmail replaces the user with the same email, or creates it
+	UpsertByEmail(ctx context.Context, u model.User) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type TokenRepository interface {
+	All(ctx context.Context) ([]model.Token, error)
+	Get(ctx context.Context, token string) (model.Token, error)
+
+	// Latest returns the user's token that expires last
+	Latest(ctx context.Context, user primitive.ObjectID) (model.Token, error)
+	ListByUser(ctx context.Context, user primitive.ObjectID) ([]model.Token, error)
+	Pending(ctx context.Context) ([]model.Token, error)
+
+	// CountActive counts the tokens that haven't expired by now
+	CountActive(ctx context.Context, now time.Time) (int, error)
+	Create(ctx context.Context, t model.Token) error
+	ClearPending(ctx context.Context, id primitive.ObjectID) error
+	SetQuotaExceeded(ctx context.Context, token string, at time.Time) (model.Token, error)
+
+	// Remove deletes the token, returning what was removed
+	Remove(ctx context.Context, token string) (model.Token, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	// DeleteExpired removes the tokens that expired by now, returning how
+	// many there were
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+type DeviceRepository interface {
+	// List returns the devices owned by owner, or every device when owner is empty
+	List(ctx context.Context, owner primitive.ObjectID, page Page) ([]model.Device, int, error)
+	Get(ctx context.Context, id string) (model.Device, error)
+	GetByCode(ctx context.Context, code string) (model.Device, error)
+
+	// CountUnlinked counts the devices no user has linked yet
+	CountUnlinked(ctx context.Context) (int, error)
+	Create(ctx context.Context, d model.Device) error
+	Update(ctx context.Context, d model.Device) error
+	Delete(ctx context.Context, id string) error
+
+	// DeleteExpired removes the unlinked devices whose code expired by now,
+	// returning how many there were
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+type KeyRepository interface {
+	List(ctx context.Context) ([]model.Key, error)
+	Get(ctx context.Context, id primitive.ObjectID) (model.Key, error)
+	Create(ctx context.Context, k model.Key) error
+
+	// Upsert replaces the key with the same id, or creates it
+	Upsert(ctx context.Context, k model.Key) error
+}
+
+type ClientRepository interface {
+	List(ctx context.Context, page Page) ([]model.Client, int, error)
+	Get(ctx context.Context, id primitive.ObjectID) (model.Client, error)
+	Create(ctx context.Context, c model.Client) error
+	Replace(ctx context.Context, c model.Client) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type PolicyRepository interface {
+	List(ctx context.Context, page Page) ([]model.Policy, int, error)
+	All(ctx context.Context) ([]model.Policy, error)
+	Get(ctx context.Context, id primitive.ObjectID) (model.Policy, error)
+	Create(ctx context.Context, p model.Policy) error
+	Replace(ctx context.Context, p model.Policy) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type WebhookRepository interface {
+	List(ctx context.Context, page Page) ([]model.Webhook, int, error)
+	Active(ctx context.Context) ([]model.Webhook, error)
+	Get(ctx context.Context, id primitive.ObjectID) (model.Webhook, error)
+	Create(ctx context.Context, h model.Webhook) error
+	Replace(ctx context.Context, h model.Webhook) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type DeliveryRepository interface {
+	List(ctx context.Context, webhook primitive.ObjectID, page Page) ([]model.WebhookDelivery, int, error)
+	Get(ctx context.Context, webhook, id primitive.ObjectID) (model.WebhookDelivery, error)
+
+	// Due returns pending deliveries whose next attempt has passed, oldest first
+	Due(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error)
+
+	// Claim moves a due delivery's next attempt to until, failing with
+	// ErrNotFound if another instance got there first
+	Claim(ctx context.Context, d model.WebhookDelivery, until time.Time) error
+	Create(ctx context.Context, d model.WebhookDelivery) error
+	Save(ctx context.Context, d model.WebhookDelivery) error
+	DeletePending(ctx context.Context, webhook primitive.ObjectID) error
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, e model.AuditEvent) error
+}
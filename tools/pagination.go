@@ -20,26 +20,19 @@ import (
 	"strconv"
 
 	"github.com/labstack/echo"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+
+	"github.com/2-IMMERSE/auth-service/storage"
 )
 
 const maxLimit = 50
 
 type Pagination struct {
-	collection *mgo.Collection
-	query      *mgo.Query
-	count      int
+	Page storage.Page
 
-	filter bson.M
-	sort   string
-	offset int
-	limit  int
+	name string
 }
 
-func NewPagination(collection string, c echo.Context) *Pagination {
-	db := c.Get("mgo_db").(*mgo.Database)
-
+func NewPagination(name string, c echo.Context) *Pagination {
 	offset, err := strconv.Atoi(c.QueryParam("offset"))
 	if err != nil || offset < 0 {
 		offset = 0
@@ -53,50 +46,23 @@ func NewPagination(collection string, c echo.Context) *Pagination {
 	}
 
 	s := c.QueryParam("sort")
-	if len(s) == 0 || s == "id" {
-		s = "_id"
-	} else if s == "-id" {
-		s = "-_id"
+	if len(s) == 0 {
+		s = "id"
 	}
 
 	p := &Pagination{
-		collection: db.C(collection),
-		filter:     bson.M{},
-		offset:     offset,
-		limit:      limit,
-		sort:       s,
+		name: name,
+		Page: storage.Page{
+			Offset: offset,
+			Limit:  limit,
+			Sort:   s,
+		},
 	}
 
 	return p
 }
 
-func (p *Pagination) createQuery() *mgo.Query {
-	return p.collection.Find(p.filter).Sort(p.sort)
-}
-
-func (p *Pagination) AddFilter(key string, filter interface{}) {
-	p.filter[key] = filter
-}
-
-func (p *Pagination) Count() (int, error) {
-	return p.createQuery().Count()
-}
-
-func (p *Pagination) All(data interface{}) error {
-	return p.createQuery().
-		Skip(p.offset).
-		Limit(p.limit).
-		All(data)
-}
-
-func (p *Pagination) AddHeaders(headers http.Header) error {
-	count, err := p.Count()
-	if err != nil {
-		return err
-	}
-
-	headers.Set("Accept-Ranges", p.collection.Name)
-	headers.Set("Content-Range", fmt.Sprintf("%s 0-10/%d", p.collection.Name, count))
-
-	return nil
+func (p *Pagination) AddHeaders(headers http.Header, count int) {
+	headers.Set("Accept-Ranges", p.name)
+	headers.Set("Content-Range", fmt.Sprintf("%s 0-10/%d", p.name, count))
 }
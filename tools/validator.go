@@ -15,58 +15,266 @@
 package tools
 
 import (
-// 	"io/ioutil"
-// 	"path"
-// 	"path/filepath"
-// 	"strings"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 	jsschema "github.com/lestrrat-go/jsschema"
 	jsval "github.com/lestrrat-go/jsval"
 	"github.com/lestrrat-go/jsval/builder"
+
+	"github.com/2-IMMERSE/auth-service/api"
 )
 
+// ValidationError lists what is wrong with a request body, by field where
+// the problem can be pinned to one
+type ValidationError struct {
+	Message string
+	Fields  map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// schema is a compiled schema along with the validators of its top level
+// properties, used to report problems per field
+type schema struct {
+	root       *jsval.JSVal
+	required   []string
+	properties map[string]*jsval.JSVal
+	closed     bool
+}
+
 type Validator struct {
-	schemas map[string]*jsschema.Schema
+	dir string
+
+	mu      sync.RWMutex
+	schemas map[string]*schema
+	routes  map[string]string
+
+	// err is why the last reload failed, the schemas loaded before it
+	// stay in use
+	err error
 }
 
-func NewValidator(schemasDir string) *Validator {
+// NewValidator loads every schema in schemasDir, failing if any of them
+// can't be compiled
+func NewValidator(schemasDir string) (*Validator, error) {
 	v := &Validator{
-		schemas: make(map[string]*jsschema.Schema),
-	}
-
-	//logrus.Debugf("Loading validation schema...")
-	//files, _ := ioutil.ReadDir(schemasDir)
-	//for _, f := range files {
-	//	if !f.IsDir() {
-	//		logrus.Debugf("Loading %s...", f.Name())
-	//		if s, err := jsschema.ReadFile(path.Join(schemasDir, f.Name())); err != nil {
-	//			logrus.Error(err)
-	//		} else {
-	//			key := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
-	//			v.schemas[key] = s
-	//		}
-	//	}
-	//}
-	//logrus.Debugf("%+v", v.schemas)
-
-
-	return v
+		dir:     schemasDir,
+		schemas: make(map[string]*schema),
+		routes:  make(map[string]string),
+	}
+
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Reload reads and compiles every schema in the directory again. The schemas
+// in use are only replaced when all of them compile.
+func (v *Validator) Reload() (err error) {
+	logrus.Debugf("Loading validation schema...")
+
+	defer func() {
+		v.mu.Lock()
+		v.err = err
+		v.mu.Unlock()
+	}()
+
+	files, err := ioutil.ReadDir(v.dir)
+	if err != nil {
+		return fmt.Errorf("schema: %v", err)
+	}
+
+	schemas := make(map[string]*schema)
+	routes := make(map[string]string)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		if f.Name() == api.RoutesFile {
+			data, err := ioutil.ReadFile(path.Join(v.dir, f.Name()))
+			if err == nil {
+				err = json.Unmarshal(data, &routes)
+			}
+			if err != nil {
+				return fmt.Errorf("schema %s: %v", f.Name(), err)
+			}
+			continue
+		}
+
+		logrus.Debugf("Loading %s...", f.Name())
+		s, err := compile(path.Join(v.dir, f.Name()))
+		if err != nil {
+			return fmt.Errorf("schema %s: %v", f.Name(), err)
+		}
+
+		schemas[strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))] = s
+	}
+
+	for route, name := range routes {
+		if _, ok := schemas[name]; !ok {
+			return fmt.Errorf("schema %s: %s uses missing schema %s", api.RoutesFile, route, name)
+		}
+	}
+
+	v.mu.Lock()
+	v.schemas = schemas
+	v.routes = routes
+	v.mu.Unlock()
+
+	return nil
+}
+
+// Err reports why the schemas last failed to load, nil if they loaded
+func (v *Validator) Err() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.err
 }
 
-func (v *Validator) GetValidator(name string) *jsval.JSVal {
+// compile builds the validators of the schema in file
+func compile(file string) (*schema, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := build(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Required             []string                   `json:"required"`
+		Properties           map[string]json.RawMessage `json:"properties"`
+		AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	s := &schema{
+		root:       root,
+		required:   doc.Required,
+		properties: map[string]*jsval.JSVal{},
+		closed:     string(doc.AdditionalProperties) == "false",
+	}
+
+	for name, raw := range doc.Properties {
+		if s.properties[name], err = build(raw); err != nil {
+			return nil, fmt.Errorf("property %s: %v", name, err)
+		}
+	}
+
+	return s, nil
+}
+
+func build(data []byte) (*jsval.JSVal, error) {
+	s, err := jsschema.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.New().Build(s)
+}
+
+// ValidateDocument checks body against the JSON Schema in doc
+func ValidateDocument(doc []byte, body interface{}) error {
+	root, err := build(doc)
+	if err != nil {
+		return err
+	}
+
+	return root.Validate(body)
+}
+
+// Route returns the name of the schema the request bodies of a route are
+// validated against, path being the route as registered (/users/:id)
+func (v *Validator) Route(method, path string) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	name, ok := v.routes[method+" "+path]
+	return name, ok
+}
+
+// Has reports whether there is a schema called name
+func (v *Validator) Has(name string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	_, ok := v.schemas[name]
+	return ok
+}
+
+// Validate checks body, as decoded from JSON, against the schema called
+// name. Bodies are valid when there is no such schema.
+func (v *Validator) Validate(name string, body interface{}) error {
+	v.mu.RLock()
 	s, ok := v.schemas[name]
+	v.mu.RUnlock()
+
 	if !ok {
-		logrus.Errorf("schema for %s not found", name)
 		return nil
 	}
 
-	b := builder.New()
-	val, err := b.Build(s)
-	if err != nil {
-		logrus.Error(err)
+	err := s.root.Validate(body)
+	if err == nil {
 		return nil
 	}
 
-	return val
+	verr := &ValidationError{
+		Message: "Request body is invalid",
+		Fields:  s.fields(body),
+	}
+
+	if len(verr.Fields) == 0 {
+		verr.Message = fmt.Sprintf("Request body is invalid: %v", err)
+	}
+
+	return verr
+}
+
+// fields pins the problems with body to its top level fields
+func (s *schema) fields(body interface{}) map[string]string {
+	object, ok := body.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	fields := map[string]string{}
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			fields[name] = "is required"
+		}
+	}
+
+	for name, value := range object {
+		property, ok := s.properties[name]
+		if !ok {
+			if s.closed {
+				fields[name] = "is not allowed"
+			}
+			continue
+		}
+
+		if err := property.Validate(value); err != nil {
+			fields[name] = err.Error()
+		}
+	}
+
+	return fields
 }
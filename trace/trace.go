@@ -0,0 +1,194 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace carries the request id and W3C trace context of a request,
+// so calls made to the gateway while handling it can be correlated with it.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Headers the trace is read from and passed on in
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// Parent is a W3C trace context, identifying the span a request belongs to
+type Parent struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+	State   string
+}
+
+type key int
+
+const (
+	parentKey key = iota
+	requestIDKey
+)
+
+// Parse reads a traceparent header, reporting whether it was valid
+func Parse(traceparent, tracestate string) (Parent, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || parts[0] == "ff" || !isHex(parts[0], 2) {
+		return Parent{}, false
+	}
+
+	// version 00 has exactly four fields, later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return Parent{}, false
+	}
+
+	p := Parent{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		Flags:   parts[3],
+		State:   tracestate,
+	}
+
+	if !isHex(p.TraceID, 32) || !isHex(p.SpanID, 16) || !isHex(p.Flags, 2) ||
+		p.TraceID == strings.Repeat("0", 32) || p.SpanID == strings.Repeat("0", 16) {
+		return Parent{}, false
+	}
+
+	return p, true
+}
+
+// New starts a trace, for requests that didn't arrive with one
+func New() Parent {
+	return Parent{
+		TraceID: randomHex(16),
+		SpanID:  randomHex(8),
+		Flags:   "01",
+	}
+}
+
+// Child is a new span in the same trace, for a call p makes
+func (p Parent) Child() Parent {
+	p.SpanID = randomHex(8)
+	return p
+}
+
+// String is the traceparent header for p
+func (p Parent) String() string {
+	return fmt.Sprintf("00-%s-%s-%s", p.TraceID, p.SpanID, p.Flags)
+}
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p Parent) context.Context {
+	return context.WithValue(ctx, parentKey, p)
+}
+
+// FromContext returns the trace ctx carries
+func FromContext(ctx context.Context) (Parent, bool) {
+	p, ok := ctx.Value(parentKey).(Parent)
+	return p, ok
+}
+
+// WithRequestID returns a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id ctx carries, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Inject adds the request id and a child span of the trace ctx carries to
+// the headers of an outbound request
+func Inject(ctx context.Context, h http.Header) {
+	if id := RequestID(ctx); len(id) > 0 {
+		h.Set(HeaderRequestID, id)
+	}
+
+	p, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	h.Set(HeaderTraceParent, p.Child().String())
+	if len(p.State) > 0 {
+		h.Set(HeaderTraceState, p.State)
+	}
+}
+
+// Transport passes the trace of each request's context on to the server it
+// is sent to
+type Transport struct {
+	// Base makes the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// NewClient is an http.Client with a Transport. Without a transport of its
+// own it gets a copy of http.DefaultTransport, so closing its idle
+// connections leaves those of other clients alone.
+func NewClient(c http.Client) *http.Client {
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	c.Transport = &Transport{Base: c.Transport}
+	return &c
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *Transport) CloseIdleConnections() {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if c, ok := base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// round trippers mustn't modify the request they are given
+	r := req.Clone(req.Context())
+	Inject(req.Context(), r.Header)
+
+	return base.RoundTrip(r)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
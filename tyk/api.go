@@ -1,6 +0,0 @@
-package tyk
-
-type API struct {
-	ID   string `json:"api_id"`
-	Name string `json:"name"`
-}
@@ -0,0 +1,91 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tyk
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the gateway while it is
+// considered down
+var ErrCircuitOpen = errors.New("tyk: gateway unavailable, circuit open")
+
+// Breaker stops calls to the gateway after a run of failures, letting a
+// single trial call through once the cool off has passed
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooloff   time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// NewBreaker creates a breaker that opens after threshold consecutive failures
+func NewBreaker(threshold int, cooloff time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooloff:   cooloff,
+	}
+}
+
+// Allow reports whether a call may be made
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	// half open, let one call through to see if the gateway is back
+	if !b.trial && time.Since(b.openedAt) > b.cooloff {
+		b.trial = true
+		return true
+	}
+
+	return false
+}
+
+// Success closes the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed call, opening the breaker once past the threshold
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.trial = false
+	}
+}
+
+// Open reports whether calls are currently being refused
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold && !(time.Since(b.openedAt) > b.cooloff)
+}
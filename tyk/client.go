@@ -2,123 +2,274 @@ package tyk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+
+	"github.com/2-IMMERSE/auth-service/metrics"
+	"github.com/2-IMMERSE/auth-service/trace"
 )
 
 type Config struct {
 	BaseURL      string
 	Organisation string
 	Key          string
+
+	// Resolve, when set, returns the base URL of the gateway instance each
+	// call is made to in place of BaseURL
+	Resolve func() string
+
+	// Timeout for a single request, defaults to 5 seconds
+	Timeout time.Duration
+
+	// Retries is how many times idempotent requests are retried after a
+	// server or network error, defaults to 2
+	Retries int
+
+	// RetryWait is the wait before the first retry, doubling each time
+	RetryWait time.Duration
+
+	// BreakerThreshold is how many consecutive failures open the circuit
+	// breaker and BreakerCooloff how long it stays open
+	BreakerThreshold int
+	BreakerCooloff   time.Duration
 }
 
 type Client struct {
-	client *http.Client
-	config Config
+	client  *http.Client
+	config  Config
+	breaker *Breaker
 }
 
 func NewClient(config Config) *Client {
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 5
+	}
+	if config.Retries == 0 {
+		config.Retries = 2
+	}
+	if config.RetryWait == 0 {
+		config.RetryWait = time.Millisecond * 200
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = 5
+	}
+	if config.BreakerCooloff == 0 {
+		config.BreakerCooloff = time.Second * 30
+	}
+
 	return &Client{
-		config: config,
-		client: &http.Client{
-			Timeout: time.Second * 10,
-		},
+		config:  config,
+		breaker: NewBreaker(config.BreakerThreshold, config.BreakerCooloff),
+		client: trace.NewClient(http.Client{
+			Timeout: config.Timeout,
+		}),
 	}
 }
 
-func (c *Client) CreateKey(token string) error {
-	k := NewKey(c.config.Organisation)
+// Close closes the connections kept open to the gateway
+func (c *Client) Close() {
+	c.client.CloseIdleConnections()
+}
 
-	rights, err := c.GetAccessRights()
+func (c *Client) baseURL() string {
+	if c.config.Resolve != nil {
+		return c.config.Resolve()
+	}
+
+	return c.config.BaseURL
+}
+
+// Available reports whether the circuit breaker will let calls through
+func (c *Client) Available() bool {
+	return !c.breaker.Open()
+}
+
+func (c *Client) CreateKey(ctx context.Context, token string, k Key) error {
+	b, err := json.Marshal(k)
 	if err != nil {
 		return err
 	}
-	k.AccessRights = rights
-
-	b := new(bytes.Buffer)
-	json.NewEncoder(b).Encode(k)
 
-	url := fmt.Sprintf("%s/tyk/keys/%s", c.config.BaseURL, token)
-	logrus.Debugf("post keys url: %s", url)
-	req, err := http.NewRequest("POST", url, b)
+	url := fmt.Sprintf("%s/tyk/keys/%s", c.baseURL(), token)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("x-tyk-authorization", c.config.Key)
 	req.Header.Add("Content-Type", "application/json")
 
-	dump, err := httputil.DumpRequestOut(req, true)
-
-	logrus.Debugf("post request: %q", dump)
+	return c.do(ctx, "create_key", req, nil)
+}
 
-	res, err := c.client.Do(req)
+func (c *Client) DeleteKey(ctx context.Context, token string) error {
+	url := fmt.Sprintf("%s/tyk/keys/%s", c.baseURL(), token)
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 
-	defer res.Body.Close()
+	return c.do(ctx, "delete_key", req, nil)
+}
 
-	logrus.Debugf("post keys response status: %s", strconv.Itoa(res.StatusCode))
+// Ping asks the gateway whether it is up
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/hello", c.baseURL()), nil)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return c.do(ctx, "hello", req, nil)
 }
 
-func (c *Client) GetAccessRights() (map[string]AccessRight, error) {
-	url := fmt.Sprintf("%s/tyk/apis/", c.config.BaseURL)
-	req, err := http.NewRequest("GET", url, nil)
+// ListKeys returns the id of every key on the gateway
+func (c *Client) ListKeys(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/tyk/keys/", c.baseURL()), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("x-tyk-authorization", c.config.Key)
+	result := struct {
+		Keys []string `json:"keys"`
+	}{}
+	if err := c.do(ctx, "list_keys", req, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Keys, nil
+}
 
-	res, err := c.client.Do(req)
+// GetKey looks up a key's session
+func (c *Client) GetKey(ctx context.Context, token string) (*Key, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/tyk/keys/%s", c.baseURL(), token), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	k := &Key{}
+	if err := c.do(ctx, "get_key", req, k); err != nil {
+		return nil, err
+	}
 
-	logrus.Debugf("get apis response status: %s", strconv.Itoa(res.StatusCode))
+	return k, nil
+}
 
-	var apis []API
-	err = json.NewDecoder(res.Body).Decode(&apis)
-	if err != nil {
-		logrus.Debugf(err.Error())
+// do sends the request for operation as part of ctx, retrying idempotent
+// requests on server or network errors, and decodes the response into v.
+// The operation names the call in the metrics, as paths hold tokens.
+func (c *Client) do(ctx context.Context, operation string, req *http.Request, v interface{}) (err error) {
+	start := time.Now()
+	defer func() { metrics.Tyk.Observe(operation, start, err) }()
+
+	req = req.WithContext(ctx)
+	req.Header.Set("x-tyk-authorization", c.config.Key)
+
+	attempts := 1
+	if idempotent(req.Method) {
+		attempts += c.config.Retries
 	}
 
-	rights := make(map[string]AccessRight)
-	for _, api := range apis {
-		rights[api.ID] = AccessRight{
-			ID:       api.ID,
-			Name:     api.Name,
-			Versions: []string{"Default"},
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(c.config.RetryWait << uint(i-1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			logrus.Debugf("Tyk: retrying %s %s: %v", req.Method, redact(req.URL.Path), err)
+
+			if req.GetBody != nil {
+				body, berr := req.GetBody()
+				if berr != nil {
+					return berr
+				}
+				req.Body = body
+			}
+		}
+
+		err = c.try(req, v)
+		if !retryable(err) {
+			return err
 		}
-		logrus.Debugf("rights: %s, %s", rights[api.ID].ID, rights[api.ID].Name)
 	}
 
-	return rights, nil
+	return err
 }
 
-func (c *Client) DeleteKey(token string) error {
-	url := fmt.Sprintf("%s/tyk/keys/%s", c.config.BaseURL, token)
-	req, err := http.NewRequest("DELETE", url, nil)
+func (c *Client) try(req *http.Request, v interface{}) error {
+	if !c.breaker.Allow() {
+		return ErrCircuitOpen
+	}
+
+	res, err := c.client.Do(req)
 	if err != nil {
+		c.breaker.Failure()
+		if e, ok := err.(*url.Error); ok {
+			e.URL = req.URL.Scheme + "://" + req.URL.Host + redact(req.URL.Path)
+		}
 		return err
 	}
+	defer res.Body.Close()
 
-	req.Header.Add("x-tyk-authorization", c.config.Key)
+	logrus.Debugf("Tyk: %s %s response status: %s", req.Method, redact(req.URL.Path), strconv.Itoa(res.StatusCode))
 
-	if _, err := c.client.Do(req); err != nil {
+	if err := CheckResponse(res); err != nil {
+		if res.StatusCode >= 500 {
+			c.breaker.Failure()
+		} else {
+			c.breaker.Success()
+		}
 		return err
 	}
 
+	c.breaker.Success()
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("tyk: invalid response to %s %s: %v", req.Method, redact(req.URL.Path), err)
+	}
+
 	return nil
 }
+
+// redact masks the key in the path of key requests, keys being the tokens
+// users authenticate with
+func redact(path string) string {
+	if i := strings.Index(path, "/keys/"); i >= 0 {
+		return path[:i] + "/keys/****"
+	}
+
+	return path
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *Error:
+		return e.StatusCode >= 500
+	case *url.Error:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tyk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Error is returned when Tyk responds with anything other than success. The
+// gateway and dashboard both describe errors with a status and message.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("tyk: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("tyk: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound checks if err is Tyk reporting that something doesn't exist
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// CheckResponse returns an *Error for any non 2xx response
+func CheckResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+
+	e := &Error{
+		StatusCode: res.StatusCode,
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	json.Unmarshal(body, e)
+
+	return e
+}
+
+// IsUnavailable checks if err means the gateway couldn't be reached or
+// couldn't handle the request, rather than rejecting it
+func IsUnavailable(err error) bool {
+	switch e := err.(type) {
+	case *Error:
+		return e.StatusCode >= 500
+	case *url.Error:
+		return true
+	}
+
+	return err == ErrCircuitOpen
+}
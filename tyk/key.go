@@ -4,7 +4,7 @@ type Key struct {
 	Allowance        int                    `json:"allowance"`
 	Rate             int                    `json:"rate"`
 	Per              int                    `json:"per"`
-	Expires          int                    `json:"expires"`
+	Expires          int64                  `json:"expires"`
 	QuotaMax         int                    `json:"quota_max"`
 	QuotaRenews      int                    `json:"quota_renews"`
 	QuotaRemaining   int                    `json:"quota_remaining"`
@@ -12,19 +12,31 @@ type Key struct {
 	Organisation     string                 `json:"org_id"`
 	IsInactive       bool                   `json:"is_inactive"`
 	AccessRights     map[string]AccessRight `json:"access_rights"`
+	ApplyPolicies    []string               `json:"apply_policies,omitempty"`
+	MetaData         map[string]interface{} `json:"meta_data,omitempty"`
 }
 
-func NewKey(org string) Key {
-	// the access rights will be hard coded here for a bit until we have a more dynamic way of handling this
+// Limits are the rate limit and quota given to a key. A QuotaMax of -1 is
+// no quota.
+type Limits struct {
+	Rate             int
+	Per              int
+	QuotaMax         int
+	QuotaRenewalRate int
+}
+
+// NewKey creates a key with the given limits, these are used for tokens whose
+// policies don't set any
+func NewKey(org string, l Limits) Key {
 	return Key{
-		Allowance:        1000,
-		Rate:             1000,
-		Per:              60,
+		Allowance:        l.Rate,
+		Rate:             l.Rate,
+		Per:              l.Per,
 		Expires:          -1,
-		QuotaMax:         -1,
+		QuotaMax:         l.QuotaMax,
 		QuotaRenews:      86400,
-		QuotaRemaining:   0,
-		QuotaRenewalRate: 60,
+		QuotaRemaining:   l.QuotaMax,
+		QuotaRenewalRate: l.QuotaRenewalRate,
 		Organisation:     org,
 		IsInactive:       false,
 	}
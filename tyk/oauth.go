@@ -0,0 +1,116 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tyk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OAuthClient is a client registered against an OAuth enabled API
+type OAuthClient struct {
+	ClientID    string            `json:"client_id,omitempty"`
+	Secret      string            `json:"secret,omitempty"`
+	RedirectURI string            `json:"redirect_uri"`
+	APIID       string            `json:"api_id,omitempty"`
+	PolicyID    string            `json:"policy_id,omitempty"`
+	MetaData    map[string]string `json:"meta_data,omitempty"`
+}
+
+// Authorization is the gateway's response to authorizing a client
+type Authorization struct {
+	Code       string `json:"code"`
+	RedirectTo string `json:"redirect_to"`
+}
+
+// CreateOAuthClient registers a new client against an API
+func (c *Client) CreateOAuthClient(ctx context.Context, client OAuthClient) (*OAuthClient, error) {
+	b := new(bytes.Buffer)
+	if err := json.NewEncoder(b).Encode(client); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/tyk/oauth/clients/create", c.baseURL()), b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	result := &OAuthClient{}
+	if err := c.do(ctx, "create_oauth_client", req, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetOAuthClient looks up a client of an API
+func (c *Client) GetOAuthClient(ctx context.Context, apiID, clientID string) (*OAuthClient, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/tyk/oauth/clients/%s/%s", c.baseURL(), apiID, clientID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OAuthClient{}
+	if err := c.do(ctx, "get_oauth_client", req, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DeleteOAuthClient removes a client of an API
+func (c *Client) DeleteOAuthClient(ctx context.Context, apiID, clientID string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/tyk/oauth/clients/%s/%s", c.baseURL(), apiID, clientID), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, "delete_oauth_client", req, nil)
+}
+
+// AuthorizeClient asks the API at listenPath to issue an authorization code
+// to the client, keyRules is the session that tokens will be created with
+func (c *Client) AuthorizeClient(ctx context.Context, listenPath, clientID, redirectURI string, keyRules interface{}) (*Authorization, error) {
+	rules, err := json.Marshal(keyRules)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Add("response_type", "code")
+	data.Add("client_id", clientID)
+	data.Add("redirect_uri", redirectURI)
+	data.Add("key_rules", string(rules))
+
+	path := strings.Trim(listenPath, "/")
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/tyk/oauth/authorize-client/", c.baseURL(), path), strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	result := &Authorization{}
+	if err := c.do(ctx, "authorize_client", req, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
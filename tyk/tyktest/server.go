@@ -0,0 +1,214 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tyktest provides an in-memory stand-in for the parts of the Tyk
+// gateway and dashboard APIs the service talks to, for use in tests.
+package tyktest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Server answers both gateway (/tyk/...) and dashboard (/api/...) requests
+type Server struct {
+	*httptest.Server
+
+	// Secret is expected in the x-tyk-authorization header of gateway calls
+	Secret string
+	// DashboardKey is expected in the Authorization header of dashboard calls
+	DashboardKey string
+
+	mu      sync.Mutex
+	next    int
+	Keys    map[string]json.RawMessage
+	Clients map[string]map[string]interface{}
+	Users   map[string]map[string]interface{}
+
+	// HashKeys lists keys by their hash, as a gateway with hash_keys does
+	HashKeys bool
+}
+
+// NewServer starts a stand-in accepting the given credentials
+func NewServer(secret, dashboardKey string) *Server {
+	s := &Server{
+		Secret:       secret,
+		DashboardKey: dashboardKey,
+		Keys:         make(map[string]json.RawMessage),
+		Clients:      make(map[string]map[string]interface{}),
+		Users:        make(map[string]map[string]interface{}),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+func (s *Server) id(prefix string) string {
+	s.next++
+	return fmt.Sprintf("%s%d", prefix, s.next)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		if r.Header.Get("Authorization") != s.DashboardKey {
+			dashboardError(w, http.StatusUnauthorized, "Not authorised")
+			return
+		}
+		s.serveDashboard(w, r)
+		return
+	}
+
+	if r.Header.Get("x-tyk-authorization") != s.Secret {
+		gatewayError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == "tyk" && parts[1] == "keys" && r.Method == "GET":
+		keys := []string{}
+		for k := range s.Keys {
+			if s.HashKeys {
+				k = fmt.Sprintf("%x", sha256.Sum256([]byte(k)))
+			}
+			keys = append(keys, k)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	case len(parts) == 3 && parts[0] == "tyk" && parts[1] == "keys":
+		s.serveKey(w, r, parts[2])
+	case len(parts) == 4 && parts[0] == "tyk" && parts[1] == "oauth" && parts[3] == "create":
+		c := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&c)
+		c["client_id"] = s.id("client")
+		c["secret"] = s.id("secret")
+		s.Clients[c["client_id"].(string)] = c
+		json.NewEncoder(w).Encode(c)
+	case len(parts) == 5 && parts[0] == "tyk" && parts[1] == "oauth" && parts[2] == "clients":
+		c, ok := s.Clients[parts[4]]
+		if !ok || c["api_id"] != parts[3] {
+			gatewayError(w, http.StatusNotFound, "OAuth Client ID not found")
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(s.Clients, parts[4])
+			json.NewEncoder(w).Encode(map[string]string{"key": parts[4], "status": "ok", "action": "deleted"})
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+	case len(parts) >= 3 && strings.HasSuffix(r.URL.Path, "/tyk/oauth/authorize-client/"):
+		r.ParseForm()
+		c, ok := s.Clients[r.PostForm.Get("client_id")]
+		if !ok || c["redirect_uri"] != r.PostForm.Get("redirect_uri") {
+			gatewayError(w, http.StatusForbidden, "Client ID and redirect URI do not match")
+			return
+		}
+		code := s.id("code")
+		json.NewEncoder(w).Encode(map[string]string{
+			"code":        code,
+			"redirect_to": fmt.Sprintf("%s?code=%s", r.PostForm.Get("redirect_uri"), code),
+		})
+	default:
+		gatewayError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) serveKey(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case "POST", "PUT":
+		k := json.RawMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+			gatewayError(w, http.StatusBadRequest, "Request malformed")
+			return
+		}
+		s.Keys[key] = k
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "status": "ok", "action": "added"})
+	case "GET":
+		k, ok := s.Keys[key]
+		if !ok {
+			gatewayError(w, http.StatusNotFound, "Key not found")
+			return
+		}
+		w.Write(k)
+	case "DELETE":
+		if _, ok := s.Keys[key]; !ok {
+			gatewayError(w, http.StatusNotFound, "Key not found")
+			return
+		}
+		delete(s.Keys, key)
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "status": "ok", "action": "deleted"})
+	default:
+		gatewayError(w, http.StatusMethodNotAllowed, "Method not supported")
+	}
+}
+
+func (s *Server) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/users"), "/")
+
+	switch {
+	case r.Method == "POST" && len(id) == 0:
+		u := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			dashboardError(w, http.StatusBadRequest, "Could not decode body")
+			return
+		}
+		for _, existing := range s.Users {
+			if existing["email_address"] == u["email_address"] {
+				dashboardError(w, http.StatusBadRequest, "User email already exists for this Org")
+				return
+			}
+		}
+		u["id"] = s.id("user")
+		delete(u, "password")
+		s.Users[u["id"].(string)] = u
+		json.NewEncoder(w).Encode(map[string]interface{}{"Status": "OK", "Message": "User created", "Meta": u})
+	case r.Method == "GET" && len(id) > 0:
+		u, ok := s.Users[id]
+		if !ok {
+			dashboardError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		json.NewEncoder(w).Encode(u)
+	case r.Method == "DELETE" && len(id) > 0:
+		if _, ok := s.Users[id]; !ok {
+			dashboardError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		delete(s.Users, id)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Status": "OK", "Message": "User deleted", "Meta": nil})
+	default:
+		dashboardError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func gatewayError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": message})
+}
+
+func dashboardError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Status": "Error", "Message": message, "Meta": nil})
+}
@@ -20,14 +20,24 @@ import (
 	"github.com/Sirupsen/logrus"
 )
 
+// logFormatter formats logs as JSON, so the request id and other fields can
+// be searched on, or as text for reading at a terminal
+func logFormatter(format string) logrus.Formatter {
+	if format == "text" {
+		return &logrus.TextFormatter{}
+	}
+
+	return &logrus.JSONFormatter{}
+}
+
 func setMaxProcs() {
 	var numProcs int
 
-	if *maxProcs < 1 {
+	if cfg.Procs < 1 {
 		numProcs = runtime.NumCPU()
 		logrus.Debugf("Setting max procs to %d", numProcs)
 	} else {
-		numProcs = *maxProcs
+		numProcs = cfg.Procs
 	}
 
 	runtime.GOMAXPROCS(numProcs)
@@ -0,0 +1,219 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+const (
+	maxAttempts  = 10
+	batchSize    = 20
+	pollInterval = 2 * time.Second
+	baseDelay    = 10 * time.Second
+	maxDelay     = 6 * time.Hour
+	leaseTime    = time.Minute
+)
+
+// Dispatcher works through the queue of pending deliveries, posting each one
+// to its webhook and rescheduling failures with an exponential backoff.
+type Dispatcher struct {
+	store  storage.Store
+	client *http.Client
+}
+
+// NewDispatcher creates a dispatcher working against the given store
+func NewDispatcher(s storage.Store) *Dispatcher {
+	return &Dispatcher{
+		store: s,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+}
+
+// Sign returns the HMAC-SHA256 signature of the payload as sent in the
+// X-2Immerse-Signature header
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run polls for due deliveries until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	logrus.Debug("Webhook: dispatcher started")
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Debug("Webhook: dispatcher stopped")
+			return
+		case <-ticker.C:
+			if err := d.dispatch(ctx); err != nil {
+				logrus.Errorf("Webhook: %v", err)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	deliveries := d.store.Deliveries()
+
+	due, err := deliveries.Due(ctx, time.Now(), batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range due {
+		// claim the delivery so other instances leave it alone while we send
+		// it, the lease starting now as the ones before may have been slow
+		err := deliveries.Claim(ctx, delivery, time.Now().Add(leaseTime))
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := deliveries.Save(ctx, d.deliver(ctx, delivery)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver sends the delivery and returns it updated with the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery model.WebhookDelivery) model.WebhookDelivery {
+	h, err := d.store.Webhooks().Get(ctx, delivery.Webhook)
+	if err == storage.ErrNotFound || (err == nil && !h.Active) {
+		delivery.Attempts++
+		delivery.Status = model.WebhookDeliveryFailed
+		delivery.LastError = "webhook removed or disabled"
+		return delivery
+	} else if err != nil {
+		// nothing was sent, so it isn't an attempt
+		logrus.Errorf("Webhook: unable to look up webhook of delivery %s: %v", delivery.ID.Hex(), err)
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = time.Now().Add(baseDelay)
+		return delivery
+	}
+
+	delivery.Attempts++
+	status, err := d.send(ctx, h, delivery)
+	delivery.ResponseStatus = status
+
+	if err == nil {
+		logrus.Debugf("Webhook: delivered %s to %s", delivery.Event, h.URL)
+		delivery.Status = model.WebhookDeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.LastError = ""
+		return delivery
+	}
+
+	logrus.Infof("Webhook: delivery %s to %s failed: %v", delivery.ID.Hex(), h.URL, err)
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = model.WebhookDeliveryFailed
+	} else {
+		delivery.NextAttempt = time.Now().Add(Backoff(delivery.Attempts))
+	}
+
+	return delivery
+}
+
+func (d *Dispatcher) send(ctx context.Context, h model.Webhook, delivery model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "2immerse-auth-service")
+	req.Header.Set("X-2Immerse-Event", delivery.Event)
+	req.Header.Set("X-2Immerse-Delivery", delivery.ID.Hex())
+	if len(h.Secret) > 0 {
+		req.Header.Set("X-2Immerse-Signature", Sign(h.Secret, delivery.Payload))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Backoff returns how long to wait before the next attempt, doubling after
+// every failure up to a maximum
+func Backoff(attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+
+	return delay
+}
+
+// Redeliver queues a copy of an existing delivery to be sent straight away,
+// the original is kept as part of the delivery history
+func Redeliver(ctx context.Context, s storage.Store, delivery model.WebhookDelivery) (*model.WebhookDelivery, error) {
+	now := time.Now()
+	d := &model.WebhookDelivery{
+		ID:          primitive.NewObjectID(),
+		Webhook:     delivery.Webhook,
+		Event:       delivery.Event,
+		Payload:     delivery.Payload,
+		Status:      model.WebhookDeliveryPending,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+
+	if err := s.Deliveries().Create(ctx, *d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
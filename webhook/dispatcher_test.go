@@ -0,0 +1,165 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+	"github.com/2-IMMERSE/auth-service/storage/memory"
+)
+
+// brokenWebhooks fails every lookup, as a store that has gone away does
+type brokenWebhooks struct {
+	storage.WebhookRepository
+}
+
+func (brokenWebhooks) Get(ctx context.Context, id primitive.ObjectID) (model.Webhook, error) {
+	return model.Webhook{}, errors.New("connection lost")
+}
+
+type brokenStore struct {
+	*memory.Store
+}
+
+func (s brokenStore) Webhooks() storage.WebhookRepository {
+	return brokenWebhooks{s.Store.Webhooks()}
+}
+
+func TestDeliver(t *testing.T) {
+	received := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer target.Close()
+
+	store := memory.New()
+	ctx := context.Background()
+
+	active := model.Webhook{ID: primitive.NewObjectID(), URL: target.URL, Active: true}
+	inactive := model.Webhook{ID: primitive.NewObjectID(), URL: target.URL}
+	for _, h := range []model.Webhook{active, inactive} {
+		if err := store.Webhooks().Create(ctx, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		store   storage.Store
+		webhook primitive.ObjectID
+
+		status   string
+		attempts int
+		received int
+	}{
+		{name: "delivered", store: store, webhook: active.ID, status: model.WebhookDeliveryDelivered, attempts: 1, received: 1},
+		{name: "webhook removed", store: store, webhook: primitive.NewObjectID(), status: model.WebhookDeliveryFailed, attempts: 1},
+		{name: "webhook disabled", store: store, webhook: inactive.ID, status: model.WebhookDeliveryFailed, attempts: 1},
+		{name: "storage failing", store: brokenStore{store}, webhook: active.ID, status: model.WebhookDeliveryPending},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = 0
+			now := time.Now()
+
+			d := NewDispatcher(test.store).deliver(ctx, model.WebhookDelivery{
+				ID:          primitive.NewObjectID(),
+				Webhook:     test.webhook,
+				Status:      model.WebhookDeliveryPending,
+				NextAttempt: now,
+			})
+
+			if d.Status != test.status || d.Attempts != test.attempts || received != test.received {
+				t.Errorf("got %s after %d attempts with %d received, expected %s after %d with %d",
+					d.Status, d.Attempts, received, test.status, test.attempts, test.received)
+			}
+			if d.Status == model.WebhookDeliveryPending && !d.NextAttempt.After(now) {
+				t.Error("a delivery still pending wasn't rescheduled")
+			}
+		})
+	}
+}
+
+// leases records how long each claim leases a delivery for, from when it
+// is made
+type leases struct {
+	storage.DeliveryRepository
+	leased []time.Duration
+}
+
+func (l *leases) Claim(ctx context.Context, d model.WebhookDelivery, until time.Time) error {
+	l.leased = append(l.leased, time.Until(until))
+	return l.DeliveryRepository.Claim(ctx, d, until)
+}
+
+type leasingStore struct {
+	*memory.Store
+	leases *leases
+}
+
+func (s leasingStore) Deliveries() storage.DeliveryRepository {
+	return s.leases
+}
+
+func TestDispatchSlowSends(t *testing.T) {
+	const slow = 100 * time.Millisecond
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(slow)
+	}))
+	defer target.Close()
+
+	store := memory.New()
+	ctx := context.Background()
+
+	h := model.Webhook{ID: primitive.NewObjectID(), URL: target.URL, Active: true}
+	if err := store.Webhooks().Create(ctx, h); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.Deliveries().Create(ctx, model.WebhookDelivery{
+			ID:          primitive.NewObjectID(),
+			Webhook:     h.ID,
+			Status:      model.WebhookDeliveryPending,
+			NextAttempt: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := &leases{DeliveryRepository: store.Deliveries()}
+	if err := NewDispatcher(leasingStore{store, l}).dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.leased) != 3 {
+		t.Fatalf("got %d claims, expected 3", len(l.leased))
+	}
+	for i, leased := range l.leased {
+		if leased < leaseTime-slow/2 {
+			t.Errorf("delivery %d was leased for %s once claimed, expected %s", i, leased, leaseTime)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Cisco and/or its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/2-IMMERSE/auth-service/model"
+	"github.com/2-IMMERSE/auth-service/storage"
+)
+
+// Lifecycle events that webhooks can subscribe to
+const (
+	EventUserCreated      = "user.created"
+	EventUserUpdated      = "user.updated"
+	EventUserDeleted      = "user.deleted"
+	EventTokenCreated     = "token.created"
+	EventTokenRevoked     = "token.revoked"
+	EventDeviceRegistered = "device.registered"
+	EventDeviceLinked     = "device.linked"
+	EventDeviceUnlinked   = "device.unlinked"
+)
+
+// Events lists every event a webhook can subscribe to
+var Events = []string{
+	EventUserCreated,
+	EventUserUpdated,
+	EventUserDeleted,
+	EventTokenCreated,
+	EventTokenRevoked,
+	EventDeviceRegistered,
+	EventDeviceLinked,
+	EventDeviceUnlinked,
+}
+
+// Event is the envelope that is posted to every webhook
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// IsEvent checks if name is a known event or a wildcard filter
+func IsEvent(name string) bool {
+	if name == "*" {
+		return true
+	}
+
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+
+		if strings.HasSuffix(name, ".*") && strings.HasPrefix(e, strings.TrimSuffix(name, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enqueue stores a delivery for every active webhook subscribed to the event
+func Enqueue(ctx context.Context, s storage.Store, event string, data interface{}) error {
+	hooks, err := s.Webhooks().Active(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	payload, err := json.Marshal(Event{
+		ID:        id.Hex(),
+		Type:      event,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if !h.Subscribes(event) {
+			continue
+		}
+
+		d := model.WebhookDelivery{
+			ID:          primitive.NewObjectID(),
+			Webhook:     h.ID,
+			Event:       event,
+			Payload:     payload,
+			Status:      model.WebhookDeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+
+		if err := s.Deliveries().Create(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Publish queues an event from within a request. Failing to queue an event
+// should never fail the request so errors are only logged.
+func Publish(c echo.Context, event string, data interface{}) {
+	s, ok := c.Get("storage").(storage.Store)
+	if !ok {
+		return
+	}
+
+	if err := Enqueue(c.Request().Context(), s, event, data); err != nil {
+		logrus.Errorf("Webhook: unable to queue %s: %v", event, err)
+	}
+}